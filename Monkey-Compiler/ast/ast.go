@@ -12,6 +12,8 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	// Pos returns the position of the node in the source.
+	Pos() token.Position
 }
 
 // Statement represents a statement.
@@ -26,6 +28,13 @@ type Expression interface {
 	expressionNode()
 }
 
+// Pattern represents a target of a binding: an identifier, or an array or a hash pattern which
+// destructures the value. Patterns are expressions, so that they can be assigned to.
+type Pattern interface {
+	Expression
+	patternNode()
+}
+
 // Program is a top-level AST node of a program.
 type Program struct {
 	Statements []Statement
@@ -39,6 +48,14 @@ func (p *Program) TokenLiteral() string {
 	return p.Statements[0].TokenLiteral()
 }
 
+// Pos returns the position of the first statement of a program.
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) == 0 {
+		return token.Position{}
+	}
+	return p.Statements[0].Pos()
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -49,25 +66,75 @@ func (p *Program) String() string {
 	return out.String()
 }
 
-// LetStatement represents a let statement.
+// Binding is the way the variables in a pattern are bound.
+type Binding int
+
+const (
+	// AssignBinding assigns to the variables, and defines the ones which are not defined yet.
+	AssignBinding Binding = iota
+	// LetBinding defines new variables.
+	LetBinding
+	// ConstBinding defines new constants, which cannot be assigned to.
+	ConstBinding
+)
+
+// LetStatement represents a let statement, or a const statement whose bindings cannot be
+// assigned to.
 type LetStatement struct {
-	Token token.Token // the token.LET token
+	Token token.Token // the token.LET or token.CONST token
 	Name  *Ident
-	Value Expression
+	// Pattern is set instead of Name if the statement destructures the value, e.g.
+	// `let [a, b] = xs;`.
+	Pattern Pattern
+	Value   Expression
+	// Doc is the text of the `##` doc comment directly before the statement, if any.
+	Doc string
+	// Exported reports whether the statement is preceded by `export`, which makes the bindings
+	// of a module available to the programs importing it.
+	Exported bool
 }
 
 func (ls *LetStatement) statementNode() {}
 
+// IsConst reports whether the statement is a const statement.
+func (ls *LetStatement) IsConst() bool {
+	return ls.Token.Type == token.CONST
+}
+
+// Binding returns the way the statement binds its variables.
+func (ls *LetStatement) Binding() Binding {
+	if ls.IsConst() {
+		return ConstBinding
+	}
+	return LetBinding
+}
+
+// Target returns the name or the pattern the statement binds.
+func (ls *LetStatement) Target() Pattern {
+	if ls.Pattern != nil {
+		return ls.Pattern
+	}
+	return ls.Name
+}
+
 // TokenLiteral returns a token literal of let statement.
 func (ls *LetStatement) TokenLiteral() string {
 	return ls.Token.Literal
 }
 
+// Pos returns the position of let statement.
+func (ls *LetStatement) Pos() token.Position {
+	return ls.Token.Pos
+}
+
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
+	if ls.Exported {
+		out.WriteString("export ")
+	}
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Name.String())
+	out.WriteString(ls.Target().String())
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -79,12 +146,64 @@ func (ls *LetStatement) String() string {
 	return out.String()
 }
 
-// AssignStatement represents an assignment statement.
+// PatternIdents returns the identifiers bound by `pattern` in order.
+func PatternIdents(pattern Pattern) []*Ident {
+	switch pattern := pattern.(type) {
+	case *Ident:
+		return []*Ident{pattern}
+	case *ArrayPattern:
+		idents := make([]*Ident, 0, len(pattern.Elements)+1)
+		for _, elem := range pattern.Elements {
+			idents = append(idents, PatternIdents(elem)...)
+		}
+		if pattern.Rest != nil {
+			idents = append(idents, pattern.Rest)
+		}
+		return idents
+	case *HashPattern:
+		return pattern.Keys
+	default:
+		return nil
+	}
+}
+
+// ImportStatement represents an import of a module, e.g. `import "lib/strings.monkey" as str;`.
+type ImportStatement struct {
+	Token token.Token // the token.IMPORT token
+	Path  *StringLiteral
+	// Name is the identifier the module is bound to.
+	Name *Ident
+}
+
+func (is *ImportStatement) statementNode() {}
+
+// TokenLiteral returns a token literal of import statement.
+func (is *ImportStatement) TokenLiteral() string {
+	return is.Token.Literal
+}
+
+// Pos returns the position of import statement.
+func (is *ImportStatement) Pos() token.Position {
+	return is.Token.Pos
+}
+
+func (is *ImportStatement) String() string {
+	return fmt.Sprintf("%s %q as %s;", is.TokenLiteral(), is.Path.Value, is.Name)
+}
+
+// AssignStatement represents an assignment statement. Besides `=`, the token can be a compound
+// assignment operator such as `+=`, or `++` or `--`, in which case RHS is nil.
 type AssignStatement struct {
-	Token    token.Token // token.ASSIGN
+	Token    token.Token // token.ASSIGN, token.PLUS_ASSIGN, token.INC, ...
 	LHS, RHS Expression
 }
 
+// IsCompound reports whether the statement updates the current value of the left-hand side
+// instead of replacing it.
+func (as *AssignStatement) IsCompound() bool {
+	return as.Token.Type != token.ASSIGN
+}
+
 func (as *AssignStatement) statementNode() {}
 
 // TokenLiteral returns a token literal of assignment statement.
@@ -92,17 +211,23 @@ func (as *AssignStatement) TokenLiteral() string {
 	return as.Token.Literal
 }
 
+// Pos returns the position of assignment statement.
+func (as *AssignStatement) Pos() token.Position {
+	return as.Token.Pos
+}
+
 func (as *AssignStatement) String() string {
 	var out strings.Builder
 
 	// Left-hand side expression
 	out.WriteString(as.LHS.String())
 
-	// Assignment symbol (equal sign)
-	out.WriteString(" = ")
-
-	// Right-hand side expression
-	if as.RHS != nil {
+	if as.RHS == nil {
+		// Increment or decrement operator
+		out.WriteString(as.Token.Literal)
+	} else {
+		// Assignment operator and right-hand side expression
+		out.WriteString(" " + as.Token.Literal + " ")
 		out.WriteString(as.RHS.String())
 	}
 
@@ -118,16 +243,84 @@ type Ident struct {
 }
 
 func (i *Ident) expressionNode() {}
+func (i *Ident) patternNode()    {}
 
 // TokenLiteral returns a token literal of an identifier.
 func (i *Ident) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+// Pos returns the position of an identifier.
+func (i *Ident) Pos() token.Position {
+	return i.Token.Pos
+}
+
 func (i *Ident) String() string {
 	return i.Value
 }
 
+// ArrayPattern represents an array destructuring pattern, e.g. `[a, [b, c], ...rest]`.
+type ArrayPattern struct {
+	Token    token.Token // the '[' token
+	Elements []Pattern
+	// Rest is the identifier after `...` which is bound to the remaining elements, if any.
+	Rest *Ident
+}
+
+func (ap *ArrayPattern) expressionNode() {}
+func (ap *ArrayPattern) patternNode()    {}
+
+// TokenLiteral returns a token literal of array pattern.
+func (ap *ArrayPattern) TokenLiteral() string {
+	return ap.Token.Literal
+}
+
+// Pos returns the position of array pattern.
+func (ap *ArrayPattern) Pos() token.Position {
+	return ap.Token.Pos
+}
+
+func (ap *ArrayPattern) String() string {
+	elements := make([]string, 0, len(ap.Elements)+1)
+	for _, el := range ap.Elements {
+		elements = append(elements, el.String())
+	}
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	}
+
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// HashPattern represents a hash destructuring pattern, e.g. `{name, age}`. Each identifier is
+// bound to the value under the key of the same name.
+type HashPattern struct {
+	Token token.Token // the '{' token
+	Keys  []*Ident
+}
+
+func (hp *HashPattern) expressionNode() {}
+func (hp *HashPattern) patternNode()    {}
+
+// TokenLiteral returns a token literal of hash pattern.
+func (hp *HashPattern) TokenLiteral() string {
+	return hp.Token.Literal
+}
+
+// Pos returns the position of hash pattern.
+func (hp *HashPattern) Pos() token.Position {
+	return hp.Token.Pos
+}
+
+func (hp *HashPattern) String() string {
+	keys := make([]string, 0, len(hp.Keys))
+	for _, key := range hp.Keys {
+		keys = append(keys, key.String())
+	}
+
+	return "{" + strings.Join(keys, ", ") + "}"
+}
+
 // ReturnStatement represents a return statement.
 type ReturnStatement struct {
 	Token       token.Token // the token.RETURN token
@@ -141,6 +334,11 @@ func (rs *ReturnStatement) TokenLiteral() string {
 	return rs.Token.Literal
 }
 
+// Pos returns the position of return statement.
+func (rs *ReturnStatement) Pos() token.Position {
+	return rs.Token.Pos
+}
+
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -155,6 +353,227 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// WhileStatement represents a while loop.
+type WhileStatement struct {
+	Token     token.Token // the token.WHILE token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode() {}
+
+// TokenLiteral returns a token literal of while statement.
+func (ws *WhileStatement) TokenLiteral() string {
+	return ws.Token.Literal
+}
+
+// Pos returns the position of while statement.
+func (ws *WhileStatement) Pos() token.Position {
+	return ws.Token.Pos
+}
+
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(ws.Body.String())
+
+	return out.String()
+}
+
+// ForStatement represents a C-style for loop. Init, Condition and Update may be nil.
+type ForStatement struct {
+	Token     token.Token // the token.FOR token
+	Init      Statement
+	Condition Expression
+	Update    Statement
+	Body      *BlockStatement
+}
+
+func (fs *ForStatement) statementNode() {}
+
+// TokenLiteral returns a token literal of for statement.
+func (fs *ForStatement) TokenLiteral() string {
+	return fs.Token.Literal
+}
+
+// Pos returns the position of for statement.
+func (fs *ForStatement) Pos() token.Position {
+	return fs.Token.Pos
+}
+
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	if fs.Init != nil {
+		out.WriteString(strings.TrimSuffix(fs.Init.String(), ";"))
+	}
+	out.WriteString("; ")
+	if fs.Condition != nil {
+		out.WriteString(fs.Condition.String())
+	}
+	out.WriteString("; ")
+	if fs.Update != nil {
+		out.WriteString(strings.TrimSuffix(fs.Update.String(), ";"))
+	}
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+
+	return out.String()
+}
+
+// ForInStatement represents a loop over the elements of an array, the characters of a string
+// or the pairs of a hash, e.g. `for (k, v in hash) { ... }`. In the form with one variable, Key
+// is nil and Value is bound to array elements, string characters or hash keys.
+type ForInStatement struct {
+	Token    token.Token // the token.FOR token
+	Key      *Ident
+	Value    *Ident
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForInStatement) statementNode() {}
+
+// TokenLiteral returns a token literal of for-in statement.
+func (fs *ForInStatement) TokenLiteral() string {
+	return fs.Token.Literal
+}
+
+// Pos returns the position of for-in statement.
+func (fs *ForInStatement) Pos() token.Position {
+	return fs.Token.Pos
+}
+
+func (fs *ForInStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	if fs.Key != nil {
+		out.WriteString(fs.Key.String())
+		out.WriteString(", ")
+	}
+	out.WriteString(fs.Value.String())
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+
+	return out.String()
+}
+
+// BreakStatement represents a break statement.
+type BreakStatement struct {
+	Token token.Token // the token.BREAK token
+}
+
+func (bs *BreakStatement) statementNode() {}
+
+// TokenLiteral returns a token literal of break statement.
+func (bs *BreakStatement) TokenLiteral() string {
+	return bs.Token.Literal
+}
+
+// Pos returns the position of break statement.
+func (bs *BreakStatement) Pos() token.Position {
+	return bs.Token.Pos
+}
+
+func (bs *BreakStatement) String() string {
+	return bs.TokenLiteral() + ";"
+}
+
+// ContinueStatement represents a continue statement.
+type ContinueStatement struct {
+	Token token.Token // the token.CONTINUE token
+}
+
+func (cs *ContinueStatement) statementNode() {}
+
+// TokenLiteral returns a token literal of continue statement.
+func (cs *ContinueStatement) TokenLiteral() string {
+	return cs.Token.Literal
+}
+
+// Pos returns the position of continue statement.
+func (cs *ContinueStatement) Pos() token.Position {
+	return cs.Token.Pos
+}
+
+func (cs *ContinueStatement) String() string {
+	return cs.TokenLiteral() + ";"
+}
+
+// ThrowStatement represents a throw statement, which raises its value as an exception.
+type ThrowStatement struct {
+	Token token.Token // the token.THROW token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode() {}
+
+// TokenLiteral returns a token literal of throw statement.
+func (ts *ThrowStatement) TokenLiteral() string {
+	return ts.Token.Literal
+}
+
+// Pos returns the position of throw statement.
+func (ts *ThrowStatement) Pos() token.Position {
+	return ts.Token.Pos
+}
+
+func (ts *ThrowStatement) String() string {
+	return ts.TokenLiteral() + " " + ts.Value.String() + ";"
+}
+
+// TryStatement represents a try statement. Either Catch or Finally may be nil, and so may
+// Param if the catch clause does not bind the exception.
+type TryStatement struct {
+	Token   token.Token // the token.TRY token
+	Body    *BlockStatement
+	Param   *Ident
+	Catch   *BlockStatement
+	Finally *BlockStatement
+}
+
+func (ts *TryStatement) statementNode() {}
+
+// TokenLiteral returns a token literal of try statement.
+func (ts *TryStatement) TokenLiteral() string {
+	return ts.Token.Literal
+}
+
+// Pos returns the position of try statement.
+func (ts *TryStatement) Pos() token.Position {
+	return ts.Token.Pos
+}
+
+func (ts *TryStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(ts.Body.String())
+
+	if ts.Catch != nil {
+		out.WriteString(" catch")
+		if ts.Param != nil {
+			out.WriteString("(" + ts.Param.String() + ")")
+		}
+		out.WriteString(" ")
+		out.WriteString(ts.Catch.String())
+	}
+
+	if ts.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(ts.Finally.String())
+	}
+
+	return out.String()
+}
+
 // ExpressionStatement represents an expression statement.
 type ExpressionStatement struct {
 	Token      token.Token // the first token of the expression
@@ -168,6 +587,11 @@ func (es *ExpressionStatement) TokenLiteral() string {
 	return es.Token.Literal
 }
 
+// Pos returns the position of expression statement.
+func (es *ExpressionStatement) Pos() token.Position {
+	return es.Token.Pos
+}
+
 func (es *ExpressionStatement) String() string {
 	if es.Expression == nil {
 		return ""
@@ -188,6 +612,11 @@ func (il *IntegerLiteral) TokenLiteral() string {
 	return il.Token.Literal
 }
 
+// Pos returns the position of integer.
+func (il *IntegerLiteral) Pos() token.Position {
+	return il.Token.Pos
+}
+
 func (il *IntegerLiteral) String() string {
 	return il.Token.Literal
 }
@@ -205,6 +634,11 @@ func (fl *FloatLiteral) TokenLiteral() string {
 	return fl.Token.Literal
 }
 
+// Pos returns the position of floating point number.
+func (fl *FloatLiteral) Pos() token.Position {
+	return fl.Token.Pos
+}
+
 func (fl *FloatLiteral) String() string {
 	return fl.Token.Literal
 }
@@ -223,6 +657,11 @@ func (pe *PrefixExpression) TokenLiteral() string {
 	return pe.Token.Literal
 }
 
+// Pos returns the position of the operator token.
+func (pe *PrefixExpression) Pos() token.Position {
+	return pe.Token.Pos
+}
+
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -249,6 +688,11 @@ func (ie *InfixExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
+// Pos returns the position of the operator token.
+func (ie *InfixExpression) Pos() token.Position {
+	return ie.Token.Pos
+}
+
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -274,6 +718,11 @@ func (b *Boolean) TokenLiteral() string {
 	return b.Token.Literal
 }
 
+// Pos returns the position of boolean value.
+func (b *Boolean) Pos() token.Position {
+	return b.Token.Pos
+}
+
 func (b *Boolean) String() string {
 	return b.TokenLiteral()
 }
@@ -290,6 +739,11 @@ func (n *Nil) TokenLiteral() string {
 	return n.Token.Literal
 }
 
+// Pos returns the position of nil value.
+func (n *Nil) Pos() token.Position {
+	return n.Token.Pos
+}
+
 func (n *Nil) String() string {
 	return n.TokenLiteral()
 }
@@ -309,6 +763,11 @@ func (ie *IfExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
+// Pos returns the position of if expression.
+func (ie *IfExpression) Pos() token.Position {
+	return ie.Token.Pos
+}
+
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -338,6 +797,11 @@ func (bs *BlockStatement) TokenLiteral() string {
 	return bs.Token.Literal
 }
 
+// Pos returns the position of block statement.
+func (bs *BlockStatement) Pos() token.Position {
+	return bs.Token.Pos
+}
+
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -351,9 +815,13 @@ func (bs *BlockStatement) String() string {
 // FunctionLiteral represents a fuction literal.
 type FunctionLiteral struct {
 	Token      token.Token
-	Parameters []*Ident
-	Body       *BlockStatement
-	Name       string
+	Parameters []Pattern
+	// Defaults are the default values of the last len(Defaults) parameters.
+	Defaults []Expression
+	// Rest is the parameter after `...` which is bound to the remaining arguments, if any.
+	Rest *Ident
+	Body *BlockStatement
+	Name string
 }
 
 func (fl *FunctionLiteral) expressionNode() {}
@@ -363,31 +831,98 @@ func (fl *FunctionLiteral) TokenLiteral() string {
 	return fl.Token.Literal
 }
 
+// Pos returns the position of function.
+func (fl *FunctionLiteral) Pos() token.Position {
+	return fl.Token.Pos
+}
+
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
-	params := make([]string, 0, len(fl.Parameters))
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
-	}
-
 	out.WriteString(fl.TokenLiteral())
 	if fl.Name != "" {
 		out.WriteString(fmt.Sprintf("<%s>", fl.Name))
 	}
 	out.WriteString("(")
-	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(ParametersString(fl.Parameters, fl.Defaults, fl.Rest))
 	out.WriteString(") ")
 	out.WriteString(fl.Body.String())
 
 	return out.String()
 }
 
+// ParametersString returns the string representation of function parameters, e.g.
+// `x, y = 10, ...rest`.
+func ParametersString(params []Pattern, defaults []Expression, rest *Ident) string {
+	firstDefault := len(params) - len(defaults)
+
+	strs := make([]string, 0, len(params)+1)
+	for i, p := range params {
+		if i >= firstDefault {
+			strs = append(strs, p.String()+" = "+defaults[i-firstDefault].String())
+		} else {
+			strs = append(strs, p.String())
+		}
+	}
+	if rest != nil {
+		strs = append(strs, "..."+rest.String())
+	}
+
+	return strings.Join(strs, ", ")
+}
+
+// MemberExpression represents an access to a member of a hash, e.g. `person.name`. It is sugar
+// for indexing the hash with the name of the member as a string.
+type MemberExpression struct {
+	Token  token.Token // the '.' or '?.' token
+	Object Expression
+	Member *Ident
+	// Grouped reports whether the expression is in parentheses, which end the chain it is a
+	// link of, e.g. `(a?.b)` in `(a?.b).c`.
+	Grouped bool
+}
+
+func (*MemberExpression) expressionNode() {}
+
+// Optional reports whether the member access is optional, i.e. `person?.name`, which results in
+// nil if the object is nil.
+func (me *MemberExpression) Optional() bool {
+	return me.Token.Type == token.OPTIONAL_DOT
+}
+
+// TokenLiteral returns a token literal of member access.
+func (me *MemberExpression) TokenLiteral() string {
+	return me.Token.Literal
+}
+
+// Pos returns the position of member access.
+func (me *MemberExpression) Pos() token.Position {
+	return me.Token.Pos
+}
+
+func (me *MemberExpression) String() string {
+	return "(" + me.Object.String() + me.Token.Literal + me.Member.String() + ")"
+}
+
+// IndexExpression returns the index expression the member access is sugar for, e.g.
+// `person["name"]` for `person.name` and `person?["name"]` for `person?.name`.
+func (me *MemberExpression) IndexExpression() *IndexExpression {
+	return &IndexExpression{
+		Token:   me.Token,
+		Left:    me.Object,
+		Index:   &StringLiteral{Token: me.Member.Token, Value: me.Member.Value},
+		Grouped: me.Grouped,
+	}
+}
+
 // CallExpression represents a function call expression.
 type CallExpression struct {
 	Token     token.Token // the '(' token
 	Function  Expression  // Ident or FunctionLiteral
 	Arguments []Expression
+	// Grouped reports whether the expression is in parentheses, which end the chain it is a
+	// link of, e.g. `(a?.b)` in `(a?.b).c`.
+	Grouped bool
 }
 
 func (ce *CallExpression) expressionNode() {}
@@ -397,6 +932,11 @@ func (ce *CallExpression) TokenLiteral() string {
 	return ce.Token.Literal
 }
 
+// Pos returns the position of function call expression.
+func (ce *CallExpression) Pos() token.Position {
+	return ce.Token.Pos
+}
+
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -429,10 +969,64 @@ func (sl *StringLiteral) TokenLiteral() string {
 	return sl.Token.Literal
 }
 
+// Pos returns the position of string.
+func (sl *StringLiteral) Pos() token.Position {
+	if sl == nil {
+		return token.Position{}
+	}
+	return sl.Token.Pos
+}
+
 func (sl *StringLiteral) String() string {
 	return sl.TokenLiteral()
 }
 
+// StringConversion represents the conversion of a value to a string. The parser generates it
+// for the expressions embedded in an interpolated string.
+type StringConversion struct {
+	Token token.Token // the token the embedded expression starts with
+	Value Expression
+}
+
+func (sc *StringConversion) expressionNode() {}
+
+// TokenLiteral returns a token literal of string conversion.
+func (sc *StringConversion) TokenLiteral() string {
+	return sc.Token.Literal
+}
+
+// Pos returns the position of string conversion.
+func (sc *StringConversion) Pos() token.Position {
+	return sc.Token.Pos
+}
+
+func (sc *StringConversion) String() string {
+	return "${" + sc.Value.String() + "}"
+}
+
+// Spread represents an expression whose elements are spread into an array literal or the
+// arguments of a call, e.g. `...xs`.
+type Spread struct {
+	Token token.Token // the '...' token
+	Value Expression
+}
+
+func (s *Spread) expressionNode() {}
+
+// TokenLiteral returns a token literal of spread.
+func (s *Spread) TokenLiteral() string {
+	return s.Token.Literal
+}
+
+// Pos returns the position of spread.
+func (s *Spread) Pos() token.Position {
+	return s.Token.Pos
+}
+
+func (s *Spread) String() string {
+	return "..." + s.Value.String()
+}
+
 // ArrayLiteral represents an array literal.
 type ArrayLiteral struct {
 	Token    token.Token // the '[' token
@@ -449,6 +1043,14 @@ func (al *ArrayLiteral) TokenLiteral() string {
 	return al.Token.Literal
 }
 
+// Pos returns the position of array.
+func (al *ArrayLiteral) Pos() token.Position {
+	if al == nil {
+		return token.Position{}
+	}
+	return al.Token.Pos
+}
+
 func (al *ArrayLiteral) String() string {
 	if al == nil {
 		return ""
@@ -470,14 +1072,23 @@ func (al *ArrayLiteral) String() string {
 
 // IndexExpression represents an expression in array index operator.
 type IndexExpression struct {
-	Token token.Token // the '[' token
+	Token token.Token // the '[' or '?[' token
 	Left  Expression
 	Index Expression
+	// Grouped reports whether the expression is in parentheses, which end the chain it is a
+	// link of, e.g. `(a?.b)` in `(a?.b).c`.
+	Grouped bool
 }
 
 func (*IndexExpression) expressionNode() {}
 
-// TokenLiteral returns a token literal of array.
+// Optional reports whether the index expression is optional, i.e. `xs?[0]`, which results in nil
+// if the indexed value is nil.
+func (ie *IndexExpression) Optional() bool {
+	return ie.Token.Type == token.OPTIONAL_LBRACKET || ie.Token.Type == token.OPTIONAL_DOT
+}
+
+// TokenLiteral returns a token literal of index expression.
 func (ie *IndexExpression) TokenLiteral() string {
 	if ie == nil {
 		return ""
@@ -485,6 +1096,14 @@ func (ie *IndexExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
+// Pos returns the position of index expression.
+func (ie *IndexExpression) Pos() token.Position {
+	if ie == nil {
+		return token.Position{}
+	}
+	return ie.Token.Pos
+}
+
 func (ie *IndexExpression) String() string {
 	if ie == nil {
 		return ""
@@ -494,6 +1113,9 @@ func (ie *IndexExpression) String() string {
 
 	out.WriteString("(")
 	out.WriteString(ie.Left.String())
+	if ie.Optional() {
+		out.WriteString("?")
+	}
 	out.WriteString("[")
 	out.WriteString(ie.Index.String())
 	out.WriteString("])")
@@ -501,6 +1123,75 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// IsGrouped reports whether `expr` is a link of a chain of index, member, slice and call
+// expressions which is in parentheses, so that it is not a part of the chain of the link it is
+// the object of.
+func IsGrouped(expr Expression) bool {
+	switch expr := expr.(type) {
+	case *IndexExpression:
+		return expr.Grouped
+	case *MemberExpression:
+		return expr.Grouped
+	case *SliceExpression:
+		return expr.Grouped
+	case *CallExpression:
+		return expr.Grouped
+	default:
+		return false
+	}
+}
+
+// SliceExpression represents a slice expression, e.g. `xs[1:3]`.
+type SliceExpression struct {
+	Token token.Token // the '[' or '?[' token
+	Left  Expression
+	// Start and End are nil if they are omitted, e.g. `xs[:3]` and `xs[1:]`.
+	Start Expression
+	End   Expression
+	// Grouped reports whether the expression is in parentheses, which end the chain it is a
+	// link of, e.g. `(a?.b)` in `(a?.b).c`.
+	Grouped bool
+}
+
+func (*SliceExpression) expressionNode() {}
+
+// Optional reports whether the slice expression is optional, i.e. `xs?[1:]`, which results in
+// nil if the sliced value is nil.
+func (se *SliceExpression) Optional() bool {
+	return se.Token.Type == token.OPTIONAL_LBRACKET
+}
+
+// TokenLiteral returns a token literal of slice.
+func (se *SliceExpression) TokenLiteral() string {
+	return se.Token.Literal
+}
+
+// Pos returns the position of slice.
+func (se *SliceExpression) Pos() token.Position {
+	return se.Token.Pos
+}
+
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	if se.Optional() {
+		out.WriteString("?")
+	}
+	out.WriteString("[")
+	if se.Start != nil {
+		out.WriteString(se.Start.String())
+	}
+	out.WriteString(":")
+	if se.End != nil {
+		out.WriteString(se.End.String())
+	}
+	out.WriteString("])")
+
+	return out.String()
+}
+
 // HashLiteral represents a hash literal.
 type HashLiteral struct {
 	Token token.Token // the '{' token
@@ -517,6 +1208,14 @@ func (hl *HashLiteral) TokenLiteral() string {
 	return hl.Token.Literal
 }
 
+// Pos returns the position of hash.
+func (hl *HashLiteral) Pos() token.Position {
+	if hl == nil {
+		return token.Position{}
+	}
+	return hl.Token.Pos
+}
+
 func (hl *HashLiteral) String() string {
 	if hl == nil {
 		return ""
@@ -543,11 +1242,16 @@ type MacroLiteral struct {
 
 func (ml *MacroLiteral) expressionNode() {}
 
-// TokenLiteral returns a token literal of function.
+// TokenLiteral returns a token literal of macro literal.
 func (ml *MacroLiteral) TokenLiteral() string {
 	return ml.Token.Literal
 }
 
+// Pos returns the position of macro literal.
+func (ml *MacroLiteral) Pos() token.Position {
+	return ml.Token.Pos
+}
+
 func (ml *MacroLiteral) String() string {
 	var out bytes.Buffer
 
@@ -17,9 +17,21 @@ func Modify(node Node, modifier ModifierFunc) Node {
 		node.Right = Modify(node.Right, modifier).(Expression)
 	case *PrefixExpression:
 		node.Right = Modify(node.Right, modifier).(Expression)
+	case *StringConversion:
+		node.Value = Modify(node.Value, modifier).(Expression)
 	case *IndexExpression:
 		node.Left = Modify(node.Left, modifier).(Expression)
 		node.Index = Modify(node.Index, modifier).(Expression)
+	case *MemberExpression:
+		node.Object = Modify(node.Object, modifier).(Expression)
+	case *SliceExpression:
+		node.Left = Modify(node.Left, modifier).(Expression)
+		if node.Start != nil {
+			node.Start = Modify(node.Start, modifier).(Expression)
+		}
+		if node.End != nil {
+			node.End = Modify(node.End, modifier).(Expression)
+		}
 	case *IfExpression:
 		node.Condition = Modify(node.Condition, modifier).(Expression)
 		node.Consequence = Modify(node.Consequence, modifier).(*BlockStatement)
@@ -32,13 +44,50 @@ func Modify(node Node, modifier ModifierFunc) Node {
 		}
 	case *ReturnStatement:
 		node.ReturnValue = Modify(node.ReturnValue, modifier).(Expression)
+	case *ThrowStatement:
+		node.Value = Modify(node.Value, modifier).(Expression)
+	case *TryStatement:
+		node.Body = Modify(node.Body, modifier).(*BlockStatement)
+		if node.Catch != nil {
+			node.Catch = Modify(node.Catch, modifier).(*BlockStatement)
+		}
+		if node.Finally != nil {
+			node.Finally = Modify(node.Finally, modifier).(*BlockStatement)
+		}
 	case *LetStatement:
 		node.Value = Modify(node.Value, modifier).(Expression)
+	case *AssignStatement:
+		node.LHS = Modify(node.LHS, modifier).(Expression)
+		if node.RHS != nil {
+			node.RHS = Modify(node.RHS, modifier).(Expression)
+		}
+	case *WhileStatement:
+		node.Condition = Modify(node.Condition, modifier).(Expression)
+		node.Body = Modify(node.Body, modifier).(*BlockStatement)
+	case *ForStatement:
+		if node.Init != nil {
+			node.Init = Modify(node.Init, modifier).(Statement)
+		}
+		if node.Condition != nil {
+			node.Condition = Modify(node.Condition, modifier).(Expression)
+		}
+		if node.Update != nil {
+			node.Update = Modify(node.Update, modifier).(Statement)
+		}
+		node.Body = Modify(node.Body, modifier).(*BlockStatement)
+	case *ForInStatement:
+		node.Iterable = Modify(node.Iterable, modifier).(Expression)
+		node.Body = Modify(node.Body, modifier).(*BlockStatement)
 	case *FunctionLiteral:
 		for i, param := range node.Parameters {
-			node.Parameters[i] = Modify(param, modifier).(*Ident)
+			node.Parameters[i] = Modify(param, modifier).(Pattern)
+		}
+		for i, def := range node.Defaults {
+			node.Defaults[i] = Modify(def, modifier).(Expression)
 		}
 		node.Body = Modify(node.Body, modifier).(*BlockStatement)
+	case *Spread:
+		node.Value = Modify(node.Value, modifier).(Expression)
 	case *ArrayLiteral:
 		for i, elem := range node.Elements {
 			node.Elements[i] = Modify(elem, modifier).(Expression)
@@ -83,15 +83,37 @@ func TestModify(t *testing.T) {
 			input: &LetStatement{Value: one()},
 			want:  &LetStatement{Value: two()},
 		},
+		{
+			input: &ThrowStatement{Value: one()},
+			want:  &ThrowStatement{Value: two()},
+		},
+		{
+			input: &TryStatement{
+				Body: &BlockStatement{
+					Statements: []Statement{&ExpressionStatement{Expression: one()}},
+				},
+				Finally: &BlockStatement{
+					Statements: []Statement{&ExpressionStatement{Expression: one()}},
+				},
+			},
+			want: &TryStatement{
+				Body: &BlockStatement{
+					Statements: []Statement{&ExpressionStatement{Expression: two()}},
+				},
+				Finally: &BlockStatement{
+					Statements: []Statement{&ExpressionStatement{Expression: two()}},
+				},
+			},
+		},
 		{
 			input: &FunctionLiteral{
-				Parameters: []*Ident{},
+				Parameters: []Pattern{},
 				Body: &BlockStatement{
 					Statements: []Statement{&ExpressionStatement{Expression: one()}},
 				},
 			},
 			want: &FunctionLiteral{
-				Parameters: []*Ident{},
+				Parameters: []Pattern{},
 				Body: &BlockStatement{
 					Statements: []Statement{&ExpressionStatement{Expression: two()}},
 				},
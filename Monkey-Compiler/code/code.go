@@ -4,6 +4,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"strings"
+
+	"monkey-compiler/token"
 )
 
 // Opcode represents an opcode.
@@ -35,10 +37,18 @@ const (
 	// OpGreaterThanOrEqual is an opcode to check the second topmost element is greater than or
 	// equal to the first.
 	OpGreaterThanOrEqual
-	// OpAnd is an opcode for logical AND.
-	OpAnd
-	// OpOr is an opcode for logical OR.
-	OpOr
+	// OpJumpNotTruthyOrPop is an opcode to jump if the topmost element on the stack is not
+	// truthy, leaving it on the stack, or to pop it otherwise. It implements `&&`.
+	OpJumpNotTruthyOrPop
+	// OpJumpTruthyOrPop is an opcode to jump if the topmost element on the stack is truthy,
+	// leaving it on the stack, or to pop it otherwise. It implements `||`.
+	OpJumpTruthyOrPop
+	// OpJumpNotNilOrPop is an opcode to jump if the topmost element on the stack is not nil,
+	// leaving it on the stack, or to pop it otherwise. It implements `??`.
+	OpJumpNotNilOrPop
+	// OpJumpNil is an opcode to jump if the topmost element on the stack is nil, leaving it on
+	// the stack. It skips the rest of an optional chain, e.g. `a?.b`.
+	OpJumpNil
 	// OpMinus is an opcode to negate integers.
 	OpMinus
 	// OpBang is an opcode to negate booleans.
@@ -80,6 +90,71 @@ const (
 	OpGetFree
 	// OpCurrentClosure is an opcode to self-reference the current closure.
 	OpCurrentClosure
+	// OpToString is an opcode to convert the topmost element on the stack to a string.
+	OpToString
+	// OpIter is an opcode to replace the topmost element on the stack with an iterator over it.
+	OpIter
+	// OpIterNext is an opcode to advance the iterator on top of the stack and push the next
+	// element, or to pop the iterator and jump if there are no more elements.
+	OpIterNext
+	// OpMod is an opcode for remainder (%).
+	OpMod
+	// OpDup is an opcode to push copies of the given number of topmost elements on the stack.
+	OpDup
+	// OpFloorDiv is an opcode for integer (floor) division (//).
+	OpFloorDiv
+	// OpPow is an opcode for exponentiation (**).
+	OpPow
+	// OpBitAnd is an opcode for bitwise AND (&).
+	OpBitAnd
+	// OpBitOr is an opcode for bitwise OR (|).
+	OpBitOr
+	// OpBitXor is an opcode for bitwise XOR (^).
+	OpBitXor
+	// OpShiftLeft is an opcode for left shift (<<).
+	OpShiftLeft
+	// OpShiftRight is an opcode for arithmetic right shift (>>).
+	OpShiftRight
+	// OpBitNot is an opcode to invert the bits of integers.
+	OpBitNot
+	// OpUnpackArray is an opcode to replace the array on top of the stack with its elements,
+	// the first one on top, followed by an array of the remaining elements if it has a rest.
+	OpUnpackArray
+	// OpUnpackHash is an opcode to replace the hash and the keys on top of the stack with the
+	// values of the keys, the value of the first key on top.
+	OpUnpackHash
+	// OpJumpIfPassed is an opcode to jump if the argument for the parameter at the index was
+	// passed to the current function. It skips the default value of the parameter.
+	OpJumpIfPassed
+	// OpExtendArray is an opcode to append the elements of the array on top of the stack to the
+	// array below it.
+	OpExtendArray
+	// OpCallSpread is an opcode to call a function with the elements of the array on top of the
+	// stack as the arguments.
+	OpCallSpread
+	// OpSlice is an opcode to slice an array or a string with the start and the end indices on
+	// top of the stack, either of which may be nil.
+	OpSlice
+	// OpGetMethod is an opcode to replace the hash on top of the stack with its member named by
+	// the constant, bound to the hash if the member is a function.
+	OpGetMethod
+	// OpTry is an opcode to push an exception handler which catches the exceptions raised until
+	// it is popped, by jumping to the position with the exception on top of the stack.
+	OpTry
+	// OpEndTry is an opcode to pop the innermost exception handler.
+	OpEndTry
+	// OpThrow is an opcode to raise the topmost element on the stack as an exception.
+	OpThrow
+	// OpAssignLocal is an opcode to assign to a local binding, through its cell if the binding is
+	// captured by closures. OpSetLocal creates a fresh binding instead.
+	OpAssignLocal
+	// OpSetFree is an opcode to assign to a free variable through its cell.
+	OpSetFree
+	// OpCaptureLocal is an opcode to push the cell of a local binding captured by a closure,
+	// moving the value of the binding into a new cell unless it is captured already.
+	OpCaptureLocal
+	// OpCaptureFree is an opcode to push the cell of a free variable captured by a closure.
+	OpCaptureFree
 )
 
 // Definition represents the definition of an opcode.
@@ -101,8 +176,10 @@ var definitions = map[Opcode]*Definition{
 	OpNotEqual:           {Name: "OpNotEqual", OperandWidths: nil},
 	OpGreaterThan:        {Name: "OpGreaterThan", OperandWidths: nil},
 	OpGreaterThanOrEqual: {Name: "OpGreaterThanOrEqual", OperandWidths: nil},
-	OpAnd:                {Name: "OpAnd", OperandWidths: nil},
-	OpOr:                 {Name: "OpOr", OperandWidths: nil},
+	OpJumpNotTruthyOrPop: {Name: "OpJumpNotTruthyOrPop", OperandWidths: []int{2}},
+	OpJumpTruthyOrPop:    {Name: "OpJumpTruthyOrPop", OperandWidths: []int{2}},
+	OpJumpNotNilOrPop:    {Name: "OpJumpNotNilOrPop", OperandWidths: []int{2}},
+	OpJumpNil:            {Name: "OpJumpNil", OperandWidths: []int{2}},
 	OpMinus:              {Name: "OpMinus", OperandWidths: nil},
 	OpBang:               {Name: "OpBang", OperandWidths: nil},
 	OpJumpNotTruthy:      {Name: "OpJumpNotTruthy", OperandWidths: []int{2}},
@@ -123,6 +200,33 @@ var definitions = map[Opcode]*Definition{
 	OpClosure:            {Name: "OpClosure", OperandWidths: []int{2, 1}},
 	OpGetFree:            {Name: "OpGetFree", OperandWidths: []int{1}},
 	OpCurrentClosure:     {Name: "OpCurrentClosure", OperandWidths: nil},
+	OpToString:           {Name: "OpToString", OperandWidths: nil},
+	OpIter:               {Name: "OpIter", OperandWidths: nil},
+	OpIterNext:           {Name: "OpIterNext", OperandWidths: []int{2, 1}},
+	OpMod:                {Name: "OpMod", OperandWidths: nil},
+	OpDup:                {Name: "OpDup", OperandWidths: []int{1}},
+	OpFloorDiv:           {Name: "OpFloorDiv", OperandWidths: nil},
+	OpPow:                {Name: "OpPow", OperandWidths: nil},
+	OpBitAnd:             {Name: "OpBitAnd", OperandWidths: nil},
+	OpBitOr:              {Name: "OpBitOr", OperandWidths: nil},
+	OpBitXor:             {Name: "OpBitXor", OperandWidths: nil},
+	OpShiftLeft:          {Name: "OpShiftLeft", OperandWidths: nil},
+	OpShiftRight:         {Name: "OpShiftRight", OperandWidths: nil},
+	OpBitNot:             {Name: "OpBitNot", OperandWidths: nil},
+	OpUnpackArray:        {Name: "OpUnpackArray", OperandWidths: []int{1, 1}},
+	OpUnpackHash:         {Name: "OpUnpackHash", OperandWidths: []int{1}},
+	OpJumpIfPassed:       {Name: "OpJumpIfPassed", OperandWidths: []int{2, 1}},
+	OpExtendArray:        {Name: "OpExtendArray", OperandWidths: nil},
+	OpCallSpread:         {Name: "OpCallSpread", OperandWidths: nil},
+	OpSlice:              {Name: "OpSlice", OperandWidths: nil},
+	OpGetMethod:          {Name: "OpGetMethod", OperandWidths: []int{2}},
+	OpTry:                {Name: "OpTry", OperandWidths: []int{2}},
+	OpEndTry:             {Name: "OpEndTry", OperandWidths: nil},
+	OpThrow:              {Name: "OpThrow", OperandWidths: nil},
+	OpAssignLocal:        {Name: "OpAssignLocal", OperandWidths: []int{1}},
+	OpSetFree:            {Name: "OpSetFree", OperandWidths: []int{1}},
+	OpCaptureLocal:       {Name: "OpCaptureLocal", OperandWidths: []int{1}},
+	OpCaptureFree:        {Name: "OpCaptureFree", OperandWidths: []int{1}},
 }
 
 // Lookup performs a lookup for `op` in the definitions of opcodes.
@@ -177,6 +281,20 @@ func (insns Instructions) formatInstruction(def *Definition, operands []int) str
 	return fmt.Sprintf("ERROR: unhandled operand width for %s: %d", def.Name, operandCount)
 }
 
+// SourceMap maps the starting positions of instructions to positions in the source code.
+type SourceMap map[int]token.Position
+
+// Lookup returns the source position of the instruction which contains the byte at `ip`.
+// If no position is recorded for the instruction, it returns an invalid position.
+func (sm SourceMap) Lookup(ip int) token.Position {
+	for i := ip; i >= 0; i-- {
+		if pos, ok := sm[i]; ok {
+			return pos
+		}
+	}
+	return token.Position{}
+}
+
 // Make makes a bytecode instruction sequence from an opcode and operands.
 func Make(op Opcode, operands ...int) []byte {
 	def, ok := definitions[op]
@@ -1,12 +1,15 @@
 package compiler
 
 import (
+	"errors"
 	"fmt"
 	"sort"
 
 	"monkey-compiler/ast"
 	"monkey-compiler/code"
+	"monkey-compiler/module"
 	"monkey-compiler/object"
+	"monkey-compiler/token"
 )
 
 // EmittedInstruction represents an instruction emitted at a position.
@@ -19,6 +22,35 @@ type EmittedInstruction struct {
 type CompilationScope struct {
 	insns              code.Instructions
 	lastInsn, prevInsn EmittedInstruction
+	sourceMap          code.SourceMap
+	// loops is a stack of the loops enclosing the code being compiled
+	loops []*loop
+	// tries is a stack of the try statements enclosing the code being compiled
+	tries []*tryBlock
+}
+
+// loop holds the positions of the jumps emitted for break and continue statements in a loop.
+// Their targets are not known until the whole loop is compiled.
+type loop struct {
+	breaks, continues []int
+	// iterating reports whether the loop keeps an iterator on the stack while it runs.
+	iterating bool
+	// tries is the number of the try statements enclosing the loop in its function.
+	tries int
+}
+
+// tryBlock is a try statement enclosing the code being compiled. Leaving the statement early
+// by a break, continue or return statement has to pop its exception handler and run its finally
+// block on the way.
+type tryBlock struct {
+	finally *ast.BlockStatement
+	// symTbl is the symbol table of the scope enclosing the statement, which the finally block
+	// is compiled in.
+	symTbl *SymbolTable
+	// handling reports whether an exception handler is pushed for the code being compiled.
+	handling bool
+	// loops is the number of the loops enclosing the statement in its function.
+	loops int
 }
 
 // Compiler is a bytecode compiler.
@@ -27,40 +59,87 @@ type Compiler struct {
 	consts []object.Object
 
 	symTbl *SymbolTable
+	// mainSymTbl is the symbol table of the top level of the program, which also holds the
+	// modules the program imports under their paths.
+	mainSymTbl *SymbolTable
+	// hoisted holds the symbols of the function bindings defined ahead of their let statements.
+	hoisted map[*ast.LetStatement]Symbol
+
+	loader *module.Loader
 
 	scopes   []CompilationScope
 	scopeIdx int
+
+	// pos is the source position of the node being compiled.
+	pos token.Position
+
+	// chainJumps holds the positions of the jumps to the end of the chain of index, member,
+	// slice and call expressions being compiled, which are taken if the object of an optional
+	// link is nil.
+	chainJumps []int
+	// inChain reports whether the node being compiled is the object of a link in a chain.
+	inChain bool
 }
 
 // New creates a new Compiler.
 func New() *Compiler {
 	symTbl := NewSymbolTable()
+	defineBuiltins(symTbl)
+
+	return NewWithState(symTbl, make([]object.Object, 0))
+}
 
-	// Define built-in functions
+// defineBuiltins defines built-in functions in `symTbl`.
+func defineBuiltins(symTbl *SymbolTable) {
 	for i, builtin := range object.Builtins {
 		symTbl.DefineBuiltin(i, builtin.Name)
 	}
-
-	return NewWithState(symTbl, make([]object.Object, 0))
 }
 
 // NewWithState creates a new Compiler with a given symbol table and constant pool.
 func NewWithState(symTbl *SymbolTable, consts []object.Object) *Compiler {
 	mainScope := CompilationScope{
-		insns: make(code.Instructions, 0),
+		insns:     make(code.Instructions, 0),
+		sourceMap: make(code.SourceMap),
 	}
 
 	return &Compiler{
-		consts: consts,
-		symTbl: symTbl,
-		scopes: []CompilationScope{mainScope},
+		consts:     consts,
+		symTbl:     symTbl,
+		mainSymTbl: symTbl,
+		hoisted:    make(map[*ast.LetStatement]Symbol),
+		loader:     module.NewLoader(),
+		scopes:     []CompilationScope{mainScope},
 	}
 }
 
+// SetLoader sets the loader of the modules the program imports, so that they are shared with
+// the macro expansion which loaded them.
+func (c *Compiler) SetLoader(loader *module.Loader) {
+	c.loader = loader
+}
+
 // Compile compiles an AST node to a bytecode.
 func (c *Compiler) Compile(node ast.Node) error {
+	if node != nil {
+		if pos := node.Pos(); pos.IsValid() {
+			outer := c.pos
+			c.pos = pos
+			defer func() { c.pos = outer }()
+		}
+	}
+
+	// The outermost link of a chain is where the optional links skip to
+	inChain := c.inChain
+	c.inChain = false
+	if isChainLink(node) && !inChain {
+		defer c.endChain(c.beginChain())
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
+		c.hoistFunctions(node.Statements)
+
 		for _, s := range node.Statements {
 			if err := c.Compile(s); err != nil {
 				return err
@@ -68,6 +147,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 	case *ast.BlockStatement:
+		c.enterBlock()
+		defer c.leaveBlock()
+
+		c.hoistFunctions(node.Statements)
+
 		for _, stmt := range node.Statements {
 			if err := c.Compile(stmt); err != nil {
 				return err
@@ -83,29 +167,62 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 	// FIXME: duplicate of assign statement; need to merge
 	case *ast.LetStatement:
+		if node.Pattern != nil {
+			if err := c.Compile(node.Value); err != nil {
+				return err
+			}
+
+			return c.bindPattern(node.Pattern, node.Binding())
+		}
+
 		// Define a symbol at first in order to make recursive functions work
-		sym := c.symTbl.Define(node.Name.Value)
+		sym, hoisted := c.hoisted[node]
+		if hoisted {
+			if err := c.checkRedeclaration(node.Name.Value, sym); err != nil {
+				return err
+			}
+		} else {
+			var err error
+			sym, err = c.defineSymbol(node.Name.Value, node.Binding())
+			if err != nil {
+				return err
+			}
+		}
 
 		// Compile the right-hand side expression
 		if err := c.Compile(node.Value); err != nil {
 			return err
 		}
 
-		// Define an identifier as a symbol in a proper scope
-		if sym.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, sym.Index)
+		// Define an identifier as a symbol in a proper scope. A hoisted function is assigned
+		// to the variable the closures referring to it before have captured.
+		if hoisted {
+			c.assignSymbol(sym)
 		} else {
-			c.emit(code.OpSetLocal, sym.Index)
+			c.storeSymbol(sym)
 		}
 
+	case *ast.ImportStatement:
+		return c.compileImport(node)
+
 	case *ast.AssignStatement:
-		switch lhs := node.LHS.(type) {
+		target := node.LHS
+		// A member is assigned to by the index expression the member access is sugar for
+		if member, ok := target.(*ast.MemberExpression); ok {
+			target = member.IndexExpression()
+		}
+
+		switch lhs := target.(type) {
 		case *ast.Ident:
-			if err := c.compileVariableAssignment(lhs, node.RHS); err != nil {
+			if err := c.compileVariableAssignment(lhs, node); err != nil {
 				return err
 			}
 
 		case *ast.IndexExpression:
+			if lhs.Optional() {
+				return c.errorf("cannot assign to %s", node.LHS)
+			}
+
 			// Compile left-hand side expression
 			if err := c.Compile(lhs.Left); err != nil {
 				return err
@@ -114,12 +231,34 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 
+			// Get the current value reusing the evaluated left-hand side expression
+			if node.IsCompound() {
+				c.emit(code.OpDup, 2)
+				c.emit(code.OpGetIndex)
+			}
+
 			// Compile right-hand side expression
-			if err := c.Compile(node.RHS); err != nil {
+			if err := c.compileAssignedValue(node); err != nil {
 				return err
 			}
 
 			c.emit(code.OpSetIndex)
+
+		case *ast.ArrayPattern, *ast.HashPattern:
+			if node.IsCompound() {
+				return c.errorf("cannot assign to %s", node.LHS)
+			}
+
+			if err := c.Compile(node.RHS); err != nil {
+				return err
+			}
+
+			if err := c.bindPattern(lhs.(ast.Pattern), ast.AssignBinding); err != nil {
+				return err
+			}
+
+		default:
+			return c.errorf("cannot assign to %s", node.LHS)
 		}
 
 	case *ast.ReturnStatement:
@@ -127,11 +266,163 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
+		if err := c.leaveTries(0); err != nil {
+			return err
+		}
+
 		c.emit(code.OpReturnValue)
 
+	case *ast.ThrowStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+
+		c.emit(code.OpThrow)
+
+	case *ast.TryStatement:
+		return c.compileTry(node)
+
+	case *ast.WhileStatement:
+		loopStartPos := len(c.currentInsns())
+
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+
+		// Emit an `OpJumpNotTruthy` with a bogus value
+		exitJumpPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		l, err := c.compileLoopBody(node.Body, false)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpJump, loopStartPos)
+
+		afterLoopPos := len(c.currentInsns())
+		c.changeOperand(exitJumpPos, afterLoopPos)
+		c.patchLoopJumps(l, loopStartPos, afterLoopPos)
+
+		c.emitLoopValue()
+
+	case *ast.ForStatement:
+		// The variables defined by the init statement are scoped to the loop
+		c.enterBlock()
+		defer c.leaveBlock()
+
+		if node.Init != nil {
+			if err := c.Compile(node.Init); err != nil {
+				return err
+			}
+		}
+
+		loopStartPos := len(c.currentInsns())
+
+		exitJumpPos := -1
+		if node.Condition != nil {
+			if err := c.Compile(node.Condition); err != nil {
+				return err
+			}
+
+			// Emit an `OpJumpNotTruthy` with a bogus value
+			exitJumpPos = c.emit(code.OpJumpNotTruthy, 9999)
+		}
+
+		l, err := c.compileLoopBody(node.Body, false)
+		if err != nil {
+			return err
+		}
+
+		updatePos := len(c.currentInsns())
+		if node.Update != nil {
+			if err := c.Compile(node.Update); err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpJump, loopStartPos)
+
+		afterLoopPos := len(c.currentInsns())
+		if exitJumpPos >= 0 {
+			c.changeOperand(exitJumpPos, afterLoopPos)
+		}
+		c.patchLoopJumps(l, updatePos, afterLoopPos)
+
+		c.emitLoopValue()
+
+	case *ast.ForInStatement:
+		if err := c.Compile(node.Iterable); err != nil {
+			return err
+		}
+
+		c.emit(code.OpIter)
+
+		loopStartPos := len(c.currentInsns())
+
+		// Emit an `OpIterNext` with a bogus jump position. The iterator stays on the stack
+		// while the loop runs and is popped by `OpIterNext` when it is exhausted.
+		numVars := 1
+		if node.Key != nil {
+			numVars = 2
+		}
+		iterNextPos := c.emit(code.OpIterNext, 9999, numVars)
+
+		// The loop variables are scoped to the loop, and bound anew in each iteration
+		c.enterBlock()
+		defer c.leaveBlock()
+
+		c.storeSymbol(c.symTbl.Define(node.Value.Value))
+		if node.Key != nil {
+			c.storeSymbol(c.symTbl.Define(node.Key.Value))
+		}
+
+		l, err := c.compileLoopBody(node.Body, true)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpJump, loopStartPos)
+
+		afterLoopPos := len(c.currentInsns())
+		c.replaceInstruction(iterNextPos, code.Make(code.OpIterNext, afterLoopPos, numVars))
+		c.patchLoopJumps(l, loopStartPos, afterLoopPos)
+
+		c.emitLoopValue()
+
+	case *ast.BreakStatement:
+		l := c.currentLoop()
+		if l == nil {
+			return c.errorf("break is not in a loop")
+		}
+
+		if err := c.leaveTries(l.tries); err != nil {
+			return err
+		}
+
+		// Leaving a for-in loop early has to pop its iterator
+		if l.iterating {
+			c.emit(code.OpPop)
+		}
+
+		// Emit an `OpJump` with a bogus value
+		l.breaks = append(l.breaks, c.emit(code.OpJump, 9999))
+
+	case *ast.ContinueStatement:
+		l := c.currentLoop()
+		if l == nil {
+			return c.errorf("continue is not in a loop")
+		}
+
+		if err := c.leaveTries(l.tries); err != nil {
+			return err
+		}
+
+		// Emit an `OpJump` with a bogus value
+		l.continues = append(l.continues, c.emit(code.OpJump, 9999))
+
 	case *ast.PrefixExpression:
 		if err := c.Compile(node.Right); err != nil {
-			return nil
+			return err
 		}
 
 		switch node.Operator {
@@ -139,13 +430,42 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpBang)
 		case "-":
 			c.emit(code.OpMinus)
+		case "~":
+			c.emit(code.OpBitNot)
 		default:
-			return fmt.Errorf("unknown unary operator: %s", node.Operator)
+			return c.errorf("unknown unary operator: %s", node.Operator)
 		}
 
 	case *ast.InfixExpression:
 		opr := node.Operator
 
+		// Evaluate the right operand only if the left one does not decide the result
+		if opr == "&&" || opr == "||" || opr == "??" {
+			if err := c.Compile(node.Left); err != nil {
+				return err
+			}
+
+			// Emit a jump with a bogus value
+			var jump code.Opcode
+			switch opr {
+			case "&&":
+				jump = code.OpJumpNotTruthyOrPop
+			case "||":
+				jump = code.OpJumpTruthyOrPop
+			default:
+				jump = code.OpJumpNotNilOrPop
+			}
+			jumpPos := c.emit(jump, 9999)
+
+			if err := c.Compile(node.Right); err != nil {
+				return err
+			}
+
+			c.changeOperand(jumpPos, len(c.currentInsns()))
+
+			return nil
+		}
+
 		// Reverse the two operands if the operator is "<" (less than) or "<=" (less than or equal)
 		if opr == "<" || opr == "<=" {
 			if err := c.Compile(node.Right); err != nil {
@@ -182,6 +502,22 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMul)
 		case "/":
 			c.emit(code.OpDiv)
+		case "//":
+			c.emit(code.OpFloorDiv)
+		case "%":
+			c.emit(code.OpMod)
+		case "**":
+			c.emit(code.OpPow)
+		case "&":
+			c.emit(code.OpBitAnd)
+		case "|":
+			c.emit(code.OpBitOr)
+		case "^":
+			c.emit(code.OpBitXor)
+		case "<<":
+			c.emit(code.OpShiftLeft)
+		case ">>":
+			c.emit(code.OpShiftRight)
 		case ">":
 			c.emit(code.OpGreaterThan)
 		case ">=":
@@ -190,16 +526,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
-		case "&&":
-			c.emit(code.OpAnd)
-		case "||":
-			c.emit(code.OpOr)
 		default:
-			return fmt.Errorf("unknown operator: %s", opr)
+			return c.errorf("unknown operator: %s", opr)
 		}
 
 	case *ast.IndexExpression:
-		if err := c.Compile(node.Left); err != nil {
+		if err := c.compileChainObject(node.Left, node.Optional()); err != nil {
 			return err
 		}
 
@@ -209,6 +541,26 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		c.emit(code.OpGetIndex)
 
+	case *ast.MemberExpression:
+		c.inChain = inChain
+		return c.Compile(node.IndexExpression())
+
+	case *ast.SliceExpression:
+		if err := c.compileChainObject(node.Left, node.Optional()); err != nil {
+			return err
+		}
+
+		// Omitted indices are nil
+		for _, idx := range []ast.Expression{node.Start, node.End} {
+			if idx == nil {
+				c.emit(code.OpNil)
+			} else if err := c.Compile(idx); err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpSlice)
+
 	case *ast.IfExpression:
 		if err := c.Compile(node.Condition); err != nil {
 			return err
@@ -221,9 +573,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastInstruction()
-		}
+		c.keepBlockValue()
 
 		// Emit an `OpJump` with a bogus value
 		jumpPos := c.emit(code.OpJump, 9999)
@@ -238,19 +588,32 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastInstruction()
-			}
+			c.keepBlockValue()
 		}
 
 		afterAlternativePos := len(c.currentInsns())
 		c.changeOperand(jumpPos, afterAlternativePos)
 
 	case *ast.CallExpression:
-		if err := c.Compile(node.Function); err != nil {
+		// A method call passes the receiver to the method, so the method is bound to it
+		if member, ok := node.Function.(*ast.MemberExpression); ok {
+			if err := c.compileMethod(member); err != nil {
+				return err
+			}
+		} else if err := c.compileChainObject(node.Function, false); err != nil {
 			return err
 		}
 
+		if hasSpread(node.Arguments) {
+			if err := c.compileSpreadElements(node.Arguments); err != nil {
+				return err
+			}
+
+			c.emit(code.OpCallSpread)
+
+			return nil
+		}
+
 		for _, arg := range node.Arguments {
 			if err := c.Compile(arg); err != nil {
 				return err
@@ -262,11 +625,14 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.Ident:
 		sym, ok := c.symTbl.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %q", node.Value)
+			return c.errorf("undefined variable %q", node.Value)
 		}
 
 		c.loadSymbol(sym)
 
+	case *ast.ArrayPattern, *ast.HashPattern:
+		return c.errorf("unexpected pattern %s", node)
+
 	case *ast.Boolean:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -289,7 +655,18 @@ func (c *Compiler) Compile(node ast.Node) error {
 		s := &object.String{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(s))
 
+	case *ast.StringConversion:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+
+		c.emit(code.OpToString)
+
 	case *ast.ArrayLiteral:
+		if hasSpread(node.Elements) {
+			return c.compileSpreadElements(node.Elements)
+		}
+
 		for _, el := range node.Elements {
 			if err := c.Compile(el); err != nil {
 				return err
@@ -326,8 +703,32 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.symTbl.DefineFunctionName(node.Name)
 		}
 
-		for _, p := range node.Parameters {
-			c.symTbl.Define(p.Value)
+		// A parameter with a pattern is passed in a hidden local variable and destructured
+		// before the body runs. The rest parameter follows the other parameters.
+		paramSyms := make([]Symbol, len(node.Parameters))
+		for i, p := range node.Parameters {
+			paramSyms[i] = c.symTbl.Define(p.String())
+		}
+		if node.Rest != nil {
+			c.symTbl.Define(node.Rest.Value)
+		}
+
+		firstDefault := len(node.Parameters) - len(node.Defaults)
+		for i, p := range node.Parameters {
+			if i >= firstDefault {
+				if err := c.compileDefaultValue(paramSyms[i], i, node.Defaults[i-firstDefault]); err != nil {
+					return err
+				}
+			}
+
+			if _, ok := p.(*ast.Ident); ok {
+				continue
+			}
+
+			c.loadSymbol(paramSyms[i])
+			if err := c.bindPattern(p, ast.LetBinding); err != nil {
+				return err
+			}
 		}
 
 		if err := c.Compile(node.Body); err != nil {
@@ -345,18 +746,22 @@ func (c *Compiler) Compile(node ast.Node) error {
 		// in the current scope from the symbol table *before* leaving the scope
 		freeSymbols := c.symTbl.freeSymbols
 		numLocals := c.symTbl.numDefs
+		sourceMap := c.currentScope().sourceMap
 
 		insns := c.leaveScope()
 
-		// Iterate through and load free symbols *after* we left the scope
+		// Iterate through and capture free symbols *after* we left the scope
 		for _, s := range freeSymbols {
-			c.loadSymbol(s)
+			c.captureSymbol(s)
 		}
 
 		compiledFn := &object.CompiledFunction{
 			Instructions:  insns,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			MinArity:      firstDefault,
+			Variadic:      node.Rest != nil,
+			SourceMap:     sourceMap,
 		}
 		fnIdx := c.addConstant(compiledFn)
 		c.emit(code.OpClosure, fnIdx, len(freeSymbols))
@@ -380,9 +785,23 @@ func (c *Compiler) emit(op code.Opcode, operands ...int) (pos int) {
 
 	c.setLastInstruction(op, pos)
 
+	if c.pos.IsValid() {
+		c.scopes[c.scopeIdx].sourceMap[pos] = c.pos
+	}
+
 	return pos
 }
 
+// errorf returns a compilation error prefixed with the source position of the node being
+// compiled.
+func (c *Compiler) errorf(format string, a ...interface{}) error {
+	msg := fmt.Sprintf(format, a...)
+	if c.pos.IsValid() {
+		msg = c.pos.String() + ": " + msg
+	}
+	return errors.New(msg)
+}
+
 func (c *Compiler) currentScope() CompilationScope {
 	return c.scopes[c.scopeIdx]
 }
@@ -410,10 +829,28 @@ func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
 
 func (c *Compiler) removeLastInstruction() {
 	scope := c.currentScope()
+	delete(scope.sourceMap, scope.lastInsn.Position)
 	c.scopes[c.scopeIdx].insns = scope.insns[:scope.lastInsn.Position]
 	c.scopes[c.scopeIdx].lastInsn = scope.prevInsn
 }
 
+// emitLoopValue makes nil the last popped value after a loop, as the value of the loop
+// statement, instead of the condition or the iterator which ended it.
+func (c *Compiler) emitLoopValue() {
+	c.emit(code.OpNil)
+	c.emit(code.OpPop)
+}
+
+// keepBlockValue leaves the value of a block just compiled on the stack. The value is the value
+// of the last expression statement, or nil if the block does not end with an expression.
+func (c *Compiler) keepBlockValue() {
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastInstruction()
+	} else {
+		c.emit(code.OpNil)
+	}
+}
+
 func (c *Compiler) replaceInstruction(pos int, newInsn []byte) {
 	// The underlying assumption here is that we only replace instructions of the same type,
 	// with the same non-variable length.
@@ -432,9 +869,292 @@ func (c *Compiler) replaceLastInsnWithReturn() {
 	c.scopes[c.scopeIdx].lastInsn.Opcode = code.OpReturnValue
 }
 
+// compileLoopBody compiles the body of a loop and returns the loop with the positions of the
+// jumps for break and continue statements in the body.
+func (c *Compiler) compileLoopBody(body *ast.BlockStatement, iterating bool) (*loop, error) {
+	idx := c.scopeIdx
+	l := &loop{iterating: iterating, tries: len(c.scopes[idx].tries)}
+	c.scopes[idx].loops = append(c.scopes[idx].loops, l)
+	defer func() {
+		loops := c.scopes[idx].loops
+		c.scopes[idx].loops = loops[:len(loops)-1]
+	}()
+
+	if err := c.Compile(body); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// currentLoop returns the innermost loop enclosing the code being compiled, or nil if there is
+// no loop in the current function.
+func (c *Compiler) currentLoop() *loop {
+	loops := c.currentScope().loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+// patchLoopJumps sets the targets of the jumps for continue and break statements in the loop.
+func (c *Compiler) patchLoopJumps(l *loop, continuePos, breakPos int) {
+	for _, pos := range l.continues {
+		c.changeOperand(pos, continuePos)
+	}
+	for _, pos := range l.breaks {
+		c.changeOperand(pos, breakPos)
+	}
+}
+
+// compileTry compiles a try statement. The exception handler pushed for the body jumps to the
+// catch clause with the exception on top of the stack. The finally block is inlined on every way
+// out of the statement: after the body and the catch clause, before the break, continue and
+// return statements leaving them, and before an exception escaping them is thrown again. The
+// value of the statement is the value of the body, or of the catch clause if it catches an
+// exception.
+func (c *Compiler) compileTry(node *ast.TryStatement) error {
+	// Emit an `OpTry` with a bogus value
+	tryPos := c.emit(code.OpTry, 9999)
+
+	// The finally block is compiled in the scope enclosing the statement wherever it is inlined
+	symTbl := c.symTbl
+
+	body := &tryBlock{finally: node.Finally, symTbl: symTbl, handling: true}
+	if err := c.compileTryBlock(node.Body, body); err != nil {
+		return err
+	}
+	c.keepBlockValue()
+	c.emit(code.OpEndTry)
+	if err := c.compileFinallyKeepingValue(node.Finally); err != nil {
+		return err
+	}
+
+	// Emit `OpJump`s with bogus values
+	endJumps := []int{c.emit(code.OpJump, 9999)}
+
+	c.changeOperand(tryPos, len(c.currentInsns()))
+
+	if node.Catch != nil {
+		// The parameter is scoped to the catch clause
+		c.enterBlock()
+
+		if node.Param != nil {
+			c.storeSymbol(c.symTbl.Define(node.Param.Value))
+		} else {
+			c.emit(code.OpPop)
+		}
+
+		// An exception escaping the catch clause still has to run the finally block
+		rethrowPos := -1
+		if node.Finally != nil {
+			rethrowPos = c.emit(code.OpTry, 9999)
+		}
+
+		catch := &tryBlock{finally: node.Finally, symTbl: symTbl, handling: rethrowPos >= 0}
+		if err := c.compileTryBlock(node.Catch, catch); err != nil {
+			return err
+		}
+		c.keepBlockValue()
+
+		if rethrowPos >= 0 {
+			c.emit(code.OpEndTry)
+			if err := c.compileFinallyKeepingValue(node.Finally); err != nil {
+				return err
+			}
+		}
+
+		c.leaveBlock()
+
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+
+		if rethrowPos >= 0 {
+			c.changeOperand(rethrowPos, len(c.currentInsns()))
+		}
+	}
+
+	if node.Finally != nil {
+		// The exception is kept in a hidden variable while the finally block runs, so that a
+		// break, continue or return statement in the block leaves the stack balanced. The name
+		// is a keyword, so it cannot clash with any variable.
+		c.enterBlock()
+		exc := c.symTbl.Define("finally")
+		c.storeSymbol(exc)
+
+		if err := c.compileFinally(node.Finally); err != nil {
+			return err
+		}
+
+		c.loadSymbol(exc)
+		c.emit(code.OpThrow)
+		c.leaveBlock()
+	}
+
+	afterTryPos := len(c.currentInsns())
+	for _, pos := range endJumps {
+		c.changeOperand(pos, afterTryPos)
+	}
+
+	// The value of the statement is the last popped one, as an expression statement's is
+	c.emit(code.OpPop)
+
+	return nil
+}
+
+// compileTryBlock compiles the body or the catch clause of a try statement, which is enclosed
+// by `t` while it is compiled.
+func (c *Compiler) compileTryBlock(block *ast.BlockStatement, t *tryBlock) error {
+	idx := c.scopeIdx
+	t.loops = len(c.scopes[idx].loops)
+	c.scopes[idx].tries = append(c.scopes[idx].tries, t)
+	defer func() {
+		tries := c.scopes[idx].tries
+		c.scopes[idx].tries = tries[:len(tries)-1]
+	}()
+
+	return c.Compile(block)
+}
+
+// compileFinally compiles the finally block of a try statement, if any.
+func (c *Compiler) compileFinally(finally *ast.BlockStatement) error {
+	if finally == nil {
+		return nil
+	}
+	return c.Compile(finally)
+}
+
+// compileFinallyKeepingValue compiles the finally block of a try statement, if any, which runs
+// after the body or the catch clause with its value on top of the stack. The value is kept in
+// a hidden variable while the block runs, so that a break, continue or return statement in the
+// block leaves the stack balanced. The name is a keyword, so it cannot clash with any variable.
+func (c *Compiler) compileFinallyKeepingValue(finally *ast.BlockStatement) error {
+	if finally == nil {
+		return nil
+	}
+
+	c.enterBlock()
+	defer c.leaveBlock()
+
+	value := c.symTbl.Define("try")
+	c.storeSymbol(value)
+
+	if err := c.compileFinally(finally); err != nil {
+		return err
+	}
+
+	c.loadSymbol(value)
+	return nil
+}
+
+// leaveTries emits the code to leave the try statements enclosing the code being compiled, from
+// the innermost one out to the one at `depth`: their exception handlers are popped and their
+// finally blocks are run.
+func (c *Compiler) leaveTries(depth int) error {
+	idx := c.scopeIdx
+	scope := c.scopes[idx]
+	symTbl := c.symTbl
+	defer func() {
+		c.scopes[idx].loops = scope.loops
+		c.scopes[idx].tries = scope.tries
+		c.symTbl = symTbl
+	}()
+
+	for i := len(scope.tries) - 1; i >= depth; i-- {
+		t := scope.tries[i]
+		if t.handling {
+			c.emit(code.OpEndTry)
+		}
+
+		// The finally block runs outside of the try statement, so a break, continue or return
+		// statement in it only leaves the loops and the try statements enclosing the statement.
+		// The stacks are cut down to their capacity, so that they are copied if pushed onto.
+		c.scopes[idx].loops = scope.loops[:t.loops:t.loops]
+		c.scopes[idx].tries = scope.tries[:i:i]
+
+		// The finally block sees the variables of the scope enclosing the statement. Its own
+		// variables take the slots after the ones in use, so as not to clobber any of them.
+		c.symTbl = NewBlockSymbolTable(t.symTbl)
+		c.symTbl.numSlots = symTbl.numSlots
+		if err := c.compileFinally(t.finally); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isChainLink reports whether `node` is a link in a chain of index, member, slice and call
+// expressions, e.g. `a?.b[0].c()`.
+func isChainLink(node ast.Node) bool {
+	switch node.(type) {
+	case *ast.IndexExpression, *ast.MemberExpression, *ast.SliceExpression, *ast.CallExpression:
+		return true
+	default:
+		return false
+	}
+}
+
+// beginChain starts compiling a chain and returns the jumps of the enclosing one.
+func (c *Compiler) beginChain() (outer []int) {
+	outer = c.chainJumps
+	c.chainJumps = nil
+	return outer
+}
+
+// endChain points the jumps of the optional links in the chain to the end of it, which leaves
+// nil as the value of the chain, and restores the jumps of the enclosing one.
+func (c *Compiler) endChain(outer []int) {
+	for _, pos := range c.chainJumps {
+		c.changeOperand(pos, len(c.currentInsns()))
+	}
+	c.chainJumps = outer
+}
+
+// compileChainObject compiles the object of a link in a chain, e.g. `a` in `a.b`. If the link
+// is optional, the rest of the chain is skipped when the object is nil. An object in parentheses
+// is a chain of its own.
+func (c *Compiler) compileChainObject(obj ast.Expression, optional bool) error {
+	c.inChain = !ast.IsGrouped(obj)
+	if err := c.Compile(obj); err != nil {
+		return err
+	}
+
+	if optional {
+		c.chainJumps = append(c.chainJumps, c.emit(code.OpJumpNil, 9999))
+	}
+	return nil
+}
+
+// compileMethod compiles the member `member` accesses, bound to the object if it is a function.
+// A method in parentheses is a chain of its own.
+func (c *Compiler) compileMethod(member *ast.MemberExpression) error {
+	if member.Grouped {
+		defer c.endChain(c.beginChain())
+	}
+
+	if err := c.compileChainObject(member.Object, member.Optional()); err != nil {
+		return err
+	}
+
+	name := &object.String{Value: member.Member.Value}
+	c.emit(code.OpGetMethod, c.addConstant(name))
+	return nil
+}
+
+// enterBlock enters the scope of a block in the current function.
+func (c *Compiler) enterBlock() {
+	c.symTbl = NewBlockSymbolTable(c.symTbl)
+}
+
+// leaveBlock leaves the scope of a block in the current function, whose local slots are reused
+// by the blocks following it.
+func (c *Compiler) leaveBlock() {
+	c.symTbl = c.symTbl.outer
+}
+
 func (c *Compiler) enterScope() {
 	scope := CompilationScope{
-		insns: make(code.Instructions, 0),
+		insns:     make(code.Instructions, 0),
+		sourceMap: make(code.SourceMap),
 	}
 	c.scopes = append(c.scopes, scope)
 	c.scopeIdx++
@@ -469,24 +1189,366 @@ func (c *Compiler) loadSymbol(s Symbol) {
 	}
 }
 
-func (c *Compiler) compileVariableAssignment(lhs *ast.Ident, rhs ast.Expression) error {
+// storeSymbol pops the topmost element on the stack into the global or local variable `s`,
+// which is newly defined.
+func (c *Compiler) storeSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+// assignSymbol pops the topmost element on the stack into the existing variable `s`. An
+// assignment to a variable captured by closures is seen by them.
+func (c *Compiler) assignSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpAssignLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// captureSymbol pushes the cell of the local or free variable `s` for a closure capturing it.
+// The current closure referred to by its name is pushed as is, and boxed by `OpClosure`.
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// hoistFunctions defines the variables bound to functions by the let statements in `stmts`
+// ahead of the statements, so that the functions can refer to each other wherever they are
+// defined in the block. The variables are nil until their let statements run.
+func (c *Compiler) hoistFunctions(stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok || let.Name == nil {
+			continue
+		}
+		if _, ok := let.Value.(*ast.FunctionLiteral); !ok {
+			continue
+		}
+		// A constant is defined in order with the other variables of the name in the block, so
+		// that the let statement redeclaring it reports the error
+		if sym, ok := c.symTbl.ResolveCurrentScope(let.Name.Value); ok && (sym.Const || let.IsConst()) {
+			continue
+		}
+
+		sym, _ := c.defineSymbol(let.Name.Value, let.Binding())
+		c.hoisted[let] = sym
+
+		// A fresh local variable is set, so that a closure capturing it does not share the one
+		// left in the slot by an earlier block or iteration
+		if sym.Scope == LocalScope {
+			c.emit(code.OpNil)
+			c.storeSymbol(sym)
+		}
+	}
+}
+
+func (c *Compiler) compileVariableAssignment(lhs *ast.Ident, node *ast.AssignStatement) error {
 	name := lhs.Value
-	sym, exists := c.symTbl.ResolveCurrentScope(name)
-	if !exists || sym.Scope == BuiltinScope {
-		// Define a symbol at first in order to make recursive functions work
-		sym = c.symTbl.Define(name)
+
+	// Load the current value before the symbol is possibly redefined below
+	if node.IsCompound() {
+		sym, ok := c.symTbl.Resolve(name)
+		if !ok {
+			return c.errorf("undefined variable %q", name)
+		}
+		c.loadSymbol(sym)
+	}
+
+	// Define a symbol at first in order to make recursive functions work
+	sym, defined := c.assignedSymbol(name)
+	if sym.Const {
+		return c.errorf("cannot assign to constant %q", name)
 	}
 
 	// Compile the right-hand side expression
-	if err := c.Compile(rhs); err != nil {
+	if err := c.compileAssignedValue(node); err != nil {
 		return err
 	}
 
-	// Define an identifier as a symbol in a proper scope
-	if sym.Scope == GlobalScope {
-		c.emit(code.OpSetGlobal, sym.Index)
+	if defined {
+		c.storeSymbol(sym)
 	} else {
-		c.emit(code.OpSetLocal, sym.Index)
+		c.assignSymbol(sym)
+	}
+
+	return nil
+}
+
+// assignedSymbol returns the variable an assignment to `name` stores into, which is the
+// variable of the name visible from the current scope, even of an enclosing function. The name
+// of the function being defined refers to the variable the function is bound to. If there is no
+// such variable, or the name refers to a built-in function, a new variable is defined in the
+// current scope and `defined` is true.
+func (c *Compiler) assignedSymbol(name string) (sym Symbol, defined bool) {
+	sym, exists := c.symTbl.ResolveVariable(name)
+	switch {
+	case exists && (sym.Scope == GlobalScope || sym.Scope == LocalScope || sym.Scope == FreeScope):
+		return sym, false
+	default:
+		return c.symTbl.Define(name), true
+	}
+}
+
+// defineSymbol defines a variable named `name` in the current scope, which is a constant if `b`
+// is a const binding. A constant cannot be redeclared in the same scope.
+func (c *Compiler) defineSymbol(name string, b ast.Binding) (Symbol, error) {
+	if err := c.checkRedeclaration(name, Symbol{}); err != nil {
+		return Symbol{}, err
+	}
+
+	if b == ast.ConstBinding {
+		return c.symTbl.DefineConst(name), nil
+	}
+	return c.symTbl.Define(name), nil
+}
+
+// checkRedeclaration returns an error if a constant named `name` other than `sym` is defined in
+// the current scope.
+func (c *Compiler) checkRedeclaration(name string, sym Symbol) error {
+	if cur, ok := c.symTbl.ResolveCurrentScope(name); ok && cur.Const && cur != sym {
+		return c.errorf("cannot redeclare constant %q", name)
+	}
+	return nil
+}
+
+// bindPattern pops the value on top of the stack and binds it to the variables in `pattern` in
+// the way `b`.
+func (c *Compiler) bindPattern(pattern ast.Pattern, b ast.Binding) error {
+	bind := func(ident *ast.Ident) error {
+		if b != ast.AssignBinding {
+			sym, err := c.defineSymbol(ident.Value, b)
+			if err != nil {
+				return err
+			}
+			c.storeSymbol(sym)
+			return nil
+		}
+
+		sym, defined := c.assignedSymbol(ident.Value)
+		switch {
+		case sym.Const:
+			return c.errorf("cannot assign to constant %q", ident.Value)
+		case defined:
+			c.storeSymbol(sym)
+		default:
+			c.assignSymbol(sym)
+		}
+		return nil
+	}
+
+	switch pattern := pattern.(type) {
+	case *ast.Ident:
+		return bind(pattern)
+
+	case *ast.ArrayPattern:
+		hasRest := 0
+		if pattern.Rest != nil {
+			hasRest = 1
+		}
+		c.emit(code.OpUnpackArray, len(pattern.Elements), hasRest)
+
+		for _, elem := range pattern.Elements {
+			if err := c.bindPattern(elem, b); err != nil {
+				return err
+			}
+		}
+		if pattern.Rest != nil {
+			return bind(pattern.Rest)
+		}
+
+	case *ast.HashPattern:
+		for _, key := range pattern.Keys {
+			c.emit(code.OpConstant, c.addConstant(&object.String{Value: key.Value}))
+		}
+		c.emit(code.OpUnpackHash, len(pattern.Keys))
+
+		for _, key := range pattern.Keys {
+			if err := bind(key); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return c.errorf("unknown pattern: %s", pattern)
+	}
+
+	return nil
+}
+
+// compileImport compiles an import statement. The first import of a module runs the top level
+// of the module and stores the module in a hidden global of the program, which the later
+// imports read, so a module is run once however many times it is imported.
+func (c *Compiler) compileImport(node *ast.ImportStatement) error {
+	mod, err := c.loader.Load(node.Path.Value, node.Pos())
+	if err != nil {
+		return err
+	}
+
+	// The path is not a valid identifier, so it cannot clash with any variable
+	sym, ok := c.mainSymTbl.ResolveCurrentScope(mod.Path)
+	if !ok {
+		if err := c.compileModule(mod); err != nil {
+			return err
+		}
+
+		sym = c.mainSymTbl.Define(mod.Path)
+		c.storeSymbol(sym)
+	}
+
+	c.loadSymbol(sym)
+	name, err := c.defineSymbol(node.Name.Value, ast.LetBinding)
+	if err != nil {
+		return err
+	}
+	c.storeSymbol(name)
+
+	return nil
+}
+
+// compileModule compiles the top level of `mod` with its own global symbols, followed by the
+// module object which refers to the globals it exports.
+func (c *Compiler) compileModule(mod *module.Module) error {
+	outer := c.symTbl
+	c.symTbl = NewModuleSymbolTable(c.mainSymTbl)
+	defineBuiltins(c.symTbl)
+	defer func() { c.symTbl = outer }()
+
+	if err := c.Compile(mod.Program); err != nil {
+		return err
+	}
+
+	// The blocks at the top level of the module use the local slots of the program's
+	if c.symTbl.numDefs > c.mainSymTbl.numDefs {
+		c.mainSymTbl.numDefs = c.symTbl.numDefs
+	}
+
+	obj := &object.Module{Name: mod.Name, Globals: make(map[string]int, len(mod.Exports))}
+	for _, name := range mod.Exports {
+		sym, _ := c.symTbl.ResolveCurrentScope(name)
+		obj.Globals[name] = sym.Index
+	}
+	c.emit(code.OpConstant, c.addConstant(obj))
+
+	return nil
+}
+
+// compileDefaultValue compiles the default value `value` of the parameter `param` at `idx`,
+// which is set only if no argument is passed for the parameter.
+func (c *Compiler) compileDefaultValue(param Symbol, idx int, value ast.Expression) error {
+	// Emit an `OpJumpIfPassed` with a bogus value
+	jumpPos := c.emit(code.OpJumpIfPassed, 9999, idx)
+
+	if err := c.Compile(value); err != nil {
+		return err
+	}
+	c.storeSymbol(param)
+
+	c.replaceInstruction(jumpPos, code.Make(code.OpJumpIfPassed, len(c.currentInsns()), idx))
+
+	return nil
+}
+
+// compileSpreadElements compiles the elements of an array literal or the arguments of a call,
+// some of which are spread, into an array. The elements between spread ones are collected into
+// arrays which are appended in turn.
+func (c *Compiler) compileSpreadElements(elems []ast.Expression) error {
+	i, err := c.compileUntilSpread(elems)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpArray, i)
+
+	for i < len(elems) {
+		if spread, ok := elems[i].(*ast.Spread); ok {
+			if err := c.Compile(spread.Value); err != nil {
+				return err
+			}
+			i++
+		} else {
+			n, err := c.compileUntilSpread(elems[i:])
+			if err != nil {
+				return err
+			}
+			c.emit(code.OpArray, n)
+			i += n
+		}
+
+		c.emit(code.OpExtendArray)
+	}
+
+	return nil
+}
+
+// compileUntilSpread compiles the elements of `elems` before the first spread one and returns
+// the number of them.
+func (c *Compiler) compileUntilSpread(elems []ast.Expression) (int, error) {
+	for i, elem := range elems {
+		if _, ok := elem.(*ast.Spread); ok {
+			return i, nil
+		}
+		if err := c.Compile(elem); err != nil {
+			return 0, err
+		}
+	}
+	return len(elems), nil
+}
+
+// hasSpread reports whether any of `exprs` is spread.
+func hasSpread(exprs []ast.Expression) bool {
+	for _, expr := range exprs {
+		if _, ok := expr.(*ast.Spread); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compileAssignedValue compiles the value to assign to the left-hand side of `node`. For a
+// compound assignment the current value of the left-hand side must be on the stack.
+func (c *Compiler) compileAssignedValue(node *ast.AssignStatement) error {
+	switch node.Token.Type {
+	case token.ASSIGN:
+		return c.Compile(node.RHS)
+	case token.INC:
+		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: 1}))
+		c.emit(code.OpAdd)
+		return nil
+	case token.DEC:
+		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: 1}))
+		c.emit(code.OpSub)
+		return nil
+	}
+
+	if err := c.Compile(node.RHS); err != nil {
+		return err
+	}
+
+	switch node.Token.Type {
+	case token.PLUS_ASSIGN:
+		c.emit(code.OpAdd)
+	case token.MINUS_ASSIGN:
+		c.emit(code.OpSub)
+	case token.ASTARISK_ASSIGN:
+		c.emit(code.OpMul)
+	case token.SLASH_ASSIGN:
+		c.emit(code.OpDiv)
+	case token.PERCENT_ASSIGN:
+		c.emit(code.OpMod)
+	default:
+		return c.errorf("unknown assignment operator: %s", node.Token.Literal)
 	}
 
 	return nil
@@ -497,6 +1559,8 @@ func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
 		Instructions: c.currentInsns(),
 		Constants:    c.consts,
+		SourceMap:    c.currentScope().sourceMap,
+		NumLocals:    c.mainSymTbl.numDefs,
 	}
 }
 
@@ -504,4 +1568,7 @@ func (c *Compiler) Bytecode() *Bytecode {
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	SourceMap    code.SourceMap
+	// NumLocals is the number of local slots the blocks at the top level need.
+	NumLocals int
 }
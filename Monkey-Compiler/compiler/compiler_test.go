@@ -2,6 +2,7 @@ package compiler
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 
 	"monkey-compiler/ast"
@@ -83,6 +84,49 @@ func TestIntegerArithmetic(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestIntegerOperators(t *testing.T) {
+	tests := []struct {
+		input string
+		want  code.Opcode
+	}{
+		{"1 // 2", code.OpFloorDiv},
+		{"1 % 2", code.OpMod},
+		{"1 ** 2", code.OpPow},
+		{"1 & 2", code.OpBitAnd},
+		{"1 | 2", code.OpBitOr},
+		{"1 ^ 2", code.OpBitXor},
+		{"1 << 2", code.OpShiftLeft},
+		{"1 >> 2", code.OpShiftRight},
+	}
+
+	for _, tt := range tests {
+		runCompilerTests(t, []compilerTestCase{
+			{
+				input:      tt.input,
+				wantConsts: []interface{}{1, 2},
+				wantInsns: []code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(tt.want),
+					code.Make(code.OpPop),
+				},
+			},
+		})
+	}
+
+	runCompilerTests(t, []compilerTestCase{
+		{
+			input:      "~1",
+			wantConsts: []interface{}{1},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpBitNot),
+				code.Make(code.OpPop),
+			},
+		},
+	})
+}
+
 func TestFloatArithmetic(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -311,9 +355,13 @@ func TestBooleanExpressions(t *testing.T) {
 			input:      "true && false",
 			wantConsts: []interface{}{},
 			wantInsns: []code.Instructions{
+				// 0000
 				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthyOrPop, 5),
+				// 0004
 				code.Make(code.OpFalse),
-				code.Make(code.OpAnd),
+				// 0005
 				code.Make(code.OpPop),
 			},
 		},
@@ -321,9 +369,13 @@ func TestBooleanExpressions(t *testing.T) {
 			input:      "true || false",
 			wantConsts: []interface{}{},
 			wantInsns: []code.Instructions{
+				// 0000
 				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpTruthyOrPop, 5),
+				// 0004
 				code.Make(code.OpFalse),
-				code.Make(code.OpOr),
+				// 0005
 				code.Make(code.OpPop),
 			},
 		},
@@ -362,9 +414,13 @@ func TestLogicalExpressions(t *testing.T) {
 			input:      "1 && 2",
 			wantConsts: []interface{}{1, 2},
 			wantInsns: []code.Instructions{
+				// 0000
 				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpJumpNotTruthyOrPop, 9),
+				// 0006
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpAnd),
+				// 0009
 				code.Make(code.OpPop),
 			},
 		},
@@ -372,9 +428,45 @@ func TestLogicalExpressions(t *testing.T) {
 			input:      "1 || 2",
 			wantConsts: []interface{}{1, 2},
 			wantInsns: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpJumpTruthyOrPop, 9),
+				// 0006
+				code.Make(code.OpConstant, 1),
+				// 0009
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:      "1 && 2 || 3",
+			wantConsts: []interface{}{1, 2, 3},
+			wantInsns: []code.Instructions{
+				// 0000
 				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpJumpNotTruthyOrPop, 9),
+				// 0006
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpOr),
+				// 0009
+				code.Make(code.OpJumpTruthyOrPop, 15),
+				// 0012
+				code.Make(code.OpConstant, 2),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:      "nil ?? 1",
+			wantConsts: []interface{}{1},
+			wantInsns: []code.Instructions{
+				// 0000
+				code.Make(code.OpNil),
+				// 0001
+				code.Make(code.OpJumpNotNilOrPop, 7),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
 				code.Make(code.OpPop),
 			},
 		},
@@ -429,6 +521,181 @@ func TestConditionals(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:      `if (true) { let x = 1; }`,
+			wantConsts: []interface{}{1},
+			wantInsns: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 13),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpSetLocal, 0),
+				// 0009
+				code.Make(code.OpNil),
+				// 0010
+				code.Make(code.OpJump, 14),
+				// 0013
+				code.Make(code.OpNil),
+				// 0014
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:      `while (true) { 1 }`,
+			wantConsts: []interface{}{1},
+			wantInsns: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 11),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 0),
+				// 0011
+				code.Make(code.OpNil),
+				// 0012
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:      `for (let i = 0; i < 10; i = i + 1) { if (i) { continue } break }`,
+			wantConsts: []interface{}{0, 10, 1},
+			wantInsns: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetLocal, 0),
+				// 0005
+				code.Make(code.OpConstant, 1),
+				// 0008
+				code.Make(code.OpGetLocal, 0),
+				// 0010
+				code.Make(code.OpGreaterThan),
+				// 0011
+				code.Make(code.OpJumpNotTruthy, 42),
+				// 0014
+				code.Make(code.OpGetLocal, 0),
+				// 0016
+				code.Make(code.OpJumpNotTruthy, 26),
+				// 0019
+				code.Make(code.OpJump, 31),
+				// 0022
+				code.Make(code.OpNil),
+				// 0023
+				code.Make(code.OpJump, 27),
+				// 0026
+				code.Make(code.OpNil),
+				// 0027
+				code.Make(code.OpPop),
+				// 0028
+				code.Make(code.OpJump, 42),
+				// 0031
+				code.Make(code.OpGetLocal, 0),
+				// 0033
+				code.Make(code.OpConstant, 2),
+				// 0036
+				code.Make(code.OpAdd),
+				// 0037
+				code.Make(code.OpAssignLocal, 0),
+				// 0039
+				code.Make(code.OpJump, 5),
+				// 0042
+				code.Make(code.OpNil),
+				// 0043
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:      `for (;;) { break }`,
+			wantConsts: []interface{}{},
+			wantInsns: []code.Instructions{
+				// 0000
+				code.Make(code.OpJump, 6),
+				// 0003
+				code.Make(code.OpJump, 0),
+				// 0006
+				code.Make(code.OpNil),
+				// 0007
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestForInLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:      `for (x in [1]) { x }`,
+			wantConsts: []interface{}{1},
+			wantInsns: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpIter),
+				// 0007
+				code.Make(code.OpIterNext, 19, 1),
+				// 0011
+				code.Make(code.OpSetLocal, 0),
+				// 0013
+				code.Make(code.OpGetLocal, 0),
+				// 0015
+				code.Make(code.OpPop),
+				// 0016
+				code.Make(code.OpJump, 7),
+				// 0019
+				code.Make(code.OpNil),
+				// 0020
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(h) { for (k, v in h) { break } }`,
+			wantConsts: []interface{}{
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpGetLocal, 0),
+					// 0002
+					code.Make(code.OpIter),
+					// 0003
+					code.Make(code.OpIterNext, 18, 2),
+					// 0007
+					code.Make(code.OpSetLocal, 1),
+					// 0009
+					code.Make(code.OpSetLocal, 2),
+					// 0011
+					code.Make(code.OpPop),
+					// 0012
+					code.Make(code.OpJump, 18),
+					// 0015
+					code.Make(code.OpJump, 3),
+					// 0018
+					code.Make(code.OpNil),
+					// 0019
+					code.Make(code.OpReturnValue),
+				},
+			},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -596,6 +863,19 @@ func TestStringExpressions(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:      `"n = ${1}!"`,
+			wantConsts: []interface{}{"n = ", 1, "!"},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpToString),
+				code.Make(code.OpAdd),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -669,18 +949,620 @@ func TestHashLiterals(t *testing.T) {
 			},
 		},
 		{
-			input:      "{1: 2 + 3, 4: 5 * 6}",
-			wantConsts: []interface{}{1, 2, 3, 4, 5, 6},
+			input:      "{1: 2 + 3, 4: 5 * 6}",
+			wantConsts: []interface{}{1, 2, 3, 4, 5, 6},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 5),
+				code.Make(code.OpMul),
+				code.Make(code.OpHash, 4),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestGetIndexExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:      "[1, 2, 3][1 + 1]",
+			wantConsts: []interface{}{1, 2, 3, 1, 1},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpArray, 3),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpAdd),
+				code.Make(code.OpGetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:      "{1: 2}[2 - 1]",
+			wantConsts: []interface{}{1, 2, 2, 1},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpSub),
+				code.Make(code.OpGetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestSliceExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:      "[1, 2, 3][1:2]",
+			wantConsts: []interface{}{1, 2, 3, 1, 2},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpArray, 3),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:      `"abc"[:-1]`,
+			wantConsts: []interface{}{"abc", 1},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpNil),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMinus),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:      `"abc"[1:]`,
+			wantConsts: []interface{}{"abc", 1},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpNil),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestSetIndexExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:      "a = [1, 2, 3]; a[1 + 1] = 2 - 2",
+			wantConsts: []interface{}{1, 2, 3, 1, 1, 2, 2},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpArray, 3),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpAdd),
+				code.Make(code.OpConstant, 5),
+				code.Make(code.OpConstant, 6),
+				code.Make(code.OpSub),
+				code.Make(code.OpSetIndex),
+			},
+		},
+		{
+			input:      "h = {}; h[1 * 2] = 3 / 4",
+			wantConsts: []interface{}{1, 2, 3, 4},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpDiv),
+				code.Make(code.OpSetIndex),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestMemberExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:      "let p = {}; p.age",
+			wantConsts: []interface{}{"age"},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpGetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:      "let p = {}; p.age = 30",
+			wantConsts: []interface{}{"age", 30},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetIndex),
+			},
+		},
+		{
+			input:      "let p = {}; p.greet(1)",
+			wantConsts: []interface{}{"greet", 1},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpGetMethod, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestOptionalChaining(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:      "let p = nil; p?.a.b",
+			wantConsts: []interface{}{"a", "b"},
+			wantInsns: []code.Instructions{
+				// 0000
+				code.Make(code.OpNil),
+				// 0001
+				code.Make(code.OpSetGlobal, 0),
+				// 0004
+				code.Make(code.OpGetGlobal, 0),
+				// 0007
+				code.Make(code.OpJumpNil, 18),
+				// 0010
+				code.Make(code.OpConstant, 0),
+				// 0013
+				code.Make(code.OpGetIndex),
+				// 0014
+				code.Make(code.OpConstant, 1),
+				// 0017
+				code.Make(code.OpGetIndex),
+				// 0018
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:      "let p = nil; p?.greet(1)",
+			wantConsts: []interface{}{"greet", 1},
+			wantInsns: []code.Instructions{
+				// 0000
+				code.Make(code.OpNil),
+				// 0001
+				code.Make(code.OpSetGlobal, 0),
+				// 0004
+				code.Make(code.OpGetGlobal, 0),
+				// 0007
+				code.Make(code.OpJumpNil, 18),
+				// 0010
+				code.Make(code.OpGetMethod, 0),
+				// 0013
+				code.Make(code.OpConstant, 1),
+				// 0016
+				code.Make(code.OpCall, 1),
+				// 0018
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:      "let xs = nil; xs?[0]?[1:]",
+			wantConsts: []interface{}{0, 1},
+			wantInsns: []code.Instructions{
+				// 0000
+				code.Make(code.OpNil),
+				// 0001
+				code.Make(code.OpSetGlobal, 0),
+				// 0004
+				code.Make(code.OpGetGlobal, 0),
+				// 0007
+				code.Make(code.OpJumpNil, 22),
+				// 0010
+				code.Make(code.OpConstant, 0),
+				// 0013
+				code.Make(code.OpGetIndex),
+				// 0014
+				code.Make(code.OpJumpNil, 22),
+				// 0017
+				code.Make(code.OpConstant, 1),
+				// 0020
+				code.Make(code.OpNil),
+				// 0021
+				code.Make(code.OpSlice),
+				// 0022
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// The chain in the argument ends before the call
+			input:      "let p = nil; len(p?.a)",
+			wantConsts: []interface{}{"a"},
+			wantInsns: []code.Instructions{
+				// 0000
+				code.Make(code.OpNil),
+				// 0001
+				code.Make(code.OpSetGlobal, 0),
+				// 0004
+				code.Make(code.OpGetBuiltin, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpJumpNil, 16),
+				// 0012
+				code.Make(code.OpConstant, 0),
+				// 0015
+				code.Make(code.OpGetIndex),
+				// 0016
+				code.Make(code.OpCall, 1),
+				// 0018
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTryStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:      "throw 1",
+			wantConsts: []interface{}{1},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpThrow),
+			},
+		},
+		{
+			input:      "try { throw 1 } catch (e) { e }",
+			wantConsts: []interface{}{1},
+			wantInsns: []code.Instructions{
+				// 0000
+				code.Make(code.OpTry, 12),
+				// 0003
+				code.Make(code.OpConstant, 0),
+				// 0006
+				code.Make(code.OpThrow),
+				// 0007
+				code.Make(code.OpNil),
+				// 0008
+				code.Make(code.OpEndTry),
+				// 0009
+				code.Make(code.OpJump, 19),
+				// 0012
+				code.Make(code.OpSetLocal, 0),
+				// 0014
+				code.Make(code.OpGetLocal, 0),
+				// 0016
+				code.Make(code.OpJump, 19),
+				// 0019
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:      "try { throw 1 } catch (e) { e } finally { 2 }",
+			wantConsts: []interface{}{1, 2, 2, 2},
+			wantInsns: []code.Instructions{
+				// 0000
+				code.Make(code.OpTry, 20),
+				// 0003
+				code.Make(code.OpConstant, 0),
+				// 0006
+				code.Make(code.OpThrow),
+				// 0007
+				code.Make(code.OpNil),
+				// 0008
+				code.Make(code.OpEndTry),
+				// 0009
+				code.Make(code.OpSetLocal, 0),
+				// 0011
+				code.Make(code.OpConstant, 1),
+				// 0014
+				code.Make(code.OpPop),
+				// 0015
+				code.Make(code.OpGetLocal, 0),
+				// 0017
+				code.Make(code.OpJump, 48),
+				// 0020
+				code.Make(code.OpSetLocal, 0),
+				// 0022
+				code.Make(code.OpTry, 39),
+				// 0025
+				code.Make(code.OpGetLocal, 0),
+				// 0027
+				code.Make(code.OpEndTry),
+				// 0028
+				code.Make(code.OpSetLocal, 1),
+				// 0030
+				code.Make(code.OpConstant, 2),
+				// 0033
+				code.Make(code.OpPop),
+				// 0034
+				code.Make(code.OpGetLocal, 1),
+				// 0036
+				code.Make(code.OpJump, 48),
+				// 0039
+				code.Make(code.OpSetLocal, 0),
+				// 0041
+				code.Make(code.OpConstant, 3),
+				// 0044
+				code.Make(code.OpPop),
+				// 0045
+				code.Make(code.OpGetLocal, 0),
+				// 0047
+				code.Make(code.OpThrow),
+				// 0048
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:      "while (true) { try { break } finally { 1 } }",
+			wantConsts: []interface{}{1, 1, 1},
+			wantInsns: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 41),
+				// 0004
+				code.Make(code.OpTry, 28),
+				// 0007
+				code.Make(code.OpEndTry),
+				// 0008
+				code.Make(code.OpConstant, 0),
+				// 0011
+				code.Make(code.OpPop),
+				// 0012
+				code.Make(code.OpJump, 41),
+				// 0015
+				code.Make(code.OpNil),
+				// 0016
+				code.Make(code.OpEndTry),
+				// 0017
+				code.Make(code.OpSetLocal, 0),
+				// 0019
+				code.Make(code.OpConstant, 1),
+				// 0022
+				code.Make(code.OpPop),
+				// 0023
+				code.Make(code.OpGetLocal, 0),
+				// 0025
+				code.Make(code.OpJump, 37),
+				// 0028
+				code.Make(code.OpSetLocal, 0),
+				// 0030
+				code.Make(code.OpConstant, 2),
+				// 0033
+				code.Make(code.OpPop),
+				// 0034
+				code.Make(code.OpGetLocal, 0),
+				// 0036
+				code.Make(code.OpThrow),
+				// 0037
+				code.Make(code.OpPop),
+				// 0038
+				code.Make(code.OpJump, 0),
+				// 0041
+				code.Make(code.OpNil),
+				// 0042
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestImports(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `import "testdata/one.monkey" as one; import "testdata/one.monkey" as again; one.x`,
+			wantConsts: []interface{}{
+				1,
+				&object.Module{Name: "testdata/one.monkey", Globals: map[string]int{"x": 0}},
+				"x",
+			},
+			wantInsns: []code.Instructions{
+				// The top level of the module with its own globals
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				// The module refers to the globals it exports
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpSetGlobal, 2),
+				// The second import reads the module loaded by the first one
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpSetGlobal, 3),
+				code.Make(code.OpGetGlobal, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpGetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestCompoundAssignments(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:      "let x = 1; x += 2; x++",
+			wantConsts: []interface{}{1, 2, 1},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input:      "let xs = [1]; xs[0] %= 2",
+			wantConsts: []interface{}{1, 0, 2},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDup, 2),
+				code.Make(code.OpGetIndex),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpMod),
+				code.Make(code.OpSetIndex),
+			},
+		},
+		{
+			input: "fn(n) { n -= 1; n *= 3 }",
+			wantConsts: []interface{}{
+				1,
+				3,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpAssignLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpMul),
+					code.Make(code.OpAssignLocal, 0),
+					code.Make(code.OpReturn),
+				},
+			},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:      "let [a, ...b] = [1]",
+			wantConsts: []interface{}{1},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpUnpackArray, 1, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+		{
+			input:      `let {x, y} = {}; [x, [y]] = [y, [x]]`,
+			wantConsts: []interface{}{"x", "y"},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpUnpackHash, 2),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpUnpackArray, 2, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpUnpackArray, 1, 0),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+		{
+			input: "fn(a, [b, c]) { a + b + c }",
+			wantConsts: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpUnpackArray, 2, 0),
+					code.Make(code.OpSetLocal, 2),
+					code.Make(code.OpSetLocal, 3),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal, 2),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetLocal, 3),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(a, b = 2, ...c) { b }",
+			wantConsts: []interface{}{
+				2,
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpJumpIfPassed, 9, 1),
+					// 0004
+					code.Make(code.OpConstant, 0),
+					// 0007
+					code.Make(code.OpSetLocal, 1),
+					// 0009
+					code.Make(code.OpGetLocal, 1),
+					// 0011
+					code.Make(code.OpReturnValue),
+				},
+			},
 			wantInsns: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpAdd),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
-				code.Make(code.OpConstant, 5),
-				code.Make(code.OpMul),
-				code.Make(code.OpHash, 4),
+				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -689,34 +1571,36 @@ func TestHashLiterals(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
-func TestGetIndexExpressions(t *testing.T) {
+func TestSpread(t *testing.T) {
 	tests := []compilerTestCase{
 		{
-			input:      "[1, 2, 3][1 + 1]",
-			wantConsts: []interface{}{1, 2, 3, 1, 1},
+			input:      "let a = []; [1, ...a, 2, 3]",
+			wantConsts: []interface{}{1, 2, 3},
 			wantInsns: []code.Instructions{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpExtendArray),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpConstant, 2),
-				code.Make(code.OpArray, 3),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
-				code.Make(code.OpAdd),
-				code.Make(code.OpGetIndex),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpExtendArray),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:      "{1: 2}[2 - 1]",
-			wantConsts: []interface{}{1, 2, 2, 1},
+			input:      "let a = []; len(...a)",
+			wantConsts: []interface{}{},
 			wantInsns: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpHash, 2),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpSub),
-				code.Make(code.OpGetIndex),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpExtendArray),
+				code.Make(code.OpCallSpread),
 				code.Make(code.OpPop),
 			},
 		},
@@ -725,41 +1609,24 @@ func TestGetIndexExpressions(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
-func TestSetIndexExpressions(t *testing.T) {
+func TestShortFunctionsAndPipes(t *testing.T) {
 	tests := []compilerTestCase{
 		{
-			input:      "a = [1, 2, 3]; a[1 + 1] = 2 - 2",
-			wantConsts: []interface{}{1, 2, 3, 1, 1, 2, 2},
-			wantInsns: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpArray, 3),
-				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
-				code.Make(code.OpAdd),
-				code.Make(code.OpConstant, 5),
-				code.Make(code.OpConstant, 6),
-				code.Make(code.OpSub),
-				code.Make(code.OpSetIndex),
+			input: "let f = |x| x; 1 |> f",
+			wantConsts: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+				1,
 			},
-		},
-		{
-			input:      "h = {}; h[1 * 2] = 3 / 4",
-			wantConsts: []interface{}{1, 2, 3, 4},
 			wantInsns: []code.Instructions{
-				code.Make(code.OpHash, 0),
+				code.Make(code.OpClosure, 0, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpMul),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpDiv),
-				code.Make(code.OpSetIndex),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
 			},
 		},
 	}
@@ -1059,6 +1926,90 @@ func TestLetStatementScopes(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestBlockScopes(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:      `let x = 1; if (true) { let x = 2; x }; x`,
+			wantConsts: []interface{}{1, 2},
+			wantInsns: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpTrue),
+				// 0007
+				code.Make(code.OpJumpNotTruthy, 20),
+				// 0010
+				code.Make(code.OpConstant, 1),
+				// 0013
+				code.Make(code.OpSetLocal, 0),
+				// 0015
+				code.Make(code.OpGetLocal, 0),
+				// 0017
+				code.Make(code.OpJump, 21),
+				// 0020
+				code.Make(code.OpNil),
+				// 0021
+				code.Make(code.OpPop),
+				// 0022
+				code.Make(code.OpGetGlobal, 0),
+				// 0025
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			fn() {
+				if (true) { let a = 1; a } else { let b = 2; b }
+			}
+			`,
+			wantConsts: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpTrue),
+					// 0001
+					code.Make(code.OpJumpNotTruthy, 14),
+					// 0004
+					code.Make(code.OpConstant, 0),
+					// 0007
+					code.Make(code.OpSetLocal, 0),
+					// 0009
+					code.Make(code.OpGetLocal, 0),
+					// 0011
+					code.Make(code.OpJump, 21),
+					// 0014
+					code.Make(code.OpConstant, 1),
+					// 0017
+					code.Make(code.OpSetLocal, 0),
+					// 0019
+					code.Make(code.OpGetLocal, 0),
+					// 0021
+					code.Make(code.OpReturnValue),
+				},
+			},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	// The slot of `a` is reused by `b` after the consequence ends
+	complr := New()
+	if err := complr.Compile(parse(tests[1].input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	fn := complr.Bytecode().Constants[2].(*object.CompiledFunction)
+	if fn.NumLocals != 1 {
+		t.Errorf("wrong number of locals. want=1, got=%d", fn.NumLocals)
+	}
+}
+
 func TestAssignmentStatementScopes(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -1072,7 +2023,7 @@ func TestAssignmentStatementScopes(t *testing.T) {
 				66,
 				[]code.Instructions{
 					code.Make(code.OpConstant, 1),
-					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpSetGlobal, 0),
 					code.Make(code.OpReturn),
 				},
 			},
@@ -1100,7 +2051,7 @@ func TestAssignmentStatementScopes(t *testing.T) {
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSetLocal, 0),
 					code.Make(code.OpConstant, 1),
-					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpAssignLocal, 0),
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpReturnValue),
 				},
@@ -1132,13 +2083,13 @@ func TestAssignmentStatementScopes(t *testing.T) {
 				99,
 				[]code.Instructions{
 					code.Make(code.OpConstant, 3),
-					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpSetFree, 0),
 					code.Make(code.OpConstant, 4),
-					code.Make(code.OpSetLocal, 1),
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpGetLocal, 1),
-					code.Make(code.OpAdd),
+					code.Make(code.OpSetFree, 1),
 					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetFree, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetFree, 2),
 					code.Make(code.OpAdd),
 					code.Make(code.OpReturnValue),
 				},
@@ -1149,8 +2100,10 @@ func TestAssignmentStatementScopes(t *testing.T) {
 					code.Make(code.OpSetLocal, 1),
 					code.Make(code.OpConstant, 2),
 					code.Make(code.OpSetLocal, 2),
-					code.Make(code.OpGetLocal, 2),
-					code.Make(code.OpClosure, 5, 1),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpCaptureLocal, 1),
+					code.Make(code.OpCaptureLocal, 2),
+					code.Make(code.OpClosure, 5, 3),
 					code.Make(code.OpPop),
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpGetLocal, 1),
@@ -1246,7 +2199,7 @@ func TestClosures(t *testing.T) {
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 0, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -1276,13 +2229,13 @@ func TestClosures(t *testing.T) {
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 0, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 1, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -1330,15 +2283,15 @@ func TestClosures(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpConstant, 2),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 4, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
 					code.Make(code.OpConstant, 1),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 5, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -1403,8 +2356,10 @@ func TestRecursiveFunctions(t *testing.T) {
 				},
 				1,
 				[]code.Instructions{
-					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpNil),
 					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpAssignLocal, 0),
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 2),
 					code.Make(code.OpCall, 1),
@@ -1419,6 +2374,93 @@ func TestRecursiveFunctions(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input: `
+			let f = fn() { g() };
+			let g = fn() { f() };
+			`,
+			wantConsts: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 1),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+		{
+			input: `
+			fn() {
+				let x = 1;
+				let f = fn() { g() };
+				let g = fn() { f() };
+			}
+			`,
+			wantConsts: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpNil),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpNil),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 2),
+					code.Make(code.OpCaptureLocal, 1),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpAssignLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 2, 1),
+					code.Make(code.OpAssignLocal, 1),
+					code.Make(code.OpReturn),
+				},
+			},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			let f = fn() {
+				fn() { f };
+			};
+			`,
+			wantConsts: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			wantInsns: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -1466,8 +2508,8 @@ func TestShadowingBuiltinFunctions(t *testing.T) {
 				2,
 				[]code.Instructions{
 					code.Make(code.OpConstant, 1),
-					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSetGlobal, 0),
+					code.Make(code.OpGetGlobal, 0),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -1485,6 +2527,83 @@ func TestShadowingBuiltinFunctions(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestCompilerErrorPositions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"x", "1:1: undefined variable \"x\""},
+		{"let a = 1;\nlet f = fn() {\n  a + b\n};", "3:7: undefined variable \"b\""},
+		{"let a = 1;\ncount += a", "2:7: undefined variable \"count\""},
+		{"1 = 2", "1:3: cannot assign to 1"},
+		{"let x = {a};", "1:9: unexpected pattern {a}"},
+		{"let {a} = {};\n{a} += 1", "2:5: cannot assign to {a}"},
+		{"if (true) { let y = 1 };\ny", "2:1: undefined variable \"y\""},
+		{"for (x in [1]) {}\nx", "2:1: undefined variable \"x\""},
+		{"const LIMIT = 10;\nLIMIT = 20", "2:7: cannot assign to constant \"LIMIT\""},
+		{"const n = 1;\nn += 1", "2:3: cannot assign to constant \"n\""},
+		{"const n = 1;\nn++", "2:2: cannot assign to constant \"n\""},
+		{"const [a, b] = [1, 2];\n[b, a] = [a, b]", "2:8: cannot assign to constant \"b\""},
+		{"const n = 1;\nlet f = fn() { n = 2 };", "2:18: cannot assign to constant \"n\""},
+		{"let f = fn() {\n  const g = fn() { 1 };\n  g = 2\n};", "3:5: cannot assign to constant \"g\""},
+		{"const f = fn() {\n  f = 1\n};", "2:5: cannot assign to constant \"f\""},
+		{"let g = fn() {\n  const f = fn() { [f] = [1] };\n};", "2:24: cannot assign to constant \"f\""},
+		{"const L = 10;\nlet L = 5;", "2:1: cannot redeclare constant \"L\""},
+		{"const L = 10;\nconst L = 5;", "2:1: cannot redeclare constant \"L\""},
+		{"const L = 10;\nlet [a, L] = [1, 2];", "2:1: cannot redeclare constant \"L\""},
+		{"const f = fn() { 1 };\nlet f = fn() { 2 };", "2:1: cannot redeclare constant \"f\""},
+		{"const L = 1;\nlet L = fn() { 2 };", "2:1: cannot redeclare constant \"L\""},
+		{"let f = fn() {\n  const L = 1;\n  let L = 2;\n};", "3:3: cannot redeclare constant \"L\""},
+		{"let p = {};\np?.a = 1", "2:6: cannot assign to (p?.a)"},
+		{"let xs = [];\nxs?[0] += 1", "2:8: cannot assign to (xs?[0])"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		cmplr := New()
+		err := cmplr.Compile(program)
+		if err == nil {
+			t.Fatalf("expected compiler error but resulted in none")
+		}
+
+		if err.Error() != tt.want {
+			t.Errorf("wrong compiler error. want=%q, got=%q", tt.want, err)
+		}
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	program := parse("1;\n  2 + 3")
+
+	cmplr := New()
+	if err := cmplr.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := cmplr.Bytecode()
+
+	tests := []struct {
+		ip   int
+		line int
+		col  int
+	}{
+		{0, 1, 1},  // OpConstant 1
+		{3, 1, 1},  // OpPop
+		{4, 2, 3},  // OpConstant 2
+		{7, 2, 7},  // OpConstant 3
+		{9, 2, 7},  // operand of OpConstant 3
+		{10, 2, 5}, // OpAdd
+	}
+
+	for _, tt := range tests {
+		pos := bytecode.SourceMap.Lookup(tt.ip)
+		if pos.Line != tt.line || pos.Column != tt.col {
+			t.Errorf("wrong position at %d. want=%d:%d, got=%s", tt.ip, tt.line, tt.col, pos)
+		}
+	}
+}
+
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 	t.Helper()
 
@@ -1568,6 +2687,11 @@ func testConstants(want []interface{}, got []object.Object) error {
 				return fmt.Errorf("constant %d - testInstructions failed: %s", i, err)
 			}
 
+		case *object.Module:
+			if !reflect.DeepEqual(c, got[i]) {
+				return fmt.Errorf("constant %d - wrong module. want=%#v, got=%#v", i, c, got[i])
+			}
+
 		default:
 			return fmt.Errorf("constant %d - unsupported constant type: %T", i, c)
 		}
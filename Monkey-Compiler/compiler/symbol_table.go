@@ -21,6 +21,8 @@ type Symbol struct {
 	Name  string
 	Scope SymbolScope
 	Index int
+	// Const reports whether the symbol is a constant, which cannot be assigned to.
+	Const bool
 }
 
 // SymbolTable is a mapping table of identifiers (names) and defined symbols.
@@ -29,13 +31,37 @@ type SymbolTable struct {
 
 	outer *SymbolTable
 
-	store   map[string]Symbol
+	store map[string]Symbol
+	// numDefs is the number of local slots a function needs, i.e. the largest number of local
+	// symbols defined at once in its scope and the blocks in it. It is counted in the symbol
+	// table of the function, or of the top level for the slots of the blocks in it.
 	numDefs int
+	// numSlots is the number of local slots in use in the scope, including the ones of the
+	// enclosing scopes of the same function.
+	numSlots int
+	// numGlobals points to the number of global symbols defined in a program and the modules
+	// it imports, which share the globals store. It is nil in local scopes.
+	numGlobals *int
+
+	// block reports whether the table is of a block, which shares the slots of the function
+	// or the top level enclosing it. Its slots are reused once the block ends.
+	block bool
 }
 
 // NewSymbolTable creates a new symbol table.
 func NewSymbolTable() *SymbolTable {
-	return NewEnclosedSymbolTable(nil)
+	s := NewEnclosedSymbolTable(nil)
+	s.numGlobals = new(int)
+	return s
+}
+
+// NewModuleSymbolTable creates a new symbol table for the top level of a module imported by a
+// program whose top level symbols are in `main`. The global symbols of the module are numbered
+// after the ones of the program, because they share the globals store.
+func NewModuleSymbolTable(main *SymbolTable) *SymbolTable {
+	s := NewEnclosedSymbolTable(nil)
+	s.numGlobals = main.numGlobals
+	return s
 }
 
 // NewEnclosedSymbolTable creates a new symbol table with an outer one.
@@ -47,15 +73,38 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	}
 }
 
-// Define defines an identifier as a symbol in a scope.
+// NewBlockSymbolTable creates a new symbol table for a block in the scope of `outer`.
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewEnclosedSymbolTable(outer)
+	s.numSlots = outer.numSlots
+	s.numGlobals = outer.numGlobals
+	s.block = true
+	return s
+}
+
+// Define defines an identifier as a symbol in a scope. A symbol of a block is local even at the
+// top level, so that it is bound anew each time the block runs.
 func (s *SymbolTable) Define(name string) Symbol {
-	scope := GlobalScope
-	if s.hasOuter() {
-		scope = LocalScope
+	fn := s.function()
+	if !fn.hasOuter() && !s.block {
+		sym := s.define(name, GlobalScope, *s.numGlobals)
+		*s.numGlobals++
+		return sym
 	}
 
-	sym := s.define(name, scope, s.numDefs)
-	s.numDefs++
+	sym := s.define(name, LocalScope, s.numSlots)
+	s.numSlots++
+	if s.numSlots > fn.numDefs {
+		fn.numDefs = s.numSlots
+	}
+	return sym
+}
+
+// DefineConst defines an identifier as a constant symbol in a scope.
+func (s *SymbolTable) DefineConst(name string) Symbol {
+	sym := s.Define(name)
+	sym.Const = true
+	s.store[name] = sym
 	return sym
 }
 
@@ -73,7 +122,10 @@ func (s *SymbolTable) DefineFunctionName(name string) Symbol {
 func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	s.freeSymbols = append(s.freeSymbols, original)
 
-	return s.define(original.Name, FreeScope, len(s.freeSymbols)-1)
+	sym := s.define(original.Name, FreeScope, len(s.freeSymbols)-1)
+	sym.Const = original.Const
+	s.store[sym.Name] = sym
+	return sym
 }
 
 func (s *SymbolTable) define(name string, scope SymbolScope, index int) Symbol {
@@ -91,13 +143,37 @@ func (s *SymbolTable) Resolve(name string) (sym Symbol, exists bool) {
 	}
 
 	sym, exists = s.outer.Resolve(name)
-	if exists && (sym.Scope == LocalScope || sym.Scope == FreeScope) {
-		// Define an outer local or free variable as a free variable in the current scope
+	if exists && !s.block && sym.Scope != GlobalScope && sym.Scope != BuiltinScope {
+		// Define an outer local or free variable, or an outer function referred to by its name,
+		// as a free variable in the current scope
 		sym = s.defineFree(sym)
 	}
 	return sym, exists
 }
 
+// ResolveVariable resolves an identifier like Resolve, except that the name of a function
+// referred to within it resolves to the variable the function is bound to, which then replaces
+// the name in the scope of the function.
+func (s *SymbolTable) ResolveVariable(name string) (sym Symbol, exists bool) {
+	sym, exists = s.store[name]
+	switch {
+	case exists && sym.Scope != FunctionScope:
+		return sym, true
+	case !s.hasOuter():
+		return Symbol{}, false
+	}
+
+	_, named := s.store[name]
+	sym, exists = s.outer.ResolveVariable(name)
+	switch {
+	case exists && !s.block && sym.Scope != GlobalScope && sym.Scope != BuiltinScope:
+		sym = s.defineFree(sym)
+	case exists && named:
+		s.store[name] = sym
+	}
+	return sym, exists
+}
+
 // ResolveCurrentScope resolves an identifier within the current scope and returns a defined
 // symbol and `true` if it is defined, otherwise returns an empty symbol and `false`.
 func (s *SymbolTable) ResolveCurrentScope(name string) (sym Symbol, exists bool) {
@@ -105,6 +181,14 @@ func (s *SymbolTable) ResolveCurrentScope(name string) (sym Symbol, exists bool)
 	return sym, exists
 }
 
+// function returns the symbol table of the function or the top level enclosing `s`.
+func (s *SymbolTable) function() *SymbolTable {
+	for s.block {
+		s = s.outer
+	}
+	return s
+}
+
 // hasOuter returns true if `s` has an outer symbol table, otherwise false.
 func (s *SymbolTable) hasOuter() bool {
 	return s.outer != nil
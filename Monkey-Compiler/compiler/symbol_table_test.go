@@ -49,6 +49,140 @@ func TestDefine(t *testing.T) {
 	}
 }
 
+func TestDefineInModule(t *testing.T) {
+	main := NewSymbolTable()
+	main.Define("a")
+
+	mod := NewModuleSymbolTable(main)
+	mod.Define("b")
+	main.Define("c")
+
+	wantSymbols := []struct {
+		table *SymbolTable
+		want  Symbol
+	}{
+		{main, Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{mod, Symbol{Name: "b", Scope: GlobalScope, Index: 1}},
+		{main, Symbol{Name: "c", Scope: GlobalScope, Index: 2}},
+	}
+
+	for _, tt := range wantSymbols {
+		got, ok := tt.table.Resolve(tt.want.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", tt.want.Name)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", tt.want.Name, tt.want, got)
+		}
+	}
+
+	if _, ok := mod.Resolve("a"); ok {
+		t.Errorf("symbol %q of the program is resolvable in the module", "a")
+	}
+}
+
+func TestDefineInBlock(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	globalBlock := NewBlockSymbolTable(global)
+	globalBlock.Define("a")
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("b")
+
+	firstBlock := NewBlockSymbolTable(local)
+	firstBlock.Define("c")
+	firstBlock.Define("d")
+
+	secondBlock := NewBlockSymbolTable(local)
+	secondBlock.Define("e")
+
+	nestedBlock := NewBlockSymbolTable(secondBlock)
+	nestedBlock.Define("b")
+
+	nestedLocal := NewEnclosedSymbolTable(nestedBlock)
+
+	wantSymbols := []struct {
+		table *SymbolTable
+		want  Symbol
+	}{
+		{global, Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{globalBlock, Symbol{Name: "a", Scope: LocalScope, Index: 0}},
+		{local, Symbol{Name: "b", Scope: LocalScope, Index: 0}},
+		{firstBlock, Symbol{Name: "b", Scope: LocalScope, Index: 0}},
+		{firstBlock, Symbol{Name: "c", Scope: LocalScope, Index: 1}},
+		{firstBlock, Symbol{Name: "d", Scope: LocalScope, Index: 2}},
+		// The slots of the first block are reused after it ends
+		{secondBlock, Symbol{Name: "e", Scope: LocalScope, Index: 1}},
+		{nestedBlock, Symbol{Name: "b", Scope: LocalScope, Index: 2}},
+		{nestedBlock, Symbol{Name: "e", Scope: LocalScope, Index: 1}},
+		{nestedLocal, Symbol{Name: "b", Scope: FreeScope, Index: 0}},
+		{nestedLocal, Symbol{Name: "e", Scope: FreeScope, Index: 1}},
+	}
+
+	for _, tt := range wantSymbols {
+		got, ok := tt.table.Resolve(tt.want.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", tt.want.Name)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", tt.want.Name, tt.want, got)
+		}
+	}
+
+	for _, name := range []string{"c", "d"} {
+		if _, ok := local.Resolve(name); ok {
+			t.Errorf("symbol %q of the block is resolvable out of it", name)
+		}
+	}
+
+	if global.numDefs != 1 {
+		t.Errorf("wrong number of top level slots. want=1, got=%d", global.numDefs)
+	}
+	if local.numDefs != 3 {
+		t.Errorf("wrong number of local slots. want=3, got=%d", local.numDefs)
+	}
+	if len(nestedBlock.freeSymbols) != 0 {
+		t.Errorf("block has free symbols. got=%+v", nestedBlock.freeSymbols)
+	}
+}
+
+func TestDefineConst(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineConst("a")
+	global.Define("b")
+
+	local := NewEnclosedSymbolTable(global)
+	local.DefineConst("c")
+
+	nested := NewEnclosedSymbolTable(local)
+
+	wantSymbols := []struct {
+		table *SymbolTable
+		want  Symbol
+	}{
+		{global, Symbol{Name: "a", Scope: GlobalScope, Index: 0, Const: true}},
+		{global, Symbol{Name: "b", Scope: GlobalScope, Index: 1}},
+		{local, Symbol{Name: "c", Scope: LocalScope, Index: 0, Const: true}},
+		{nested, Symbol{Name: "a", Scope: GlobalScope, Index: 0, Const: true}},
+		{nested, Symbol{Name: "c", Scope: FreeScope, Index: 0, Const: true}},
+	}
+
+	for _, tt := range wantSymbols {
+		got, ok := tt.table.Resolve(tt.want.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", tt.want.Name)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", tt.want.Name, tt.want, got)
+		}
+	}
+}
+
 func TestResolveGlobal(t *testing.T) {
 	global := NewSymbolTable()
 	global.Define("a")
@@ -452,3 +586,38 @@ func TestShadowingFunctionNameCurrentScope(t *testing.T) {
 		t.Errorf("expected %q to resolve to %+v, but got %+v", want.Name, want, got)
 	}
 }
+
+func TestResolveVariableOfFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("b")
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	secondLocal.DefineFunctionName("b")
+	thirdLocal := NewEnclosedSymbolTable(firstLocal)
+	thirdLocal.DefineFunctionName("a")
+
+	tests := []struct {
+		table *SymbolTable
+		want  Symbol
+	}{
+		{secondLocal, Symbol{Name: "b", Scope: FreeScope, Index: 0}},
+		{thirdLocal, Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+	}
+
+	for _, tt := range tests {
+		got, ok := tt.table.ResolveVariable(tt.want.Name)
+		if !ok {
+			t.Fatalf("name %q not resolvable", tt.want.Name)
+		}
+		if got != tt.want {
+			t.Errorf("expected %q to resolve to %+v, but got %+v", tt.want.Name, tt.want, got)
+		}
+
+		// The function name is replaced by the variable
+		got, _ = tt.table.Resolve(tt.want.Name)
+		if got != tt.want {
+			t.Errorf("expected %q to resolve to %+v afterwards, but got %+v", tt.want.Name, tt.want, got)
+		}
+	}
+}
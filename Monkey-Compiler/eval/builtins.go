@@ -5,10 +5,11 @@ import (
 )
 
 var builtins = map[string]*object.Builtin{
-	"len":   object.GetBuiltinByName("len"),
-	"puts":  object.GetBuiltinByName("puts"),
-	"first": object.GetBuiltinByName("first"),
-	"last":  object.GetBuiltinByName("last"),
-	"rest":  object.GetBuiltinByName("rest"),
-	"push":  object.GetBuiltinByName("push"),
+	"len":    object.GetBuiltinByName("len"),
+	"puts":   object.GetBuiltinByName("puts"),
+	"first":  object.GetBuiltinByName("first"),
+	"last":   object.GetBuiltinByName("last"),
+	"rest":   object.GetBuiltinByName("rest"),
+	"push":   object.GetBuiltinByName("push"),
+	"freeze": object.GetBuiltinByName("freeze"),
 }
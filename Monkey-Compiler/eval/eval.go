@@ -2,9 +2,13 @@ package eval
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 
 	"monkey-compiler/ast"
 	"monkey-compiler/object"
+	"monkey-compiler/token"
 )
 
 var (
@@ -14,8 +18,59 @@ var (
 	TrueValue = &object.Boolean{Value: true}
 	// FalseValue represents a value of false literals.
 	FalseValue = &object.Boolean{Value: false}
+
+	breakValue    = &loopControl{keyword: "break"}
+	continueValue = &loopControl{keyword: "continue"}
+
+	skippedChain = &chainControl{link: "?."}
+
+	// callDepth is the number of the function calls being evaluated.
+	callDepth int
 )
 
+// MaxCallDepth is the maximum number of nested function calls. A call beyond it raises a stack
+// overflow error, as it does in the VM, rather than overflowing the Go stack.
+const MaxCallDepth = 1024
+
+// loopControlType represents a type of loop controls.
+const loopControlType object.Type = "LoopControl"
+
+// loopControl is the result of a break or continue statement. Like a return value, it stops
+// the evaluation of the enclosing blocks up to the innermost loop.
+type loopControl struct {
+	keyword string
+}
+
+// Type returns the type of the loopControl.
+func (lc *loopControl) Type() object.Type {
+	return loopControlType
+}
+
+// Inspect returns a string representation of the loopControl.
+func (lc *loopControl) Inspect() string {
+	return lc.keyword
+}
+
+// chainControlType represents a type of chain controls.
+const chainControlType object.Type = "ChainControl"
+
+// chainControl is the value of the links of a chain of index, member, slice and call expressions
+// after an optional link whose object is nil. It skips the rest of the chain, which results in
+// nil.
+type chainControl struct {
+	link string
+}
+
+// Type returns the type of the chainControl.
+func (cc *chainControl) Type() object.Type {
+	return chainControlType
+}
+
+// Inspect returns a string representation of the chainControl.
+func (cc *chainControl) Inspect() string {
+	return cc.link
+}
+
 // Eval evaluates the given node and returns an evaluated object.
 func Eval(node ast.Node, env object.Environment) object.Object {
 	switch node := node.(type) {
@@ -35,14 +90,48 @@ func Eval(node ast.Node, env object.Environment) object.Object {
 		return &object.ReturnValue{Value: value}
 
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		return evalBlockStatement(node, object.NewEnclosedEnvironment(env))
+
+	case *ast.ThrowStatement:
+		value := Eval(node.Value, env)
+		if isError(value) {
+			return value
+		}
+		return object.NewThrownError(value)
+
+	case *ast.TryStatement:
+		return evalTryStatement(node, env)
 
 	case *ast.LetStatement:
 		value := Eval(node.Value, env)
 		if isError(value) {
 			return value
 		}
-		env.Set(node.Name.Value, value)
+		if err := bindPattern(node.Target(), value, env, node.Binding()); err != nil {
+			return err
+		}
+
+	case *ast.AssignStatement:
+		return evalAssignStatement(node, env)
+
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+
+	case *ast.ForInStatement:
+		return evalForInStatement(node, env)
+
+	case *ast.BreakStatement:
+		return breakValue
+
+	case *ast.ContinueStatement:
+		return continueValue
+
+	case *ast.ImportStatement:
+		// Modules are loaded by the compiler, which runs programs read from files
+		return newError(object.GenericError, "import is not supported by the evaluator")
 
 	// Expressions
 
@@ -55,6 +144,9 @@ func Eval(node ast.Node, env object.Environment) object.Object {
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 
+	case *ast.Nil:
+		return NilValue
+
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
 		if isError(right) {
@@ -67,6 +159,9 @@ func Eval(node ast.Node, env object.Environment) object.Object {
 		if isError(left) {
 			return left
 		}
+		if node.Operator == "&&" || node.Operator == "||" || node.Operator == "??" {
+			return evalLogicalExpression(node, left, env)
+		}
 		right := Eval(node.Right, env)
 		if isError(right) {
 			return right
@@ -79,20 +174,67 @@ func Eval(node ast.Node, env object.Environment) object.Object {
 	case *ast.Ident:
 		return evalIdent(node, env)
 
+	case *ast.ArrayPattern, *ast.HashPattern:
+		return newError(object.GenericError, "unexpected pattern %s", node)
+
 	case *ast.FunctionLiteral:
 		return &object.Function{
 			Parameters: node.Parameters,
+			Defaults:   node.Defaults,
+			Rest:       node.Rest,
 			Body:       node.Body,
 			Env:        env,
 		}
 
+	case *ast.CallExpression, *ast.IndexExpression, *ast.MemberExpression, *ast.SliceExpression:
+		if result := evalChainLink(node, env); result != skippedChain {
+			return result
+		}
+		return NilValue
+
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}
+
+	case *ast.StringConversion:
+		value := Eval(node.Value, env)
+		if isError(value) {
+			return value
+		}
+		if str, ok := value.(*object.String); ok {
+			return str
+		}
+		return &object.String{Value: value.Inspect()}
+
+	case *ast.ArrayLiteral:
+		elems := evalExpressions(node.Elements, env)
+		if len(elems) == 1 && isError(elems[0]) {
+			return elems[0]
+		}
+		return &object.Array{Elements: elems}
+
+	case *ast.HashLiteral:
+		return evalHashLiteral(node, env)
+	}
+
+	return nil
+}
+
+// evalChainLink evaluates a link in a chain of index, member, slice and call expressions, e.g.
+// `a?.b[0].c()`. It returns skippedChain if an optional link in the chain skipped the rest of it.
+func evalChainLink(node ast.Node, env object.Environment) object.Object {
+	switch node := node.(type) {
 	case *ast.CallExpression:
 		if node.Function.TokenLiteral() == FuncNameQuote {
 			return quote(node.Arguments[0], env)
 		}
 
-		function := Eval(node.Function, env)
-		if isError(function) {
+		var function, receiver object.Object
+		if member, ok := node.Function.(*ast.MemberExpression); ok {
+			function, receiver = evalMethod(member, env)
+		} else {
+			function = evalChainObject(node.Function, false, env)
+		}
+		if function == skippedChain || isError(function) {
 			return function
 		}
 
@@ -101,21 +243,16 @@ func Eval(node ast.Node, env object.Environment) object.Object {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
-
-	case *ast.StringLiteral:
-		return &object.String{Value: node.Value}
-
-	case *ast.ArrayLiteral:
-		elems := evalExpressions(node.Elements, env)
-		if len(elems) == 1 && isError(elems[0]) {
-			return elems[0]
+		// A method is called with its receiver as the first argument
+		if receiver != nil {
+			args = append([]object.Object{receiver}, args...)
 		}
-		return &object.Array{Elements: elems}
+
+		return applyFunction(function, args)
 
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
-		if isError(left) {
+		left := evalChainObject(node.Left, node.Optional(), env)
+		if left == skippedChain || isError(left) {
 			return left
 		}
 		index := Eval(node.Index, env)
@@ -124,13 +261,38 @@ func Eval(node ast.Node, env object.Environment) object.Object {
 		}
 		return evalIndexExpression(left, index)
 
-	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+	case *ast.MemberExpression:
+		return evalChainLink(node.IndexExpression(), env)
+
+	case *ast.SliceExpression:
+		return evalSliceExpression(node, env)
 	}
 
 	return nil
 }
 
+// evalChainObject evaluates the object of a link in a chain, e.g. `a` in `a.b`. If the link is
+// optional and the object is nil, it returns skippedChain. An object in parentheses is a chain of
+// its own.
+func evalChainObject(obj ast.Expression, optional bool, env object.Environment) object.Object {
+	var value object.Object
+	switch obj.(type) {
+	case *ast.CallExpression, *ast.IndexExpression, *ast.MemberExpression, *ast.SliceExpression:
+		if ast.IsGrouped(obj) {
+			value = Eval(obj, env)
+		} else {
+			value = evalChainLink(obj, env)
+		}
+	default:
+		value = Eval(obj, env)
+	}
+
+	if optional && value == NilValue {
+		return skippedChain
+	}
+	return value
+}
+
 func evalProgram(program *ast.Program, env object.Environment) object.Object {
 	var result object.Object
 
@@ -161,8 +323,10 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 		return evalBangOperatorExpression(right)
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
+	case "~":
+		return evalBitNotPrefixOperatorExpression(right)
 	default:
-		return newError("unknown operator: %s%s", operator, right.Type())
+		return newError(object.TypeError, "unknown operator: %s%s", operator, right.Type())
 	}
 }
 
@@ -180,12 +344,22 @@ func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
 	case *object.Float:
 		return &object.Float{Value: -right.Value}
 	default:
-		return newError("unknown operator: -%s", right.Type())
+		return newError(object.TypeError, "unknown operator: -%s", right.Type())
+	}
+}
+
+func evalBitNotPrefixOperatorExpression(right object.Object) object.Object {
+	if right, ok := right.(*object.Integer); ok {
+		return &object.Integer{Value: ^right.Value}
 	}
+	return newError(object.TypeError, "unknown operator: ~%s", right.Type())
 }
 
 func evalInfixExpression(operator string, left, right object.Object) object.Object {
 	switch {
+	case operator == "**" && object.IsNegativeInteger(right):
+		// An integer to the power of a negative integer is a fraction
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() == object.IntegerType && right.Type() == object.IntegerType:
 		return evalIntegerInfixExpression(operator, left, right)
 	case left.Type() == object.FloatType || right.Type() == object.FloatType:
@@ -197,9 +371,15 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	case operator == "!=":
 		return nativeBoolToBooleanObject(left != right)
 	case left.Type() != right.Type():
-		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+		return newError(
+			object.TypeError,
+			"type mismatch: %s %s %s", left.Type(), operator, right.Type(),
+		)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(
+			object.TypeError,
+			"unknown operator: %s %s %s", left.Type(), operator, right.Type(),
+		)
 	}
 }
 
@@ -214,8 +394,35 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 		return &object.Integer{Value: leftVal - rightVal}
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
-	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+	case "/", "//":
+		if rightVal == 0 {
+			return newError(object.ArithmeticError, "integer division by zero")
+		}
+		if operator == "/" {
+			return &object.Integer{Value: leftVal / rightVal}
+		}
+		return &object.Integer{Value: object.FloorDiv(leftVal, rightVal)}
+	case "%":
+		if rightVal == 0 {
+			return newError(object.ArithmeticError, "integer modulo by zero")
+		}
+		return &object.Integer{Value: object.FloorMod(leftVal, rightVal)}
+	case "**":
+		return &object.Integer{Value: object.IntPow(leftVal, rightVal)}
+	case "&":
+		return &object.Integer{Value: leftVal & rightVal}
+	case "|":
+		return &object.Integer{Value: leftVal | rightVal}
+	case "^":
+		return &object.Integer{Value: leftVal ^ rightVal}
+	case "<<", ">>":
+		if rightVal < 0 {
+			return newError(object.ArithmeticError, "negative shift count: %d", rightVal)
+		}
+		if operator == "<<" {
+			return &object.Integer{Value: leftVal << rightVal}
+		}
+		return &object.Integer{Value: leftVal >> rightVal}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
@@ -229,7 +436,10 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(
+			object.TypeError,
+			"unknown operator: %s %s %s", left.Type(), operator, right.Type(),
+		)
 	}
 }
 
@@ -242,7 +452,10 @@ func evalFloatInfixExpression(operator string, left, right object.Object) object
 	case *object.Float:
 		leftVal = left.Value
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(
+			object.TypeError,
+			"unknown operator: %s %s %s", left.Type(), operator, right.Type(),
+		)
 	}
 
 	switch right := right.(type) {
@@ -251,7 +464,10 @@ func evalFloatInfixExpression(operator string, left, right object.Object) object
 	case *object.Float:
 		rightVal = right.Value
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(
+			object.TypeError,
+			"unknown operator: %s %s %s", left.Type(), operator, right.Type(),
+		)
 	}
 
 	switch operator {
@@ -263,6 +479,12 @@ func evalFloatInfixExpression(operator string, left, right object.Object) object
 		return &object.Float{Value: leftVal * rightVal}
 	case "/":
 		return &object.Float{Value: leftVal / rightVal}
+	case "//":
+		return &object.Float{Value: math.Floor(leftVal / rightVal)}
+	case "%":
+		return &object.Float{Value: object.FloatFloorMod(leftVal, rightVal)}
+	case "**":
+		return &object.Float{Value: math.Pow(leftVal, rightVal)}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
@@ -276,7 +498,10 @@ func evalFloatInfixExpression(operator string, left, right object.Object) object
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(
+			object.TypeError,
+			"unknown operator: %s %s %s", left.Type(), operator, right.Type(),
+		)
 	}
 }
 
@@ -292,8 +517,26 @@ func evalStringInfixExpression(operator string, left, right object.Object) objec
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(
+			object.TypeError,
+			"unknown operator: %s %s %s", left.Type(), operator, right.Type(),
+		)
+	}
+}
+
+// evalLogicalExpression evaluates the right operand of `&&` or `||` only if the value of the
+// left operand does not decide the result. The result is the value of the operand evaluated last.
+func evalLogicalExpression(
+	node *ast.InfixExpression, left object.Object, env object.Environment,
+) object.Object {
+	if node.Operator == "??" {
+		if left != NilValue {
+			return left
+		}
+	} else if isTruthy(left) == (node.Operator == "||") {
+		return left
 	}
+	return Eval(node.Right, env)
 }
 
 func evalBlockStatement(block *ast.BlockStatement, env object.Environment) object.Object {
@@ -305,7 +548,8 @@ func evalBlockStatement(block *ast.BlockStatement, env object.Environment) objec
 			continue
 		}
 
-		if rt := result.Type(); rt == object.ReturnValueType || rt == object.ErrorType {
+		if rt := result.Type(); rt == object.ReturnValueType || rt == object.ErrorType ||
+			rt == loopControlType {
 			return result
 		}
 	}
@@ -319,20 +563,285 @@ func evalIfExpression(ie *ast.IfExpression, env object.Environment) object.Objec
 		return condition
 	}
 
+	var result object.Object
 	if isTruthy(condition) {
-		return Eval(ie.Consequence, env)
+		result = Eval(ie.Consequence, env)
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
+		result = Eval(ie.Alternative, env)
 	}
-	return NilValue
+
+	// A block which does not end with an expression has no value
+	if result == nil {
+		return NilValue
+	}
+	return result
+}
+
+func evalAssignStatement(node *ast.AssignStatement, env object.Environment) object.Object {
+	target := node.LHS
+	// A member is assigned to by the index expression the member access is sugar for
+	if member, ok := target.(*ast.MemberExpression); ok {
+		target = member.IndexExpression()
+	}
+
+	switch lhs := target.(type) {
+	case *ast.Ident:
+		var current object.Object
+		if node.IsCompound() {
+			current = evalIdent(lhs, env)
+			if isError(current) {
+				return current
+			}
+		}
+		value := evalAssignedValue(node, current, env)
+		if isError(value) {
+			return value
+		}
+		if err := assign(lhs.Value, value, env); err != nil {
+			return err
+		}
+
+	case *ast.IndexExpression:
+		if lhs.Optional() {
+			return newError(object.GenericError, "cannot assign to %s", node.LHS)
+		}
+		left := Eval(lhs.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := Eval(lhs.Index, env)
+		if isError(index) {
+			return index
+		}
+		var current object.Object
+		if node.IsCompound() {
+			current = evalIndexExpression(left, index)
+			if isError(current) {
+				return current
+			}
+		}
+		value := evalAssignedValue(node, current, env)
+		if isError(value) {
+			return value
+		}
+		return evalSetIndexExpression(left, index, value)
+
+	case *ast.ArrayPattern, *ast.HashPattern:
+		if node.IsCompound() {
+			return newError(object.GenericError, "cannot assign to %s", node.LHS)
+		}
+		value := Eval(node.RHS, env)
+		if isError(value) {
+			return value
+		}
+		if err := bindPattern(lhs.(ast.Pattern), value, env, ast.AssignBinding); err != nil {
+			return err
+		}
+
+	default:
+		return newError(object.GenericError, "cannot assign to %s", node.LHS)
+	}
+
+	return nil
+}
+
+// evalAssignedValue returns the value to assign to the left-hand side of `node`. `current` is
+// the current value of the left-hand side, which is only needed for a compound assignment.
+func evalAssignedValue(
+	node *ast.AssignStatement, current object.Object, env object.Environment,
+) object.Object {
+	switch node.Token.Type {
+	case token.ASSIGN:
+		return Eval(node.RHS, env)
+	case token.INC:
+		return evalInfixExpression("+", current, &object.Integer{Value: 1})
+	case token.DEC:
+		return evalInfixExpression("-", current, &object.Integer{Value: 1})
+	}
+
+	value := Eval(node.RHS, env)
+	if isError(value) {
+		return value
+	}
+
+	// The operator of a compound assignment is the assignment operator without `=`
+	operator := strings.TrimSuffix(node.Token.Literal, "=")
+	return evalInfixExpression(operator, current, value)
+}
+
+func evalSetIndexExpression(left, index, value object.Object) object.Object {
+	switch {
+	case left.Type() == object.ArrayType && index.Type() == object.IntegerType:
+		arrObj := left.(*object.Array)
+		if arrObj.Frozen {
+			return newError(object.TypeError, "cannot modify frozen Array")
+		}
+		idx, ok := object.Index(index.(*object.Integer).Value, len(arrObj.Elements))
+		if !ok {
+			return newError(
+				object.IndexError,
+				"array index %d out of range", index.(*object.Integer).Value,
+			)
+		}
+		arrObj.Elements[idx] = value
+	case left.Type() == object.HashType:
+		hashObj := left.(*object.Hash)
+		if hashObj.Frozen {
+			return newError(object.TypeError, "cannot modify frozen Hash")
+		}
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError(object.TypeError, "unusable as hash key: %s", index.Type())
+		}
+		hashObj.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+	default:
+		return newError(object.TypeError, "index operator not supported: %s", left.Type())
+	}
+
+	return nil
+}
+
+func evalWhileStatement(ws *ast.WhileStatement, env object.Environment) object.Object {
+	for {
+		condition := Eval(ws.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return nil
+		}
+
+		if result, done := evalLoopBody(ws.Body, env); done {
+			return result
+		}
+	}
+}
+
+func evalForStatement(fs *ast.ForStatement, env object.Environment) object.Object {
+	// The variables defined by the init statement are scoped to the loop
+	env = object.NewEnclosedEnvironment(env)
+
+	if fs.Init != nil {
+		if init := Eval(fs.Init, env); isError(init) {
+			return init
+		}
+	}
+
+	for {
+		if fs.Condition != nil {
+			condition := Eval(fs.Condition, env)
+			if isError(condition) {
+				return condition
+			}
+			if !isTruthy(condition) {
+				return nil
+			}
+		}
+
+		if result, done := evalLoopBody(fs.Body, env); done {
+			return result
+		}
+
+		if fs.Update != nil {
+			if update := Eval(fs.Update, env); isError(update) {
+				return update
+			}
+		}
+	}
+}
+
+func evalForInStatement(fs *ast.ForInStatement, env object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	iter, ok := object.NewIterator(iterable)
+	if !ok {
+		return newError(object.TypeError, "%s is not iterable", iterable.Type())
+	}
+
+	for {
+		// The loop variables are scoped to the loop, and bound anew in each iteration
+		iterEnv := object.NewEnclosedEnvironment(env)
+
+		if fs.Key == nil {
+			elem, ok := iter.NextElement()
+			if !ok {
+				return nil
+			}
+			iterEnv.Set(fs.Value.Value, elem)
+		} else {
+			key, value, ok := iter.Next()
+			if !ok {
+				return nil
+			}
+			iterEnv.Set(fs.Key.Value, key)
+			iterEnv.Set(fs.Value.Value, value)
+		}
+
+		if result, done := evalLoopBody(fs.Body, iterEnv); done {
+			return result
+		}
+	}
+}
+
+// evalLoopBody evaluates one iteration of a loop body. It reports whether the loop is done,
+// along with the result of the loop if it is.
+func evalLoopBody(body *ast.BlockStatement, env object.Environment) (object.Object, bool) {
+	switch result := Eval(body, env); {
+	case result == breakValue:
+		return nil, true
+	case result == continueValue:
+		return nil, false
+	case result != nil && (result.Type() == object.ReturnValueType || isError(result)):
+		return result, true
+	default:
+		return nil, false
+	}
+}
+
+// evalTryStatement evaluates a try statement. An error raised in the body is caught by the catch
+// clause, and the finally block is evaluated however the body and the catch clause end. The
+// value of the statement is the value of the body, or of the catch clause if it caught an
+// error. A return value, loop control or error resulting from the finally block replaces it.
+func evalTryStatement(ts *ast.TryStatement, env object.Environment) object.Object {
+	result := Eval(ts.Body, env)
+
+	if err, ok := result.(*object.Error); ok && ts.Catch != nil {
+		// The parameter is scoped to the catch clause
+		catchEnv := object.NewEnclosedEnvironment(env)
+		if ts.Param != nil {
+			catchEnv.Set(ts.Param.Value, err.Caught())
+		}
+		result = Eval(ts.Catch, catchEnv)
+	}
+
+	if ts.Finally != nil {
+		if final := Eval(ts.Finally, env); isInterrupted(final) {
+			return final
+		}
+	}
+
+	return result
+}
+
+// isInterrupted reports whether `result` stops the evaluation of the enclosing blocks, i.e. it
+// is a return value, an error or a loop control.
+func isInterrupted(result object.Object) bool {
+	if result == nil {
+		return false
+	}
+	rt := result.Type()
+	return rt == object.ReturnValueType || rt == object.ErrorType || rt == loopControlType
 }
 
 func isTruthy(obj object.Object) bool {
 	return obj != NilValue && obj != FalseValue
 }
 
-func newError(format string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+func newError(kind, format string, a ...interface{}) *object.Error {
+	return object.NewError(kind, format, a...)
 }
 
 func isError(obj object.Object) bool {
@@ -348,38 +857,182 @@ func evalIdent(node *ast.Ident, env object.Environment) object.Object {
 		return builtin
 	}
 
-	return newError("identifier not found: %s", node.Value)
+	return newError(object.NameError, "identifier not found: %s", node.Value)
 }
 
 func evalExpressions(exprs []ast.Expression, env object.Environment) []object.Object {
 	result := make([]object.Object, 0, len(exprs))
 
 	for _, expr := range exprs {
+		spread, isSpread := expr.(*ast.Spread)
+		if isSpread {
+			expr = spread.Value
+		}
+
 		evaluated := Eval(expr, env)
 		if isError(evaluated) {
 			return []object.Object{evaluated}
 		}
-		result = append(result, evaluated)
+
+		if !isSpread {
+			result = append(result, evaluated)
+			continue
+		}
+
+		arr, ok := evaluated.(*object.Array)
+		if !ok {
+			return []object.Object{newError(object.TypeError, "cannot spread %s", evaluated.Type())}
+		}
+		result = append(result, arr.Elements...)
 	}
 
 	return result
 }
 
-func extendFunctionEnv(fn *object.Function, args []object.Object) object.Environment {
+func extendFunctionEnv(fn *object.Function, args []object.Object) (object.Environment, object.Object) {
+	numParams := len(fn.Parameters)
+	firstDefault := numParams - len(fn.Defaults)
+	if len(args) < firstDefault || (fn.Rest == nil && len(args) > numParams) {
+		want := strconv.Itoa(numParams)
+		if fn.Rest != nil {
+			want = fmt.Sprintf("at least %d", firstDefault)
+		} else if firstDefault < numParams {
+			want = fmt.Sprintf("%d to %d", firstDefault, numParams)
+		}
+		return nil, newError(
+			object.ArgumentError,
+			"wrong number of arguments: want=%s, got=%d", want, len(args),
+		)
+	}
+
 	env := object.NewEnclosedEnvironment(fn.Env)
 
 	for i, param := range fn.Parameters {
-		env.Set(param.Value, args[i])
+		var arg object.Object
+		if i < len(args) {
+			arg = args[i]
+		} else {
+			// Default values are evaluated in the function's environment, so they can refer
+			// to the parameters before them
+			if arg = Eval(fn.Defaults[i-firstDefault], env); isError(arg) {
+				return nil, arg
+			}
+		}
+
+		if err := bindPattern(param, arg, env, ast.LetBinding); err != nil {
+			return nil, err
+		}
 	}
 
-	return env
+	if fn.Rest != nil {
+		rest := []object.Object{}
+		if len(args) > numParams {
+			rest = append(rest, args[numParams:]...)
+		}
+		env.Set(fn.Rest.Value, &object.Array{Elements: rest})
+	}
+
+	return env, nil
+}
+
+// bindPattern binds `value` to the variables in `pattern` in `env` in the way `b`. Missing
+// elements of an array and missing keys of a hash are bound to nil. A constant cannot be
+// redeclared in the same environment.
+func bindPattern(
+	pattern ast.Pattern, value object.Object, env object.Environment, b ast.Binding,
+) *object.Error {
+	bind := func(name string, value object.Object) *object.Error {
+		if b != ast.AssignBinding && env.HasConst(name) {
+			return newError(object.TypeError, "cannot redeclare constant %q", name)
+		}
+
+		switch b {
+		case ast.LetBinding:
+			env.Set(name, value)
+		case ast.ConstBinding:
+			env.SetConst(name, value)
+		default:
+			return assign(name, value, env)
+		}
+		return nil
+	}
+
+	switch pattern := pattern.(type) {
+	case *ast.Ident:
+		return bind(pattern.Value, value)
+
+	case *ast.ArrayPattern:
+		arr, ok := value.(*object.Array)
+		if !ok {
+			return newError(object.TypeError, "cannot destructure %s as an array", value.Type())
+		}
+
+		for i, elem := range pattern.Elements {
+			var v object.Object = NilValue
+			if i < len(arr.Elements) {
+				v = arr.Elements[i]
+			}
+			if err := bindPattern(elem, v, env, b); err != nil {
+				return err
+			}
+		}
+
+		if pattern.Rest != nil {
+			rest := []object.Object{}
+			if len(arr.Elements) > len(pattern.Elements) {
+				rest = append(rest, arr.Elements[len(pattern.Elements):]...)
+			}
+			return bind(pattern.Rest.Value, &object.Array{Elements: rest})
+		}
+
+	case *ast.HashPattern:
+		if value.Type() != object.HashType {
+			return newError(object.TypeError, "cannot destructure %s as a hash", value.Type())
+		}
+
+		for _, key := range pattern.Keys {
+			v := evalHashIndexExpression(value, &object.String{Value: key.Value})
+			if err := bind(key.Value, v); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return newError(object.GenericError, "unknown pattern: %s", pattern)
+	}
+
+	return nil
+}
+
+// assign assigns `value` to the variable `name` visible from `env`, even of an enclosing
+// function, so that the closures sharing the variable see the assignment. If there is no such
+// variable, a new one is defined in `env`. A constant cannot be assigned to.
+func assign(name string, value object.Object, env object.Environment) *object.Error {
+	if env.IsConst(name) {
+		return newError(object.TypeError, "cannot assign to constant %q", name)
+	}
+	if !env.Assign(name, value) {
+		env.Set(name, value)
+	}
+	return nil
 }
 
 func applyFunction(fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
+		if callDepth >= MaxCallDepth {
+			return newError(object.GenericError, "stack overflow")
+		}
+
+		extendedEnv, err := extendFunctionEnv(fn, args)
+		if err != nil {
+			return err
+		}
+
+		callDepth++
 		evaluated := Eval(fn.Body, extendedEnv)
+		callDepth--
+
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
 		if result := fn.Fn(args...); result != nil {
@@ -387,7 +1040,7 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 		}
 		return NilValue
 	default:
-		return newError("not a function: %s", fn.Type())
+		return newError(object.TypeError, "not a function: %s", fn.Type())
 	}
 }
 
@@ -402,25 +1055,83 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ArrayType && index.Type() == object.IntegerType:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.StringType && index.Type() == object.IntegerType:
+		return evalStringIndexExpression(left, index)
 	case left.Type() == object.HashType:
 		return evalHashIndexExpression(left, index)
 	default:
-		return newError("index operator not supported: %s", left.Type())
+		return newError(object.TypeError, "index operator not supported: %s", left.Type())
 	}
 }
 
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	arrObj := array.(*object.Array)
-	idx := index.(*object.Integer).Value
-	max := int64(len(arrObj.Elements) - 1)
-
-	if idx < 0 || idx > max {
+	idx, ok := object.Index(index.(*object.Integer).Value, len(arrObj.Elements))
+	if !ok {
 		return NilValue
 	}
 
 	return arrObj.Elements[idx]
 }
 
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	ch, ok := object.CharAt(str.(*object.String), index.(*object.Integer).Value)
+	if !ok {
+		return NilValue
+	}
+
+	return ch
+}
+
+// evalMethod returns the member of the object in a method call and the receiver to pass to the
+// member. The receiver is nil unless the object is a hash and the member is a function.
+func evalMethod(member *ast.MemberExpression, env object.Environment) (method, receiver object.Object) {
+	obj := evalChainObject(member.Object, member.Optional(), env)
+	// A method in parentheses is a chain of its own
+	if obj == skippedChain && member.Grouped {
+		return NilValue, nil
+	}
+	if obj == skippedChain || isError(obj) {
+		return obj, nil
+	}
+
+	method = evalIndexExpression(obj, &object.String{Value: member.Member.Value})
+	if obj.Type() != object.HashType {
+		return method, nil
+	}
+
+	switch method.(type) {
+	case *object.Function, *object.Builtin:
+		return method, obj
+	default:
+		return method, nil
+	}
+}
+
+func evalSliceExpression(node *ast.SliceExpression, env object.Environment) object.Object {
+	left := evalChainObject(node.Left, node.Optional(), env)
+	if left == skippedChain || isError(left) {
+		return left
+	}
+
+	// Omitted indices are nil
+	indices := []object.Object{NilValue, NilValue}
+	for i, idx := range []ast.Expression{node.Start, node.End} {
+		if idx == nil {
+			continue
+		}
+		if indices[i] = Eval(idx, env); isError(indices[i]) {
+			return indices[i]
+		}
+	}
+
+	slice, err := object.Slice(left, indices[0], indices[1])
+	if err != nil {
+		return err
+	}
+	return slice
+}
+
 func evalHashLiteral(node *ast.HashLiteral, env object.Environment) object.Object {
 	pairs := make(map[object.HashKey]object.HashPair, len(node.Pairs))
 
@@ -432,7 +1143,7 @@ func evalHashLiteral(node *ast.HashLiteral, env object.Environment) object.Objec
 
 		hashKey, ok := key.(object.Hashable)
 		if !ok {
-			return newError("unusable as hash key: %s", key.Type())
+			return newError(object.TypeError, "unusable as hash key: %s", key.Type())
 		}
 
 		value := Eval(valueNode, env)
@@ -453,7 +1164,7 @@ func evalHashLiteral(node *ast.HashLiteral, env object.Environment) object.Objec
 func evalHashIndexExpression(left, index object.Object) object.Object {
 	key, ok := index.(object.Hashable)
 	if !ok {
-		return newError("unusable as hash key: %s", index.Type())
+		return newError(object.TypeError, "unusable as hash key: %s", index.Type())
 	}
 
 	hashObj := left.(*object.Hash)
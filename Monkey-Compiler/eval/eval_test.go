@@ -1,7 +1,7 @@
 package eval
 
 import (
-	"strings"
+	"errors"
 	"testing"
 
 	"monkey-compiler/lexer"
@@ -15,7 +15,7 @@ func testEval(t *testing.T, input string) object.Object {
 	program := p.ParseProgram()
 
 	if len(p.Errors()) > 0 {
-		t.Fatalf("input %q has errors: \n%v", input, strings.Join(p.Errors(), "\n"))
+		t.Fatalf("input %q has errors: %q", input, p.Errors())
 	}
 
 	env := object.NewEnvironment()
@@ -60,6 +60,61 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestEvalIntegerOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"7 / 2", 3},
+		{"-7 / 2", -3},
+		{"7 // 2", 3},
+		{"-7 // 2", -4},
+		{"7 % 3", 1},
+		{"-7 % 3", 2},
+		{"7 % -3", -2},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"~5", -6},
+		{"1 << 4", 16},
+		{"-16 >> 2", -4},
+		{"1 + 2 << 3", 24},
+		{"7.5 // 2", 3.0},
+		{"-7.5 % 2", 0.5},
+		{"2 ** -1", 0.5},
+		{"2.0 ** 3", 8.0},
+		{"1 / 0", "integer division by zero"},
+		{"1 // 0", "integer division by zero"},
+		{"let x = 0; 5 % x", "integer modulo by zero"},
+		{"1 << -1", "negative shift count: -1"},
+		{"1.5 & 1", "unknown operator: Float & Integer"},
+		{`~"a"`, "unknown operator: ~String"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
 func testFloatObject(t *testing.T, obj object.Object, expected float64) {
 	f, ok := obj.(*object.Float)
 	if !ok {
@@ -182,6 +237,352 @@ func TestIfExpression(t *testing.T) {
 	}
 }
 
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let i = 0; let sum = 0; while (i < 10) { i = i + 1; sum = sum + i; } sum;", 55},
+		{
+			`let sum = 0;
+			for (let i = 0; i < 10; i = i + 1) {
+				if (i == 3) { continue; }
+				if (i == 6) { break; }
+				sum = sum + i;
+			}
+			sum;`,
+			12,
+		},
+		{"let n = 0; for (;;) { n = n + 1; if (n >= 5) { break } } n;", 5},
+		{
+			`let count = 0;
+			for (let i = 0; i < 3; i = i + 1) {
+				for (let j = 0; j < 10; j = j + 1) {
+					if (j == 2) { break }
+					count = count + 1;
+				}
+			}
+			count;`,
+			6,
+		},
+		{
+			`let find = fn(xs, x) {
+				for (let i = 0; i < len(xs); i = i + 1) {
+					if (xs[i] == x) { return i; }
+				}
+				-1;
+			};
+			find([5, 6, 7], 7) * 10 + find([5, 6, 7], 8);`,
+			19,
+		},
+		{"let countdown = fn(n) { while (n > 0) { n = n - 1; } n; }; countdown(10000);", 0},
+		{"let x = 0; while (false) { x = 1; } x;", 0},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestForInLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let sum = 0; for (x in [1, 2, 3, 4]) { sum = sum + x; } sum", 10},
+		{"let sum = 0; for (i, x in [10, 20, 30]) { sum = sum + i * x; } sum", 80},
+		{`let sum = 0; for (k, v in {"a": 1, "b": 2, "c": 3}) { sum = sum + v; } sum`, 6},
+		{`let keys = ""; for (k in {"a": 1}) { keys = keys + k; } keys`, "a"},
+		{`let out = ""; for (c in "añ😀") { out = c + out; } out`, "😀ña"},
+		{"let sum = 0; for (x in [1, 2, 3, 4, 5, 6]) { if (x == 2) { continue; } if (x == 5) { break; } sum = sum + x; } sum", 8},
+		{"let f = fn(xs) { for (i, x in xs) { if (x == 3) { return i; } } -1 }; f([1, 2, 3])", 2},
+		{"let fs = []; for (x in [1, 2, 3]) { fs = push(fs, fn() { x }) }; fs[0]() * 100 + fs[1]() * 10 + fs[2]()", 123},
+		{"for (x in 5) { }", "Integer is not iterable"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			switch obj := evaluated.(type) {
+			case *object.String:
+				if obj.Value != expected {
+					t.Errorf("String has wrong value. want=%q, got=%q", expected, obj.Value)
+				}
+			case *object.Error:
+				if obj.Message != expected {
+					t.Errorf("wrong error message. expected=%q, got=%q", expected, obj.Message)
+				}
+			default:
+				t.Errorf("object is not String or Error. got=%T (%+v)", evaluated, evaluated)
+			}
+		}
+	}
+}
+
+func TestLoopErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (x) { 1 }", "identifier not found: x"},
+		{"let i = 0; while (true) { i = i + 1; if (i == 3) { i + true } }", "type mismatch: Integer + Boolean"},
+		{"for (let i = 0; i < 3; i = i + y) { }", "identifier not found: y"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expected {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestAssignStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"a = 1; a = a + 1; a", 2},
+		{"let xs = [1, 2, 3]; xs[1] = 5; xs[1] + xs[2]", 8},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] + h["b"]`, 3},
+		{"let x = 1; if (true) { x = 2 }", nil},
+		{"let x = 1; let f = fn() { x = 2 }; f(); x", 2},
+		{"let f = fn() { let x = 1; let g = fn() { x += 1 }; g(); g(); x }; f()", 3},
+		{"let x = 1; let f = fn(x) { x = 5 }; f(0); x", 1},
+		{"let x = 1; let f = fn() { [x, y] = [7, 8] }; f(); x", 7},
+		{"let xs = [1]; xs[1] = 2", "array index 1 out of range"},
+		{"let h = {}; h[fn(x) { x }] = 1", "unusable as hash key: Function"},
+		{"let n = 1; n[0] = 2", "index operator not supported: Integer"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		default:
+			testNilObject(t, evaluated)
+		}
+	}
+}
+
+func TestConstStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"const LIMIT = 10; LIMIT", 10},
+		{"const x = 1; if (true) { let x = 2; x = 3 }; x", 1},
+		{"let x = 1; const x = 2; x", 2},
+		{"const x = 1; let f = fn() { let x = 2; x = 3; x }; f() + x", 4},
+		{"const L = 10; let L = 5; L", `cannot redeclare constant "L"`},
+		{"const L = 10; const L = 5; L", `cannot redeclare constant "L"`},
+		{"const L = 10; let [a, L] = [1, 2]; L", `cannot redeclare constant "L"`},
+		{"const f = fn() { 1 }; let f = fn() { 2 }; f()", `cannot redeclare constant "f"`},
+		{"const LIMIT = 10; LIMIT = 20", `cannot assign to constant "LIMIT"`},
+		{"const n = 1; n += 1", `cannot assign to constant "n"`},
+		{"const [a, b] = [1, 2]; [b, a] = [a, b]", `cannot assign to constant "b"`},
+		{"const {n} = {\"n\": 1}; let f = fn() { n = 2 }; f()", `cannot assign to constant "n"`},
+		{"const f = fn() { f = 1 }; f()", `cannot assign to constant "f"`},
+		{"let xs = freeze([1]); xs[0] = 2", "cannot modify frozen Array"},
+		{`let h = freeze({"a": 1}); h.a = 2`, "cannot modify frozen Hash"},
+		{`let h = freeze({"a": [1]}); h.a[0] = 2; h.a[0]`, 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestLogicalExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1 && 2", 2},
+		{"false && 1", false},
+		{"1 || 2", 1},
+		{"false || 2", 2},
+		{"nil && 1", nil},
+		{"1 && false || 4", 4},
+		{"let xs = nil; xs != nil && xs[0] > 1", false},
+		{"let xs = [5]; xs != nil && xs[0] > 1", true},
+		{"true || 1 / 0", true},
+		{"false && undefined", false},
+		{
+			// The right operands with side effects are not evaluated
+			input: `
+			let calls = [0];
+			let touch = fn(v) { calls[0]++; v };
+			false && touch(1);
+			true || touch(2);
+			nil && touch(3);
+			true && touch(4);
+			false || touch(5);
+			calls[0];
+			`,
+			expected: 2,
+		},
+		{"nil ?? 1", 1},
+		{"false ?? 1", false},
+		{"let h = {}; h[\"missing\"] ?? 2", 2},
+		{"1 ?? undefined", 1},
+		{"let p = nil; p?.name", nil},
+		{"let p = nil; p?.address.city", nil},
+		{"let p = nil; p?.greet(undefined)", nil},
+		{"let xs = nil; xs?[0][1]", nil},
+		{"let xs = [[1, 2]]; xs?[0]?[1]", 2},
+		{"let p = {\"age\": fn(self) { 3 }}; p?.age()", 3},
+		{"let p = nil; p?.age ?? 4", 4},
+		{"let a = nil; (a?.b)?.c", nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		default:
+			testNilObject(t, evaluated)
+		}
+	}
+}
+
+func TestCompoundAssignStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = 10; x += 5; x", 15},
+		{"let x = 10; x -= 5; x", 5},
+		{"let x = 10; x *= 5; x", 50},
+		{"let x = 10; x /= 4; x", 2},
+		{"let x = 10; x %= 4; x", 2},
+		{"let x = 1; x++; x++; x", 3},
+		{"let x = 1; x--; x", 0},
+		{"let xs = [1, 2, 3]; xs[1] += 10; xs[1]", 12},
+		{"let xs = [1, 2, 3]; xs[2]++; xs[0]--; xs[0] + xs[2]", 4},
+		{`let counts = {"a": 0}; counts["a"] += 1; counts["a"] += 1; counts["a"]`, 2},
+		{"let sum = 0; for (let i = 0; i < 5; i++) { sum += i; } sum", 10},
+		{
+			// The left-hand side is evaluated only once
+			input: `
+			let calls = [0];
+			let xs = [10, 20];
+			let pick = fn() { calls[0]++; xs };
+			pick()[1] += 5;
+			calls[0] * 100 + xs[1];
+			`,
+			expected: 125,
+		},
+		{"y += 1", "identifier not found: y"},
+		{`let s = "a"; s -= 1`, "type mismatch: String - Integer"},
+		{"let xs = [1]; xs[3] += 1", "type mismatch: Nil + Integer"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let [a, b] = [1, 2]; a * 10 + b", 12},
+		{"let [a, b, ...rest] = [1, 2, 3, 4]; rest[0] + rest[1]", 7},
+		{"let [a, ...rest] = [1]; len(rest)", 0},
+		{"let [a, b, c] = [1, 2]; c", nil},
+		{"let [a, [b, c]] = [1, [2, 3]]; a + b + c", 6},
+		{`let {name, age} = {"name": "Monkey", "age": 5}; age`, 5},
+		{`let {name, age} = {"name": "Monkey"}; age`, nil},
+		{`let [{x}, {y}] = [{"x": 1}, {"y": 2}]; x + y`, 3},
+		{"let a = 1; let b = 2; [a, b] = [b, a]; a * 10 + b", 21},
+		{`let x = 0; {x} = {"x": 7}; x`, 7},
+		{"let f = fn([a, b]) { a - b }; f([5, 3])", 2},
+		{`let f = fn(n, {x, y}) { n * (x + y) }; f(2, {"x": 1, "y": 2})`, 6},
+		{"let f = fn([first, ...rest]) { len(rest) }; f([1, 2, 3])", 2},
+		{"let [a, b] = 1;", "cannot destructure Integer as an array"},
+		{"let {a} = [1];", "cannot destructure Array as a hash"},
+		{"let f = fn([a]) { a }; f(nil)", "cannot destructure Nil as an array"},
+		{"let x = {a};", "unexpected pattern {a}"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNilObject(t, evaluated)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
 func testNilObject(t *testing.T, obj object.Object) {
 	if obj != NilValue {
 		t.Errorf("object is not NilValue. got=%#v", obj)
@@ -235,10 +636,19 @@ func TestErrorHandling(t *testing.T) {
 		}
 		`, "unknown operator: Boolean + Boolean"},
 		{"foobar", "identifier not found: foobar"},
+		{"if (true) { let y = 1 }; y", "identifier not found: y"},
+		{"for (x in [1]) {}\nx", "identifier not found: x"},
+		{"try { throw 1 } catch (e) {}; e", "identifier not found: e"},
 		{`"Hello" - "World"`, "unknown operator: String - String"},
 		{`1.5 + "World"`, "unknown operator: Float + String"},
 		{`{[1, 2]: "Monkey"}`, "unusable as hash key: Array"},
 		{`{"name": "Monkey"}[fn(x) { x }]`, "unusable as hash key: Function"},
+		{`import "lib.monkey" as lib`, "import is not supported by the evaluator"},
+		{"let p = {}; p?.name = 1", "cannot assign to (p?.name)"},
+		{"let p = {}; p?.a.b", "index operator not supported: Nil"},
+		{"let a = nil; (a?.b).c", "index operator not supported: Nil"},
+		{"let a = nil; (a?[0])[1]", "index operator not supported: Nil"},
+		{"let a = nil; (a?.f)()", "not a function: Nil"},
 	}
 
 	for _, tt := range tests {
@@ -315,6 +725,81 @@ func TestFunctionApplication(t *testing.T) {
 	}
 }
 
+func TestDefaultRestAndSpread(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let f = fn(x, y = 10) { x + y }; f(1)", 11},
+		{"let f = fn(x, y = 10) { x + y }; f(1, 2)", 3},
+		{"let f = fn(x, y = 10) { y }; f(1, nil)", nil},
+		{"let f = fn(x = 1, y = x + 1) { x * 10 + y }; f()", 12},
+		{"let f = fn([a, b] = [1, 2]) { a * 10 + b }; f()", 12},
+		{"let f = fn(first, ...rest) { len(rest) }; f(1, 2, 3)", 2},
+		{"let f = fn(first, ...rest) { len(rest) }; f(1)", 0},
+		{"let f = fn(x, y = 2, ...rest) { x + y + len(rest) }; f(1, 5, 6, 7)", 8},
+		{"let add = fn(x, y) { x + y }; add(...[1, 2])", 3},
+		{"let f = fn(...xs) { xs[1] }; f(0, ...[1, 2], 3)", 1},
+		{"let a = [1, 2]; len([0, ...a, 3, ...a])", 6},
+		{"let a = [1, 2]; [0, ...a, 3][2]", 2},
+		{`len(...["four"])`, 4},
+		{"fn(a, b) { a }(1)", "wrong number of arguments: want=2, got=1"},
+		{"fn(a, b = 1) { a }(1, 2, 3)", "wrong number of arguments: want=1 to 2, got=3"},
+		{"fn(a, ...b) { a }()", "wrong number of arguments: want=at least 1, got=0"},
+		{"[...1]", "cannot spread Integer"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNilObject(t, evaluated)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestShortFunctionsAndPipes(t *testing.T) {
+	prelude := `
+	let map = fn(xs, f) { let out = []; for (x in xs) { out = push(out, f(x)); } out };
+	let sum = fn(xs) { let s = 0; for (x in xs) { s += x; } s };
+	`
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let double = |x| x * 2; double(4)", 8},
+		{"let double = x => x * 2; double(4)", 8},
+		{"(|a, b| a - b)(5, 3)", 2},
+		{"(|| 42)()", 42},
+		{"(|x, y = 10| x + y)(1)", 11},
+		{"(|...xs| len(xs))(1, 2, 3)", 3},
+		{"let add = |x| y => x + y; add(1)(2)", 3},
+		{"(|x| { let y = x + 1; y * y })(2)", 9},
+		{"4 |> |x| x * x", 16},
+		{"let inc = |x| x + 1; 1 |> inc |> inc", 3},
+		{"let add = |x, y| x + y; 1 |> add(2)", 3},
+		{"let add = |x| |y| x * 10 + y; 1 |> (add(2))", 21},
+		{prelude + "[1, 2, 3] |> map(x => x * 2) |> sum", 12},
+		{`let p = {"n": 1, "add": fn(self, x) { self.n + x }}; 2 |> p.add()`, 3},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
+	}
+}
+
 func TestClosures(t *testing.T) {
 	input := `
 	let newAdder = fn(x) {
@@ -336,6 +821,9 @@ func TestStringLiteralAndConcat(t *testing.T) {
 	}{
 		{`"Hello World!";`, "Hello World!"},
 		{`"Hello" + " " + "World!";`, "Hello World!"},
+		{`let name = "Bob"; let age = 30; "Hello ${name}, you are ${age}"`, "Hello Bob, you are 30"},
+		{`"${1.5} ${true} ${nil} ${[1, "a"]} ${len("abc") * 2}"`, "1.5 true nil [1, a] 6"},
+		{"`raw \\n ${x}\n`", "raw \\n ${x}\n"},
 	}
 
 	for _, tt := range tests {
@@ -460,7 +948,9 @@ func TestArrayIndexExpressions(t *testing.T) {
 		{"let arr = [1, 2, 3]; arr[0] + arr[1] + arr[2];", 6},
 		{"let arr = [1, 2, 3]; let i = arr[0]; arr[i]", 2},
 		{"[1, 2, 3][3]", nil},
-		{"[1, 2, 3][-1]", nil},
+		{"[1, 2, 3][-1]", 3},
+		{"[1, 2, 3][-3]", 1},
+		{"[1, 2, 3][-4]", nil},
 	}
 
 	for _, tt := range tests {
@@ -473,6 +963,211 @@ func TestArrayIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestSliceAndStringIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"[1, 2, 3, 4][1:3]", []int64{2, 3}},
+		{"[1, 2, 3, 4][:-1]", []int64{1, 2, 3}},
+		{"[1, 2, 3, 4][2:]", []int64{3, 4}},
+		{"[1, 2, 3, 4][:]", []int64{1, 2, 3, 4}},
+		{"[1, 2, 3, 4][-2:]", []int64{3, 4}},
+		{"[1, 2, 3, 4][3:1]", []int64{}},
+		{"[1, 2, 3, 4][-10:10]", []int64{1, 2, 3, 4}},
+		{"let xs = [1, 2]; let ys = xs[:]; ys[0] = 9; xs", []int64{1, 2}},
+		{`"monkey"[2:]`, "nkey"},
+		{`"monkey"[:-3]`, "mon"},
+		{`"monkey"[1:1]`, ""},
+		{`"monkey"[0]`, "m"},
+		{`"monkey"[-1]`, "y"},
+		{`"héllo"[1]`, "é"},
+		{`"héllo"[1:3]`, "él"},
+		{`"monkey"[6]`, nil},
+		{`{}[1:2]`, errors.New("slice operator not supported: Hash")},
+		{`[1, 2]["a":]`, errors.New("slice index must be an integer, got String")},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case []int64:
+			arr, ok := evaluated.(*object.Array)
+			if !ok {
+				t.Errorf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if len(arr.Elements) != len(expected) {
+				t.Errorf("wrong num of elements. want=%d, got=%d", len(expected), len(arr.Elements))
+				continue
+			}
+			for i, want := range expected {
+				testIntegerObject(t, arr.Elements[i], want)
+			}
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. want=%q, got=%q", expected, str.Value)
+			}
+		case nil:
+			testNilObject(t, evaluated)
+		case error:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected.Error() {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestMemberExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let p = {"name": "Thorsten"}; p.name`, "Thorsten"},
+		{`let p = {"address": {"city": "Berlin"}}; p.address.city`, "Berlin"},
+		{`let p = {}; p.name`, nil},
+		{`let p = {}; p.age = 30; p["age"]`, 30},
+		{`let p = {"age": 30}; p.age += 1; p.age++; p.age`, 32},
+		{`let p = {"address": {}}; p.address.city = "Berlin"; p["address"]["city"]`, "Berlin"},
+		{`let p = {"age": 30, "older": fn(self, n) { self.age + n }}; p.older(2)`, 32},
+		{`let p = {"age": 30, "birthday": fn(self) { self.age++ }}; p.birthday(); p.birthday(); p.age`, 32},
+		{`let p = {"add": fn(a, b) { a + b }}; let add = p.add; add(1, 2)`, 3},
+		{`let p = {"count": len}; p.count()`, errors.New("argument to `len` not supported, got Hash")},
+		{`let p = {"sum": fn(self, ...xs) { len(xs) }}; p.sum(...[1, 2, 3])`, 3},
+		{`let m = {"inner": {"f": fn(self) { self.v }, "v": 7}}; m.inner.f()`, 7},
+		{"let x = 1; x.name", errors.New("index operator not supported: Integer")},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. want=%q, got=%q", expected, str.Value)
+			}
+		case nil:
+			testNilObject(t, evaluated)
+		case error:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected.Error() {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`fn() { try { throw "boom" } catch (e) { return e } }()`, "boom"},
+		{`fn() { try { throw 1 } catch { return "caught" } }()`, "caught"},
+		{`fn() { try { 1 } catch (e) { return "caught" }; "not caught" }()`, "not caught"},
+		// A try statement has the value of the body, or of the catch clause if it caught an error
+		{`try { throw "x" } catch (e) { e }`, "x"},
+		// Unbounded recursion raises a stack overflow error, which can be caught
+		{`let f = fn(n) { f(n + 1) }; try { f(0) } catch (e) { e.message }`, "stack overflow"},
+		{`let f = fn() { f() }; let r = fn() { try { f() } catch (e) { return 1 } }; r() + r()`, 2},
+		{`let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(1000)`, 1000},
+		{`fn() { try { "body" } finally { "finally" } }()`, "body"},
+		{`fn() { try { 1 % 0 } catch (e) { return e.type } }()`, "ArithmeticError"},
+		{`fn() { try { 1 % 0 } catch (e) { return e.message } }()`, "integer modulo by zero"},
+		{`fn() { try { 1[0] } catch (e) { return e.type } }()`, "TypeError"},
+		{`fn() { try { 1[0] } catch (e) { return e.message } }()`, "index operator not supported: Integer"},
+		{`fn() { try { let a = [1]; a[5] = 0 } catch (e) { return e.type } }()`, "IndexError"},
+		{`fn() { try { fn(x) { x }() } catch (e) { return e.type } }()`, "ArgumentError"},
+		{`fn() { try { 1 + "a" } catch (e) { return e.type } }()`, "TypeError"},
+		{`fn() { try { len(1) } catch (e) { return e.type } }()`, "TypeError"},
+		{`fn() { try { x } catch (e) { return e.type } }()`, "NameError"},
+		{`fn() { try { throw {"type": "Custom", "message": "bad"} } catch (e) { return e.type + ": " + e.message } }()`, "Custom: bad"},
+		{
+			`let f = fn(n) { if (n == 0) { throw "bottom" }; 1 + f(n - 1) };
+			fn() { try { f(10) } catch (e) { return e } }()`,
+			"bottom",
+		},
+		{`fn() { let x = 1 + fn() { try { 2 + [3, fn() { throw 4 }()][0] } catch (e) { return e } }(); x }()`, 5},
+		{`fn() { try { try { 1 % 0 } catch (e) { throw e } } catch (e) { return e.type } }()`, "ArithmeticError"},
+		{`fn() { try { try { throw "a" } catch (e) { throw e + "b" } } catch (e) { return e } }()`, "ab"},
+		{`let log = {"n": 0}; let f = fn() { try { return 1 } finally { log.n += 1 } }; f() + f() + log.n`, 4},
+		{`fn() { try { return 1 } finally { return 2 } }()`, 2},
+		{`fn() { try { throw 1 } catch (e) { return e } finally { return 2 } }()`, 2},
+		{
+			`let s = {"v": ""};
+			fn() { try { try { throw "x" } finally { s.v += "f" } } catch (e) { s.v += e } }();
+			s.v`,
+			"fx",
+		},
+		{
+			`let s = {"v": ""};
+			fn() { try { try { throw "a" } catch (e) { throw e + "b" } finally { s.v += "f" } } catch (e) { s.v += e } }();
+			s.v`,
+			"fab",
+		},
+		{`fn() { while (true) { try { throw 1 } finally { break } } "swallowed" }()`, "swallowed"},
+		{
+			`let n = {"f": 0}; let i = 0;
+			while (true) { try { i += 1; if (i > 3) { break }; continue } finally { n.f += 1 } }
+			i * 10 + n.f`,
+			44,
+		},
+		{`let sum = 0; for (x in [1, 0, 2]) { try { sum += 10 / x } catch (e) { sum += 100 } } sum`, 115},
+		{`throw "boom"`, errors.New("boom")},
+		{`throw {"type": "Custom", "message": "bad"}`, errors.New("bad")},
+		{`let x = 1; try { x % 0 } finally { x = 2 }`, errors.New("integer modulo by zero")},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. want=%q, got=%q", expected, str.Value)
+			}
+		case error:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected.Error() {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
 func TestHashLiterals(t *testing.T) {
 	input := `
 	let two = "two";
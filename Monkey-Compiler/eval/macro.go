@@ -31,7 +31,7 @@ func isMacroDefinition(node ast.Statement) bool {
 	}
 
 	_, ok = letStmt.Value.(*ast.MacroLiteral)
-	return ok
+	return ok && letStmt.Name != nil
 }
 
 func addMacro(stmt ast.Statement, env object.Environment) {
@@ -73,12 +73,21 @@ func ExpandMacros(program ast.Node, env object.Environment) ast.Node {
 }
 
 func isMacroCall(call *ast.CallExpression, env object.Environment) (macro *object.Macro, ok bool) {
-	ident, ok := call.Function.(*ast.Ident)
-	if !ok {
-		return nil, false
+	var obj object.Object
+	switch fn := call.Function.(type) {
+	case *ast.Ident:
+		obj, ok = env.Get(fn.Value)
+	case *ast.MemberExpression:
+		// A macro exported by an imported module, e.g. `mod.unless(...)`
+		ident, isIdent := fn.Object.(*ast.Ident)
+		if !isIdent {
+			return nil, false
+		}
+		val, _ := env.Get(ident.Value)
+		if mod, isModule := val.(*object.Module); isModule {
+			obj, ok = mod.Exports[fn.Member.Value]
+		}
 	}
-
-	obj, ok := env.Get(ident.Value)
 	if !ok {
 		return nil, false
 	}
@@ -120,6 +120,24 @@ func TestExpandMacros(t *testing.T) {
 	}
 }
 
+func TestExpandModuleMacros(t *testing.T) {
+	modEnv := object.NewEnvironment()
+	DefineMacros(testParseProgram("let reverse = macro(a, b) { quote(unquote(b) - unquote(a)); };"), modEnv)
+	reverse, _ := modEnv.Get("reverse")
+
+	env := object.NewEnvironment()
+	env.Set("mod", &object.Module{Name: "mod.monkey", Exports: map[string]object.Object{"reverse": reverse}})
+
+	program := testParseProgram("mod.reverse(2 + 2, 10 - 5); other.reverse(1, 2);")
+	DefineMacros(program, env)
+	got := ExpandMacros(program, env).String()
+
+	want := testParseProgram("(10 - 5) - (2 + 2); other.reverse(1, 2);").String()
+	if got != want {
+		t.Errorf("expected %q, but got %q", want, got)
+	}
+}
+
 func testParseProgram(input string) *ast.Program {
 	return parser.New(lexer.New(input)).ParseProgram()
 }
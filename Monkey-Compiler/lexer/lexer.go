@@ -1,6 +1,14 @@
 package lexer
 
-import "monkey-compiler/token"
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"monkey-compiler/token"
+)
 
 // Lexer represents a lexer for Monkey programming language.
 type Lexer interface {
@@ -8,47 +16,175 @@ type Lexer interface {
 	NextToken() token.Token
 }
 
+// chunkSize is the number of bytes a streaming lexer reads from its reader at a time.
+const chunkSize = 4096
+
 type lexer struct {
-	input string
+	filename string
+	// source of further input, or nil when all of the input is in buf
+	src io.Reader
+	// error which stopped reading from src, reported as an ILLEGAL token
+	err error
+	// input bytes starting at offset base
+	buf  []byte
+	base int
+	// offset of the first byte which has to stay in buf, usually the start of the current token
+	keep int
 	// current position in input (points to current char)
 	position int
 	// current reading position in input (after current char)
 	readPosition int
 	// current char under examination
-	ch byte
+	ch rune
+	// line and column of the current char
+	line, column int
+	// brace depth inside each enclosing string interpolation `${...}`
+	interpolations []int
 }
 
 // New returns a new Lexer.
 func New(input string) Lexer {
-	l := &lexer{input: input}
+	return NewFile("", input)
+}
+
+// NewFile returns a new Lexer which reports token positions with the given filename.
+func NewFile(filename, input string) Lexer {
+	l := &lexer{filename: filename, buf: []byte(input), line: 1}
+	l.readChar()
+	return l
+}
+
+// NewReader returns a new Lexer which reads its input incrementally from `r`.
+func NewReader(r io.Reader) Lexer {
+	return NewFileReader("", r)
+}
+
+// NewFileReader returns a new Lexer which reads its input incrementally from `r` and reports
+// token positions with the given filename.
+func NewFileReader(filename string, r io.Reader) Lexer {
+	l := &lexer{filename: filename, src: r, line: 1}
 	l.readChar()
 	return l
 }
 
+// fill reads from the source until the input up to offset `end` is in the buffer, or the
+// source is exhausted. Bytes before the `keep` offset are discarded first.
+func (l *lexer) fill(end int) {
+	if l.src == nil || end <= l.base+len(l.buf) {
+		return
+	}
+
+	if drop := l.keep - l.base; drop > 0 {
+		l.buf = append(l.buf[:0], l.buf[drop:]...)
+		l.base = l.keep
+	}
+
+	for l.src != nil && end > l.base+len(l.buf) {
+		if cap(l.buf)-len(l.buf) < chunkSize {
+			buf := make([]byte, len(l.buf), 2*cap(l.buf)+chunkSize)
+			copy(buf, l.buf)
+			l.buf = buf
+		}
+
+		n, err := l.src.Read(l.buf[len(l.buf):cap(l.buf)])
+		l.buf = l.buf[:len(l.buf)+n]
+		if err != nil {
+			if err != io.EOF {
+				l.err = err
+			}
+			l.src = nil
+		}
+	}
+}
+
+// text returns the input between the offsets `start` and `end`.
+func (l *lexer) text(start, end int) string {
+	max := l.base + len(l.buf)
+	if start > max {
+		start = max
+	}
+	if end > max {
+		end = max
+	}
+	return string(l.buf[start-l.base : end-l.base])
+}
+
 func (l *lexer) readChar() {
-	if l.readPosition >= len(l.input) {
-		l.ch = 0
-	} else {
-		l.ch = l.input[l.readPosition]
+	if l.src == nil && l.readPosition > l.base+len(l.buf) {
+		// Already at the end of input
+		return
 	}
+
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
+
 	l.position = l.readPosition
-	l.readPosition++
+	l.fill(l.readPosition + utf8.UTFMax)
+	if l.readPosition >= l.base+len(l.buf) {
+		l.ch = 0
+		l.readPosition++
+		return
+	}
+
+	r, width := utf8.DecodeRune(l.buf[l.readPosition-l.base:])
+	l.ch = r
+	l.readPosition += width
+}
+
+// invalidChar reports whether the current char is a byte which is not valid UTF-8.
+func (l *lexer) invalidChar() bool {
+	return l.ch == utf8.RuneError && l.readPosition-l.position == 1
 }
 
 func (l *lexer) NextToken() token.Token {
-	l.skipWhitespace()
+	// skip whitespace and comments
+	for {
+		l.skipWhitespace()
+		l.keep = l.position
+		if l.ch != '#' {
+			break
+		}
+
+		pos := l.pos()
+		switch l.peekChar() {
+		case '#':
+			return l.readDocComment(pos)
+		case '{':
+			if !l.skipBlockComment() {
+				return l.illegal(pos, "unterminated block comment")
+			}
+		default:
+			l.skipComment()
+		}
+	}
 
-	// skip comments
-	if l.ch == '#' {
-		l.skipComment()
+	pos := l.pos()
+	if l.ch == 0 && l.err != nil {
+		err := l.err
+		l.err = nil
+		return l.illegal(pos, "could not read input: %v", err)
 	}
 
+	tok := l.readToken()
+	if !tok.Pos.IsValid() {
+		tok.Pos = pos
+	}
+	return tok
+}
+
+func (l *lexer) readToken() token.Token {
 	var tok token.Token
 	switch l.ch {
 	case '=':
-		if l.peekChar() == '=' {
+		switch l.peekChar() {
+		case '=':
 			tok = l.readTwoCharToken(token.EQ)
-		} else {
+		case '>':
+			tok = l.readTwoCharToken(token.ARROW)
+		default:
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '!':
@@ -59,6 +195,21 @@ func (l *lexer) NextToken() token.Token {
 		}
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
+	case '.':
+		if isDigit(l.peekChar()) {
+			return l.readLeadingDotNumber()
+		}
+		if l.peekChar() == '.' {
+			l.readChar()
+			if l.peekChar() == '.' {
+				l.readChar()
+				tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
+			} else {
+				tok = l.illegal(token.Position{}, "unexpected %q", "..")
+			}
+			break
+		}
+		tok = newToken(token.DOT, l.ch)
 	case ':':
 		tok = newToken(token.COLON, l.ch)
 	case '(':
@@ -68,44 +219,119 @@ func (l *lexer) NextToken() token.Token {
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		switch l.peekChar() {
+		case '=':
+			tok = l.readTwoCharToken(token.PLUS_ASSIGN)
+		case '+':
+			tok = l.readTwoCharToken(token.INC)
+		default:
+			tok = newToken(token.PLUS, l.ch)
+		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		switch l.peekChar() {
+		case '=':
+			tok = l.readTwoCharToken(token.MINUS_ASSIGN)
+		case '-':
+			tok = l.readTwoCharToken(token.DEC)
+		default:
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case '*':
-		tok = newToken(token.ASTARISK, l.ch)
+		switch l.peekChar() {
+		case '=':
+			tok = l.readTwoCharToken(token.ASTARISK_ASSIGN)
+		case '*':
+			tok = l.readTwoCharToken(token.POWER)
+		default:
+			tok = newToken(token.ASTARISK, l.ch)
+		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
-	case '<':
+		switch l.peekChar() {
+		case '=':
+			tok = l.readTwoCharToken(token.SLASH_ASSIGN)
+		case '/':
+			tok = l.readTwoCharToken(token.FLOOR_DIV)
+		default:
+			tok = newToken(token.SLASH, l.ch)
+		}
+	case '%':
 		if l.peekChar() == '=' {
-			tok = l.readTwoCharToken(token.LE)
+			tok = l.readTwoCharToken(token.PERCENT_ASSIGN)
 		} else {
+			tok = newToken(token.PERCENT, l.ch)
+		}
+	case '<':
+		switch l.peekChar() {
+		case '=':
+			tok = l.readTwoCharToken(token.LE)
+		case '<':
+			tok = l.readTwoCharToken(token.SHL)
+		default:
 			tok = newToken(token.LT, l.ch)
 		}
 	case '>':
-		if l.peekChar() == '=' {
+		switch l.peekChar() {
+		case '=':
 			tok = l.readTwoCharToken(token.GE)
-		} else {
+		case '>':
+			tok = l.readTwoCharToken(token.SHR)
+		default:
 			tok = newToken(token.GT, l.ch)
 		}
 	case '&':
 		if l.peekChar() == '&' {
 			tok = l.readTwoCharToken(token.AND)
+		} else {
+			tok = newToken(token.BIT_AND, l.ch)
 		}
 	case '|':
-		if l.peekChar() == '|' {
+		switch l.peekChar() {
+		case '|':
 			tok = l.readTwoCharToken(token.OR)
+		case '>':
+			tok = l.readTwoCharToken(token.PIPE)
+		default:
+			tok = newToken(token.BIT_OR, l.ch)
+		}
+	case '?':
+		switch l.peekChar() {
+		case '?':
+			tok = l.readTwoCharToken(token.COALESCE)
+		case '.':
+			tok = l.readTwoCharToken(token.OPTIONAL_DOT)
+		case '[':
+			tok = l.readTwoCharToken(token.OPTIONAL_LBRACKET)
+		default:
+			tok = l.illegal(token.Position{}, "unexpected character %q", l.ch)
 		}
+	case '^':
+		tok = newToken(token.BIT_XOR, l.ch)
+	case '~':
+		tok = newToken(token.BIT_NOT, l.ch)
 	case '{':
+		if n := len(l.interpolations); n > 0 {
+			l.interpolations[n-1]++
+		}
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
+		if n := len(l.interpolations); n > 0 {
+			if l.interpolations[n-1] == 0 {
+				// The brace closes an embedded expression, so the string continues
+				l.interpolations = l.interpolations[:n-1]
+				tok = l.readStringToken(true)
+				break
+			}
+			l.interpolations[n-1]--
+		}
 		tok = newToken(token.RBRACE, l.ch)
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
 		tok = newToken(token.RBRACKET, l.ch)
 	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok = l.readStringToken(false)
+	case '`':
+		tok = l.readRawStringToken()
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -120,13 +346,27 @@ func (l *lexer) NextToken() token.Token {
 			return tok
 		}
 
-		tok = newToken(token.ILLEGAL, l.ch)
+		if l.invalidChar() {
+			tok = l.illegal(token.Position{}, "invalid UTF-8 encoding")
+		} else {
+			tok = l.illegal(token.Position{}, "unexpected character %q", l.ch)
+		}
 	}
 
 	l.readChar()
 	return tok
 }
 
+// pos returns the position of the current char.
+func (l *lexer) pos() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
 func (l *lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
 		l.readChar()
@@ -134,17 +374,62 @@ func (l *lexer) skipWhitespace() {
 }
 
 func (l *lexer) skipComment() {
-	for l.ch != '\n' && l.ch != '\r' {
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+}
+
+// skipBlockComment skips a block comment `#{ ... }#`. Block comments may be nested.
+// It reports false if the input ends before the comment is closed.
+func (l *lexer) skipBlockComment() bool {
+	depth := 0
+	for {
+		l.keep = l.position
+
+		switch {
+		case l.ch == 0:
+			return false
+		case l.ch == '#' && l.peekChar() == '{':
+			depth++
+			l.readChar()
+		case l.ch == '}' && l.peekChar() == '#':
+			depth--
+			l.readChar()
+			if depth == 0 {
+				l.readChar()
+				return true
+			}
+		}
+		l.readChar()
+	}
+}
+
+// readDocComment reads a `##` doc comment up to the end of the line.
+// A single space after `##` is not part of the comment text.
+func (l *lexer) readDocComment(pos token.Position) token.Token {
+	l.readChar()
+	l.readChar()
+	if l.ch == ' ' {
 		l.readChar()
 	}
-	l.skipWhitespace()
+
+	position := l.position
+	l.skipComment()
+
+	return token.Token{
+		Type:    token.DOC_COMMENT,
+		Literal: strings.TrimSuffix(l.text(position, l.position), "\r"),
+		Pos:     pos,
+	}
 }
 
-func (l *lexer) peekChar() byte {
-	if l.readPosition >= len(l.input) {
+func (l *lexer) peekChar() rune {
+	l.fill(l.readPosition + utf8.UTFMax)
+	if l.readPosition >= l.base+len(l.buf) {
 		return 0
 	}
-	return l.input[l.readPosition]
+	r, _ := utf8.DecodeRune(l.buf[l.readPosition-l.base:])
+	return r
 }
 
 func (l *lexer) readTwoCharToken(tokenType token.Type) token.Token {
@@ -156,59 +441,347 @@ func (l *lexer) readTwoCharToken(tokenType token.Type) token.Token {
 	}
 }
 
-func (l *lexer) readString() string {
-	position := l.position + 1
+// readStringToken reads a double-quoted string literal and decodes its escape sequences.
+// An unterminated string or a malformed escape sequence results in an ILLEGAL token.
+//
+// A string may embed expressions in `${...}`. The text before the first embedded expression
+// is read as a STRING_HEAD token, and the lexer returns to the string after the closing brace,
+// i.e. when `continued` is true, to read the rest as a STRING_MIDDLE or STRING_TAIL token.
+func (l *lexer) readStringToken(continued bool) token.Token {
+	var (
+		out     strings.Builder
+		invalid *token.Token
+	)
+
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+
+		switch l.ch {
+		case '"':
+			if invalid != nil {
+				return *invalid
+			}
+			if continued {
+				return token.Token{Type: token.STRING_TAIL, Literal: out.String()}
+			}
+			return token.Token{Type: token.STRING, Literal: out.String()}
+
+		case 0, '\n':
+			return l.illegal(token.Position{}, "unterminated string literal")
+
+		case '\\':
+			pos := l.pos()
+			if msg := l.readEscape(&out); msg != "" && invalid == nil {
+				tok := l.illegal(pos, "%s", msg)
+				invalid = &tok
+			}
+
+		case '$':
+			if l.peekChar() != '{' {
+				out.WriteRune(l.ch)
+				continue
+			}
+
+			l.readChar()
+			l.interpolations = append(l.interpolations, 0)
+
+			if invalid != nil {
+				return *invalid
+			}
+			if continued {
+				return token.Token{Type: token.STRING_MIDDLE, Literal: out.String()}
+			}
+			return token.Token{Type: token.STRING_HEAD, Literal: out.String()}
+
+		default:
+			// Copy the source bytes as they are, even if they are not valid UTF-8
+			out.WriteString(l.text(l.position, l.readPosition))
+		}
+	}
+}
+
+// readRawStringToken reads a backquoted raw string literal. A raw string may span multiple
+// lines, and neither escape sequences nor interpolations are processed. Carriage returns are
+// discarded so that the value does not depend on the line endings of the source file.
+func (l *lexer) readRawStringToken() token.Token {
+	var out strings.Builder
+
+	for {
+		l.readChar()
+
+		switch l.ch {
+		case '`':
+			return token.Token{Type: token.STRING, Literal: out.String()}
+		case 0:
+			return l.illegal(token.Position{}, "unterminated raw string literal")
+		case '\r':
+			// Discard carriage returns
+		default:
+			out.WriteString(l.text(l.position, l.readPosition))
+		}
+	}
+}
+
+// readEscape decodes an escape sequence starting at the current backslash and writes the
+// result to `out`. It returns a diagnostic message if the escape sequence is malformed.
+func (l *lexer) readEscape(out *strings.Builder) (msg string) {
+	// Leave a line break or the end of input to the caller to report an unterminated string
+	if next := l.peekChar(); next == 0 || next == '\n' {
+		return ""
+	}
+
+	l.readChar()
+
+	switch l.ch {
+	case 'a':
+		out.WriteByte('\a')
+	case 'b':
+		out.WriteByte('\b')
+	case 'f':
+		out.WriteByte('\f')
+	case 'n':
+		out.WriteByte('\n')
+	case 'r':
+		out.WriteByte('\r')
+	case 't':
+		out.WriteByte('\t')
+	case 'v':
+		out.WriteByte('\v')
+	case '0':
+		out.WriteByte(0)
+	case '\\', '"', '$':
+		out.WriteRune(l.ch)
+	case 'x':
+		// \xHH: a single byte given by exactly two hex digits
+		digits := l.readHexDigits(2)
+		if len(digits) != 2 {
+			return `invalid escape sequence "\x` + digits + `": want two hex digits`
+		}
+		out.WriteByte(byte(hexValue(digits)))
+	case 'u':
+		// \u{H...}: a Unicode code point given by one to six hex digits
+		if l.peekChar() != '{' {
+			return `invalid escape sequence "\u": want "\u{...}"`
+		}
+		l.readChar()
+
+		digits := l.readHexDigits(6)
+		if l.peekChar() != '}' || digits == "" {
+			return `invalid escape sequence "\u{` + digits + `": want one to six hex digits and "}"`
 		}
+		l.readChar()
+
+		r := rune(hexValue(digits))
+		if !utf8.ValidRune(r) {
+			return `invalid escape sequence "\u{` + digits + `}": not a valid code point`
+		}
+		out.WriteRune(r)
+	default:
+		return fmt.Sprintf(`unknown escape sequence "\%c"`, l.ch)
+	}
+
+	return ""
+}
+
+// readHexDigits reads up to `max` hex digits following the current char.
+func (l *lexer) readHexDigits(max int) string {
+	start := l.readPosition
+	for i := 0; i < max && isHexDigit(l.peekChar()); i++ {
+		l.readChar()
 	}
-	return l.input[position:l.position]
+	return l.text(start, l.readPosition)
 }
 
-func (l *lexer) read(checkFn func(byte) bool) string {
+// illegal returns an ILLEGAL token whose literal is a diagnostic message. If `pos` is not
+// valid, the token is positioned at the start of the token being read.
+func (l *lexer) illegal(pos token.Position, format string, a ...interface{}) token.Token {
+	return token.Token{
+		Type:    token.ILLEGAL,
+		Literal: fmt.Sprintf(format, a...),
+		Pos:     pos,
+	}
+}
+
+func (l *lexer) read(checkFn func(rune) bool) string {
 	position := l.position
 	for checkFn(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
+	return l.text(position, l.position)
 }
 
 func (l *lexer) readIdent() string {
-	return l.read(isLetter)
-}
-
-func (l *lexer) readNumber() string {
-	return l.read(isDigit)
+	return l.read(isIdentChar)
 }
 
+// readNumberToken reads an integer or a floating-point literal.
+//
+// Integers are written in decimal, or in hexadecimal, octal or binary with a 0x, 0o or 0b
+// prefix. Floats are decimal, need digits on both sides of the decimal point and may have an
+// exponent. Successive digits may be separated by underscores, e.g. 1_000_000.
 func (l *lexer) readNumberToken() token.Token {
-	intPart := l.readNumber()
-	if l.ch != '.' {
-		return token.Token{
-			Type:    token.INT,
-			Literal: intPart,
+	start := l.position
+	typ := token.Type(token.INT)
+
+	base := 10
+	if l.ch == '0' {
+		switch l.peekChar() {
+		case 'x', 'X':
+			base = 16
+		case 'o', 'O':
+			base = 8
+		case 'b', 'B':
+			base = 2
 		}
 	}
 
+	if base != 10 {
+		// Skip the prefix and read digits of any base to report invalid ones precisely
+		l.readChar()
+		l.readChar()
+		l.read(isHexDigitOrUnderscore)
+	} else {
+		l.read(isDigitOrUnderscore)
+
+		if l.ch == '.' {
+			switch next := l.peekChar(); {
+			case isDigit(next):
+				typ = token.FLOAT
+				l.readChar()
+				l.read(isDigitOrUnderscore)
+			case !isLetter(next):
+				l.readChar()
+				return l.illegal(token.Position{},
+					"invalid number %q: want a digit after the decimal point", l.text(start, l.position))
+			}
+		}
+
+		if l.ch == 'e' || l.ch == 'E' {
+			typ = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			if !isDigit(l.ch) {
+				l.read(isIdentChar)
+				return l.illegal(token.Position{},
+					"invalid number %q: exponent has no digits", l.text(start, l.position))
+			}
+			l.read(isDigitOrUnderscore)
+		}
+	}
+
+	end := l.position
+
+	// Read letters and digits stuck to the literal so that they are reported as a whole
+	l.read(isIdentChar)
+	lit := l.text(start, l.position)
+
+	if msg := checkNumber(lit, end-start, base, typ == token.FLOAT); msg != "" {
+		return l.illegal(token.Position{}, "invalid number %q: %s", lit, msg)
+	}
+
+	return token.Token{Type: typ, Literal: lit}
+}
+
+// readLeadingDotNumber reads a malformed number such as `.5` and returns an ILLEGAL token.
+func (l *lexer) readLeadingDotNumber() token.Token {
+	start := l.position
 	l.readChar()
-	fracPart := l.readNumber()
-	return token.Token{
-		Type:    token.FLOAT,
-		Literal: intPart + "." + fracPart,
+	l.read(isIdentChar)
+	return l.illegal(token.Position{},
+		"invalid number %q: want a digit before the decimal point", l.text(start, l.position))
+}
+
+var baseNames = map[int]string{2: "binary", 8: "octal", 10: "decimal", 16: "hexadecimal"}
+
+// checkNumber validates a numeric literal whose well-formed part is lit[:end] and returns
+// a diagnostic message, or an empty string if the literal is valid.
+func checkNumber(lit string, end, base int, isFloat bool) string {
+	kind := baseNames[base]
+
+	if end < len(lit) {
+		r, _ := utf8.DecodeRuneInString(lit[end:])
+		return fmt.Sprintf("invalid character %q in %s literal", r, kind)
+	}
+
+	digits := lit
+	if base != 10 {
+		digits = lit[2:]
+		if strings.Trim(digits, "_") == "" {
+			return kind + " literal has no digits"
+		}
+	}
+
+	isDigitOf := func(ch byte) bool {
+		if base == 16 {
+			return isHexDigit(rune(ch))
+		}
+		return isDigit(rune(ch))
 	}
+
+	for i := 0; i < len(digits); i++ {
+		ch := digits[i]
+		switch {
+		case ch == '_':
+			if i == 0 || i == len(digits)-1 || !isDigitOf(digits[i-1]) || !isDigitOf(digits[i+1]) {
+				return "'_' must separate successive digits"
+			}
+		case base < 10 && isDigit(rune(ch)) && int(ch-'0') >= base:
+			return fmt.Sprintf("invalid digit %q in %s literal", ch, kind)
+		}
+	}
+
+	if base == 10 && !isFloat && len(digits) > 1 && digits[0] == '0' {
+		return "leading zeros are not allowed in decimal literals; use the 0o prefix for octal"
+	}
+
+	return ""
+}
+
+// isLetter reports whether `ch` can start an identifier.
+func isLetter(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// isIdentChar reports whether `ch` can appear in an identifier after the first char.
+func isIdentChar(ch rune) bool {
+	return isLetter(ch) || isDigit(ch) || ch >= utf8.RuneSelf && unicode.IsDigit(ch)
 }
 
-func isDigit(ch byte) bool {
+// isDigit reports whether `ch` is an ASCII decimal digit. Numeric literals only use ASCII digits.
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func newToken(tokenType token.Type, ch byte) token.Token {
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+func isDigitOrUnderscore(ch rune) bool {
+	return isDigit(ch) || ch == '_'
+}
+
+func isHexDigitOrUnderscore(ch rune) bool {
+	return isHexDigit(ch) || ch == '_'
+}
+
+func hexValue(digits string) (v int) {
+	for _, ch := range digits {
+		switch {
+		case isDigit(ch):
+			v = v<<4 | int(ch-'0')
+		case 'a' <= ch && ch <= 'f':
+			v = v<<4 | int(ch-'a'+10)
+		default:
+			v = v<<4 | int(ch-'A'+10)
+		}
+	}
+	return v
+}
+
+func newToken(tokenType token.Type, ch rune) token.Token {
 	return token.Token{
 		Type:    tokenType,
 		Literal: string(ch),
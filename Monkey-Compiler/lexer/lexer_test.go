@@ -1,7 +1,11 @@
 package lexer
 
 import (
+	"errors"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"monkey-compiler/token"
 )
@@ -49,6 +53,21 @@ func TestNextToken(t *testing.T) {
 	b = nil;
 
 	macro(x, y) { x + y; };
+
+	while for break continue in import export
+	try catch finally throw const
+
+	x += 1 -= *= /= %= ++ -- - -1
+
+	% ** // & | ^ ~ << >>
+
+	[a, ...rest]
+
+	person.address.city
+
+	x => xs |> f
+
+	a ?? b?.c?[0] ?
 	`
 
 	tests := []struct {
@@ -201,6 +220,64 @@ func TestNextToken(t *testing.T) {
 		{token.SEMICOLON, ";"},
 		{token.RBRACE, "}"},
 		{token.SEMICOLON, ";"},
+		{token.WHILE, "while"},
+		{token.FOR, "for"},
+		{token.BREAK, "break"},
+		{token.CONTINUE, "continue"},
+		{token.IN, "in"},
+		{token.IMPORT, "import"},
+		{token.EXPORT, "export"},
+		{token.TRY, "try"},
+		{token.CATCH, "catch"},
+		{token.FINALLY, "finally"},
+		{token.THROW, "throw"},
+		{token.CONST, "const"},
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.ASTARISK_ASSIGN, "*="},
+		{token.SLASH_ASSIGN, "/="},
+		{token.PERCENT_ASSIGN, "%="},
+		{token.INC, "++"},
+		{token.DEC, "--"},
+		{token.MINUS, "-"},
+		{token.MINUS, "-"},
+		{token.INT, "1"},
+		{token.PERCENT, "%"},
+		{token.POWER, "**"},
+		{token.FLOOR_DIV, "//"},
+		{token.BIT_AND, "&"},
+		{token.BIT_OR, "|"},
+		{token.BIT_XOR, "^"},
+		{token.BIT_NOT, "~"},
+		{token.SHL, "<<"},
+		{token.SHR, ">>"},
+		{token.LBRACKET, "["},
+		{token.IDENT, "a"},
+		{token.COMMA, ","},
+		{token.ELLIPSIS, "..."},
+		{token.IDENT, "rest"},
+		{token.RBRACKET, "]"},
+		{token.IDENT, "person"},
+		{token.DOT, "."},
+		{token.IDENT, "address"},
+		{token.DOT, "."},
+		{token.IDENT, "city"},
+		{token.IDENT, "x"},
+		{token.ARROW, "=>"},
+		{token.IDENT, "xs"},
+		{token.PIPE, "|>"},
+		{token.IDENT, "f"},
+		{token.IDENT, "a"},
+		{token.COALESCE, "??"},
+		{token.IDENT, "b"},
+		{token.OPTIONAL_DOT, "?."},
+		{token.IDENT, "c"},
+		{token.OPTIONAL_LBRACKET, "?["},
+		{token.INT, "0"},
+		{token.RBRACKET, "]"},
+		{token.ILLEGAL, "unexpected character '?'"},
 		{token.EOF, ""},
 	}
 
@@ -220,3 +297,398 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n# comment\n  x + 10;\n"
+
+	tests := []struct {
+		expectedType token.Type
+		expectedPos  token.Position
+	}{
+		{token.LET, token.Position{Filename: "test.monkey", Offset: 0, Line: 1, Column: 1}},
+		{token.IDENT, token.Position{Filename: "test.monkey", Offset: 4, Line: 1, Column: 5}},
+		{token.ASSIGN, token.Position{Filename: "test.monkey", Offset: 6, Line: 1, Column: 7}},
+		{token.INT, token.Position{Filename: "test.monkey", Offset: 8, Line: 1, Column: 9}},
+		{token.SEMICOLON, token.Position{Filename: "test.monkey", Offset: 9, Line: 1, Column: 10}},
+		{token.IDENT, token.Position{Filename: "test.monkey", Offset: 23, Line: 3, Column: 3}},
+		{token.PLUS, token.Position{Filename: "test.monkey", Offset: 25, Line: 3, Column: 5}},
+		{token.INT, token.Position{Filename: "test.monkey", Offset: 27, Line: 3, Column: 7}},
+		{token.SEMICOLON, token.Position{Filename: "test.monkey", Offset: 29, Line: 3, Column: 9}},
+		{token.EOF, token.Position{Filename: "test.monkey", Offset: 31, Line: 4, Column: 1}},
+	}
+
+	l := NewFile("test.monkey", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - token type wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos != tt.expectedPos {
+			t.Fatalf("tests[%d] - position wrong. expected=%s, got=%s", i, tt.expectedPos, tok.Pos)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `# first
+# second
+x #{ block
+comment }# + #{ nested #{ block }# comment }# 1;
+## Adds one.
+##Doc without a space.
+y # trailing comment`
+
+	tests := []struct {
+		wantType    token.Type
+		wantLiteral string
+	}{
+		{token.IDENT, "x"},
+		{token.PLUS, "+"},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.DOC_COMMENT, "Adds one."},
+		{token.DOC_COMMENT, "Doc without a space."},
+		{token.IDENT, "y"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.wantType {
+			t.Fatalf("tests[%d] - token type wrong. expected=%q, got=%q", i, tt.wantType, tok.Type)
+		}
+
+		if tok.Literal != tt.wantLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.wantLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestUnterminatedBlockComment(t *testing.T) {
+	l := New("x\n  #{ open #{ nested }#\n")
+
+	if tok := l.NextToken(); tok.Type != token.IDENT {
+		t.Fatalf("token type wrong. expected=%q, got=%q", token.IDENT, tok.Type)
+	}
+
+	tok := l.NextToken()
+	if tok.Type != token.ILLEGAL || tok.Literal != "unterminated block comment" {
+		t.Fatalf("expected unterminated block comment, got %q (%q)", tok.Type, tok.Literal)
+	}
+
+	if tok.Pos.Line != 2 || tok.Pos.Column != 3 {
+		t.Errorf("position wrong. expected=2:3, got=%s", tok.Pos)
+	}
+
+	if tok := l.NextToken(); tok.Type != token.EOF {
+		t.Errorf("token type wrong. expected=%q, got=%q", token.EOF, tok.Type)
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`"a\nb"`, "a\nb"},
+		{`"\t\r\a\b\f\v\0"`, "\t\r\a\b\f\v\x00"},
+		{`"say \"hi\""`, `say "hi"`},
+		{`"back\\slash"`, `back\slash`},
+		{`"\x41\x7a"`, "Az"},
+		{`"\u{1F600}"`, "\U0001F600"},
+		{`"\u{e9}t\u{E9}"`, "été"},
+		{`"∑"`, "∑"},
+		{`"cost \${x}"`, "cost ${x}"},
+	}
+
+	for _, tt := range tests {
+		tok := New(tt.input).NextToken()
+
+		if tok.Type != token.STRING {
+			t.Errorf("%s - token type wrong. expected=%q, got=%q (%q)", tt.input, token.STRING, tok.Type, tok.Literal)
+			continue
+		}
+
+		if tok.Literal != tt.want {
+			t.Errorf("%s - literal wrong. expected=%q, got=%q", tt.input, tt.want, tok.Literal)
+		}
+	}
+}
+
+func TestIllegalStrings(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantMsg string
+		wantCol int
+	}{
+		{`"abc`, "unterminated string literal", 1},
+		{"x = \"abc\ny\";", "unterminated string literal", 5},
+		{`"abc\`, "unterminated string literal", 1},
+		{`"a\qb"`, `unknown escape sequence "\q"`, 3},
+		{`"\x4"`, `invalid escape sequence "\x4": want two hex digits`, 2},
+		{`"\u0041"`, `invalid escape sequence "\u": want "\u{...}"`, 2},
+		{`"\u{}"`, `invalid escape sequence "\u{": want one to six hex digits and "}"`, 2},
+		{`"\u{1234567}"`, `invalid escape sequence "\u{123456": want one to six hex digits and "}"`, 2},
+		{`"\u{D800}"`, `invalid escape sequence "\u{D800}": not a valid code point`, 2},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+
+		tok := l.NextToken()
+		for tok.Type != token.ILLEGAL && tok.Type != token.EOF {
+			tok = l.NextToken()
+		}
+
+		if tok.Type != token.ILLEGAL {
+			t.Errorf("%s - expected ILLEGAL token, got EOF", tt.input)
+			continue
+		}
+
+		if tok.Literal != tt.wantMsg {
+			t.Errorf("%s - diagnostic wrong. expected=%q, got=%q", tt.input, tt.wantMsg, tok.Literal)
+		}
+
+		if tok.Pos.Column != tt.wantCol {
+			t.Errorf("%s - column wrong. expected=%d, got=%d", tt.input, tt.wantCol, tok.Pos.Column)
+		}
+	}
+}
+
+func TestLexingContinuesAfterIllegalString(t *testing.T) {
+	l := New("\"bad\\q\" + 1;\n\"open\nlet")
+
+	want := []token.Type{token.ILLEGAL, token.PLUS, token.INT, token.SEMICOLON, token.ILLEGAL, token.LET, token.EOF}
+	for i, typ := range want {
+		if tok := l.NextToken(); tok.Type != typ {
+			t.Fatalf("tests[%d] - token type wrong. expected=%q, got=%q", i, typ, tok.Type)
+		}
+	}
+}
+
+func TestStringInterpolation(t *testing.T) {
+	input := `"a ${x} b ${ {"k": 1}["k"] } c" + "${"in${y}"}"`
+
+	tests := []struct {
+		wantType    token.Type
+		wantLiteral string
+	}{
+		{token.STRING_HEAD, "a "},
+		{token.IDENT, "x"},
+		{token.STRING_MIDDLE, " b "},
+		{token.LBRACE, "{"},
+		{token.STRING, "k"},
+		{token.COLON, ":"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.LBRACKET, "["},
+		{token.STRING, "k"},
+		{token.RBRACKET, "]"},
+		{token.STRING_TAIL, " c"},
+		{token.PLUS, "+"},
+		{token.STRING_HEAD, ""},
+		{token.STRING_HEAD, "in"},
+		{token.IDENT, "y"},
+		{token.STRING_TAIL, ""},
+		{token.STRING_TAIL, ""},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.wantType {
+			t.Fatalf("tests[%d] - token type wrong. expected=%q, got=%q (%q)", i, tt.wantType, tok.Type, tok.Literal)
+		}
+
+		if tok.Literal != tt.wantLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.wantLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestRawStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		wantType token.Type
+		want     string
+	}{
+		{"`plain`", token.STRING, "plain"},
+		{"`no \\n ${escapes}`", token.STRING, `no \n ${escapes}`},
+		{"`two\nlines`", token.STRING, "two\nlines"},
+		{"`crlf\r\nlines`", token.STRING, "crlf\nlines"},
+		{"`open", token.ILLEGAL, "unterminated raw string literal"},
+	}
+
+	for _, tt := range tests {
+		tok := New(tt.input).NextToken()
+
+		if tok.Type != tt.wantType {
+			t.Errorf("%s - token type wrong. expected=%q, got=%q", tt.input, tt.wantType, tok.Type)
+		}
+
+		if tok.Literal != tt.want {
+			t.Errorf("%s - literal wrong. expected=%q, got=%q", tt.input, tt.want, tok.Literal)
+		}
+	}
+}
+
+func TestUnicodeIdentifiers(t *testing.T) {
+	input := "let größe = 数量2 + _x9 + π;\n\"ü\" @ € \xff"
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+		expectedColumn  int
+	}{
+		{token.LET, "let", 1},
+		{token.IDENT, "größe", 5},
+		{token.ASSIGN, "=", 11},
+		{token.IDENT, "数量2", 13},
+		{token.PLUS, "+", 17},
+		{token.IDENT, "_x9", 19},
+		{token.PLUS, "+", 23},
+		{token.IDENT, "π", 25},
+		{token.SEMICOLON, ";", 26},
+		{token.STRING, "ü", 1},
+		{token.ILLEGAL, "unexpected character '@'", 5},
+		{token.ILLEGAL, "unexpected character '€'", 7},
+		{token.ILLEGAL, "invalid UTF-8 encoding", 9},
+		{token.EOF, "", 10},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - token type wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - column wrong. expected=%d, got=%d", i, tt.expectedColumn, tok.Pos.Column)
+		}
+	}
+}
+
+func TestNumericLiterals(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{"0", token.INT, "0"},
+		{"0xFF", token.INT, "0xFF"},
+		{"0Xdead_beef", token.INT, "0Xdead_beef"},
+		{"0o17", token.INT, "0o17"},
+		{"0b1010", token.INT, "0b1010"},
+		{"0b_1", token.ILLEGAL, `invalid number "0b_1": '_' must separate successive digits`},
+		{"1_000_000", token.INT, "1_000_000"},
+		{"6.02e23", token.FLOAT, "6.02e23"},
+		{"1e10", token.FLOAT, "1e10"},
+		{"1.5E-3", token.FLOAT, "1.5E-3"},
+		{"2e+8", token.FLOAT, "2e+8"},
+		{"0.5", token.FLOAT, "0.5"},
+		{"1_000.000_1", token.FLOAT, "1_000.000_1"},
+		{"0x", token.ILLEGAL, `invalid number "0x": hexadecimal literal has no digits`},
+		{"0b102", token.ILLEGAL, `invalid number "0b102": invalid digit '2' in binary literal`},
+		{"0o78", token.ILLEGAL, `invalid number "0o78": invalid digit '8' in octal literal`},
+		{"0xFG", token.ILLEGAL, `invalid number "0xFG": invalid character 'G' in hexadecimal literal`},
+		{"12abc", token.ILLEGAL, `invalid number "12abc": invalid character 'a' in decimal literal`},
+		{"1__0", token.ILLEGAL, `invalid number "1__0": '_' must separate successive digits`},
+		{"10_", token.ILLEGAL, `invalid number "10_": '_' must separate successive digits`},
+		{"1_.5", token.ILLEGAL, `invalid number "1_.5": '_' must separate successive digits`},
+		{"1e", token.ILLEGAL, `invalid number "1e": exponent has no digits`},
+		{"1e+x", token.ILLEGAL, `invalid number "1e+x": exponent has no digits`},
+		{"017", token.ILLEGAL,
+			`invalid number "017": leading zeros are not allowed in decimal literals; use the 0o prefix for octal`},
+		{".5", token.ILLEGAL, `invalid number ".5": want a digit before the decimal point`},
+		{"5.", token.ILLEGAL, `invalid number "5.": want a digit after the decimal point`},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Errorf("%s - token type wrong. expected=%q, got=%q (%q)", tt.input, tt.expectedType, tok.Type, tok.Literal)
+			continue
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("%s - literal wrong. expected=%q, got=%q", tt.input, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok := l.NextToken(); tok.Type != token.EOF {
+			t.Errorf("%s - expected EOF after the literal, got %q (%q)", tt.input, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestReaderLexer(t *testing.T) {
+	input := `let größe = fn(x) { x * 1_000 + 0x1F };
+## Doc comment.
+#{ block #{ nested }# }# "tab\t${größe(2.5e1)} 😀" != ` + "`raw\r\nstring`" + `;
+"unterminated
+\xff @ 5.`
+
+	want := NewFile("test.monkey", input)
+	got := NewFileReader("test.monkey", iotest.OneByteReader(strings.NewReader(input)))
+
+	for i := 0; ; i++ {
+		wantTok, gotTok := want.NextToken(), got.NextToken()
+		if gotTok != wantTok {
+			t.Fatalf("tokens[%d] - token wrong. expected=%#v, got=%#v", i, wantTok, gotTok)
+		}
+
+		if wantTok.Type == token.EOF {
+			break
+		}
+	}
+}
+
+func TestReaderLexerDiscardsReadInput(t *testing.T) {
+	line := "let x = \"some string\"; # comment\n"
+	input := strings.Repeat(line, 10000)
+
+	l := NewReader(strings.NewReader(input)).(*lexer)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+	}
+
+	if size := cap(l.buf); size > 4*chunkSize {
+		t.Errorf("buffer holds %d bytes of %d bytes of input", size, len(input))
+	}
+
+	if l.line != 10001 {
+		t.Errorf("line wrong. expected=%d, got=%d", 10001, l.line)
+	}
+}
+
+func TestReaderLexerError(t *testing.T) {
+	r := io.MultiReader(strings.NewReader("let x"), iotest.ErrReader(errors.New("connection reset")))
+	l := NewReader(r)
+
+	want := []token.Token{
+		{Type: token.LET, Literal: "let", Pos: token.Position{Offset: 0, Line: 1, Column: 1}},
+		{Type: token.IDENT, Literal: "x", Pos: token.Position{Offset: 4, Line: 1, Column: 5}},
+		{Type: token.ILLEGAL, Literal: "could not read input: connection reset", Pos: token.Position{Offset: 5, Line: 1, Column: 6}},
+		{Type: token.EOF, Literal: "", Pos: token.Position{Offset: 5, Line: 1, Column: 6}},
+	}
+
+	for i, tt := range want {
+		if tok := l.NextToken(); tok != tt {
+			t.Fatalf("tokens[%d] - token wrong. expected=%#v, got=%#v", i, tt, tok)
+		}
+	}
+}
@@ -3,13 +3,12 @@ package main
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"strings"
 
 	"monkey-compiler/compiler"
-	"monkey-compiler/eval"
 	"monkey-compiler/lexer"
+	"monkey-compiler/module"
 	"monkey-compiler/object"
 	"monkey-compiler/parser"
 	"monkey-compiler/repl"
@@ -32,25 +31,41 @@ func main() {
 	}
 }
 
+// runScript runs the Monkey script in the file `filename`, or the script read from the
+// standard input if `filename` is "-".
 func runScript(filename string) error {
-	data, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return fmt.Errorf("could not read %s: %v", filename, err)
+	src := os.Stdin
+	if filename != "-" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %v", filename, err)
+		}
+		defer f.Close()
+		src = f
 	}
 
-	p := parser.New(lexer.New(string(data)))
+	p := parser.New(lexer.NewFileReader(filename, src))
 	program := p.ParseProgram()
 	if len(p.Errors()) != 0 {
-		return errors.New(strings.Join(p.Errors(), "\n"))
+		return parseErrors(filename, p.Errors())
 	}
 
-	// Process macros
-	macroEnv := object.NewEnvironment()
-	eval.DefineMacros(program, macroEnv)
-	expanded := eval.ExpandMacros(program, macroEnv)
+	// Process macros, including the ones of imported modules
+	loader := module.NewLoader()
+	if filename != "-" {
+		var err error
+		if loader, err = module.NewScriptLoader(filename); err != nil {
+			return err
+		}
+	}
+	expanded, err := loader.ExpandMacros(program, object.NewEnvironment())
+	if err != nil {
+		return err
+	}
 
 	// Compile the AST to bytecode
 	c := compiler.New()
+	c.SetLoader(loader)
 	if err := c.Compile(expanded); err != nil {
 		return fmt.Errorf("Woops! Compilation failed: %s", err)
 	}
@@ -63,3 +78,30 @@ func runScript(filename string) error {
 
 	return nil
 }
+
+// parseErrors returns an error which lists parse errors, each followed by the source line it
+// occurred on with a caret under the offending column. Source lines are only shown for
+// scripts read from a file, because the standard input cannot be read again.
+func parseErrors(filename string, errs []*parser.ParseError) error {
+	var lines []string
+	if filename != "-" {
+		if data, err := os.ReadFile(filename); err == nil {
+			lines = strings.Split(string(data), "\n")
+		}
+	}
+
+	var out strings.Builder
+	for i, err := range errs {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		out.WriteString(err.Error())
+
+		if line := err.Pos.Line; line > 0 && line <= len(lines) {
+			out.WriteString("\n")
+			out.WriteString(strings.TrimSuffix(err.Caret(lines[line-1]), "\n"))
+		}
+	}
+
+	return errors.New(out.String())
+}
@@ -0,0 +1,183 @@
+// Package module loads the Monkey source files imported by `import` statements.
+package module
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"monkey-compiler/ast"
+	"monkey-compiler/eval"
+	"monkey-compiler/lexer"
+	"monkey-compiler/object"
+	"monkey-compiler/parser"
+	"monkey-compiler/token"
+)
+
+// Module is a source file loaded by an import.
+type Module struct {
+	// Path is the absolute path of the file, which identifies the module.
+	Path string
+	// Name is the path of the file as the first import of the module resolved it, which is
+	// used in messages.
+	Name string
+	// Program is the program in the file with its macros expanded.
+	Program *ast.Program
+	// Exports are the names of the bindings the module exports, in the order of definition.
+	Exports []string
+	// Macros holds the macros the module exports.
+	Macros *object.Module
+}
+
+// Loader loads modules and caches them, so that each file is loaded once however many times it
+// is imported.
+type Loader struct {
+	modules map[string]*Module
+	// loading is the stack of the paths of the modules being loaded, which is used to detect
+	// import cycles.
+	loading []string
+}
+
+// NewLoader returns a new Loader.
+func NewLoader() *Loader {
+	return &Loader{modules: make(map[string]*Module)}
+}
+
+// NewScriptLoader returns a new Loader for the script in the file `filename`. The script is
+// being loaded while the modules it imports are, so that an import cycle leading back to it is
+// reported instead of loading the script again as a module.
+func NewScriptLoader(filename string) (*Loader, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", filename, err)
+	}
+
+	l := NewLoader()
+	l.loading = append(l.loading, abs)
+	return l, nil
+}
+
+// Load loads the module at `path` imported by the statement at `from`. A relative path is
+// resolved from the directory of the importing file, or from the working directory if the
+// importing program was not read from a file.
+func (l *Loader) Load(path string, from token.Position) (*Module, error) {
+	name := path
+	if dir := from.Filename; dir != "" && dir != "-" && !filepath.IsAbs(path) {
+		name = filepath.Join(filepath.Dir(dir), path)
+	}
+
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return nil, errorf(from, "could not import %s: %v", path, err)
+	}
+
+	if mod, ok := l.modules[abs]; ok {
+		return mod, nil
+	}
+
+	for i, loading := range l.loading {
+		if loading == abs {
+			cycle := make([]string, 0, len(l.loading)-i+1)
+			for _, p := range l.loading[i:] {
+				cycle = append(cycle, filepath.Base(p))
+			}
+			cycle = append(cycle, filepath.Base(abs))
+			return nil, errorf(from, "import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	l.loading = append(l.loading, abs)
+	defer func() { l.loading = l.loading[:len(l.loading)-1] }()
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errorf(from, "could not import %s: %v", path, err)
+	}
+	defer f.Close()
+
+	p := parser.New(lexer.NewFileReader(name, f))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		msgs := make([]string, 0, len(p.Errors()))
+		for _, err := range p.Errors() {
+			msgs = append(msgs, err.Error())
+		}
+		return nil, errors.New(strings.Join(msgs, "\n"))
+	}
+
+	// Macro definitions are removed from the program when the macros are expanded
+	macroNames := exportedNames(program, true)
+
+	env := object.NewEnvironment()
+	expanded, err := l.ExpandMacros(program, env)
+	if err != nil {
+		return nil, err
+	}
+
+	mod := &Module{
+		Path:    abs,
+		Name:    name,
+		Program: expanded,
+		Exports: exportedNames(expanded, false),
+		Macros:  &object.Module{Name: name, Exports: make(map[string]object.Object)},
+	}
+	for _, macro := range macroNames {
+		mod.Macros.Exports[macro], _ = env.Get(macro)
+	}
+
+	l.modules[abs] = mod
+
+	return mod, nil
+}
+
+// ExpandMacros defines the macros in `program` and the ones exported by the modules it imports
+// in `env`, and returns `program` with the macros expanded. The macros of a module are called
+// as members of the name it is imported as, e.g. `strings.unless(...)`.
+func (l *Loader) ExpandMacros(program *ast.Program, env object.Environment) (*ast.Program, error) {
+	for _, stmt := range program.Statements {
+		imp, ok := stmt.(*ast.ImportStatement)
+		if !ok {
+			continue
+		}
+
+		mod, err := l.Load(imp.Path.Value, imp.Pos())
+		if err != nil {
+			return nil, err
+		}
+		env.Set(imp.Name.Value, mod.Macros)
+	}
+
+	eval.DefineMacros(program, env)
+	return eval.ExpandMacros(program, env).(*ast.Program), nil
+}
+
+// exportedNames returns the names bound by the exported let statements in `program` whose
+// values are macros if `macros` is true, or are not otherwise.
+func exportedNames(program *ast.Program, macros bool) []string {
+	names := make([]string, 0)
+	for _, stmt := range program.Statements {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok || !let.Exported {
+			continue
+		}
+		if _, ok := let.Value.(*ast.MacroLiteral); ok != macros {
+			continue
+		}
+
+		for _, ident := range ast.PatternIdents(let.Target()) {
+			names = append(names, ident.Value)
+		}
+	}
+	return names
+}
+
+// errorf returns an error prefixed with the position `pos` if it is valid.
+func errorf(pos token.Position, format string, a ...interface{}) error {
+	msg := fmt.Sprintf(format, a...)
+	if pos.IsValid() {
+		msg = pos.String() + ": " + msg
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,146 @@
+package module
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"monkey-compiler/lexer"
+	"monkey-compiler/object"
+	"monkey-compiler/parser"
+	"monkey-compiler/token"
+)
+
+func TestLoad(t *testing.T) {
+	l := NewLoader()
+
+	mod, err := l.Load("testdata/strings.monkey", token.Position{})
+	if err != nil {
+		t.Fatalf("Load failed: %s", err)
+	}
+
+	wantPath, _ := filepath.Abs("testdata/strings.monkey")
+	if mod.Path != wantPath {
+		t.Errorf("wrong path. want=%q, got=%q", wantPath, mod.Path)
+	}
+	if mod.Name != "testdata/strings.monkey" {
+		t.Errorf("wrong name. want=%q, got=%q", "testdata/strings.monkey", mod.Name)
+	}
+
+	wantExports := []string{"shout", "first", "second"}
+	if !reflect.DeepEqual(mod.Exports, wantExports) {
+		t.Errorf("wrong exports. want=%v, got=%v", wantExports, mod.Exports)
+	}
+
+	if len(mod.Macros.Exports) != 1 {
+		t.Fatalf("wrong number of exported macros. want=1, got=%d", len(mod.Macros.Exports))
+	}
+	if _, ok := mod.Macros.Exports["unless"].(*object.Macro); !ok {
+		t.Errorf("macro unless is not exported. got=%T", mod.Macros.Exports["unless"])
+	}
+
+	// Imports are resolved relative to the importing file, and each file is loaded once
+	for _, path := range []string{"testdata/util/exclaim.monkey", "testdata/util/marks.monkey"} {
+		abs, _ := filepath.Abs(path)
+		if _, ok := l.modules[abs]; !ok {
+			t.Errorf("module %s is not loaded", path)
+		}
+	}
+
+	from := token.Position{Filename: "testdata/main.monkey", Line: 1, Column: 1}
+	again, err := l.Load("strings.monkey", from)
+	if err != nil {
+		t.Fatalf("Load failed: %s", err)
+	}
+	if again != mod {
+		t.Errorf("module is loaded again")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{
+			"testdata/cycle/a.monkey",
+			"testdata/cycle/b.monkey:1:1: import cycle: a.monkey -> b.monkey -> a.monkey",
+		},
+		{
+			"testdata/missing.monkey",
+			"main.monkey:1:1: could not import testdata/missing.monkey: " +
+				"open testdata/missing.monkey: no such file or directory",
+		},
+		{
+			"testdata/broken.monkey",
+			"testdata/broken.monkey:2:7: expected next token to be =, got INT instead",
+		},
+	}
+
+	for _, tt := range tests {
+		from := token.Position{Filename: "main.monkey", Line: 1, Column: 1}
+		_, err := NewLoader().Load(tt.path, from)
+		if err == nil {
+			t.Errorf("%s - expected an error, got none", tt.path)
+			continue
+		}
+		if err.Error() != tt.want {
+			t.Errorf("%s - wrong error. want=%q, got=%q", tt.path, tt.want, err.Error())
+		}
+	}
+}
+
+func TestImportCycleThroughScript(t *testing.T) {
+	const filename = "testdata/cycle/a.monkey"
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("could not open %s: %s", filename, err)
+	}
+	defer f.Close()
+
+	p := parser.New(lexer.NewFileReader(filename, f))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser has %d errors: %s", len(p.Errors()), p.Errors()[0])
+	}
+
+	l, err := NewScriptLoader(filename)
+	if err != nil {
+		t.Fatalf("NewScriptLoader failed: %s", err)
+	}
+
+	// The script is not loaded again as a module by the import leading back to it
+	_, err = l.ExpandMacros(program, object.NewEnvironment())
+	want := "testdata/cycle/b.monkey:1:1: import cycle: a.monkey -> b.monkey -> a.monkey"
+	if err == nil {
+		t.Fatalf("expected an error, got none")
+	}
+	if err.Error() != want {
+		t.Errorf("wrong error. want=%q, got=%q", want, err.Error())
+	}
+}
+
+func TestExpandImportedMacros(t *testing.T) {
+	input := `
+	import "testdata/strings.monkey" as str
+	str.unless(10 > 5, puts("not greater"), puts("greater"));
+	`
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser has %d errors: %s", len(p.Errors()), p.Errors()[0])
+	}
+
+	expanded, err := NewLoader().ExpandMacros(program, object.NewEnvironment())
+	if err != nil {
+		t.Fatalf("ExpandMacros failed: %s", err)
+	}
+
+	want := `import "testdata/strings.monkey" as str;` +
+		`if(!(10 > 5)) puts(not greater)else puts(greater)`
+	if got := expanded.String(); got != want {
+		t.Errorf("wrong program. want=%q, got=%q", want, got)
+	}
+}
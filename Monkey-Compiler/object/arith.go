@@ -0,0 +1,50 @@
+package object
+
+import "math"
+
+// IsNegativeInteger reports whether `obj` is an integer less than zero, e.g. an exponent which
+// makes a power of integers a fraction.
+func IsNegativeInteger(obj Object) bool {
+	i, ok := obj.(*Integer)
+	return ok && i.Value < 0
+}
+
+// FloorDiv returns the quotient of `x` and `y` rounded towards negative infinity.
+func FloorDiv(x, y int64) int64 {
+	q := x / y
+	if x%y != 0 && (x < 0) != (y < 0) {
+		q--
+	}
+	return q
+}
+
+// FloorMod returns the remainder of the floored division of `x` by `y`, which has the sign of
+// `y`.
+func FloorMod(x, y int64) int64 {
+	m := x % y
+	if m != 0 && (m < 0) != (y < 0) {
+		m += y
+	}
+	return m
+}
+
+// FloatFloorMod is like FloorMod for floating-point numbers.
+func FloatFloorMod(x, y float64) float64 {
+	m := math.Mod(x, y)
+	if m != 0 && (m < 0) != (y < 0) {
+		m += y
+	}
+	return m
+}
+
+// IntPow returns `x` to the power of `y`, which must not be negative.
+func IntPow(x, y int64) int64 {
+	result := int64(1)
+	for ; y > 0; y >>= 1 {
+		if y&1 == 1 {
+			result *= x
+		}
+		x *= x
+	}
+	return result
+}
@@ -14,7 +14,7 @@ var Builtins = []struct {
 		Builtin: &Builtin{
 			Fn: func(args ...Object) Object {
 				if l := len(args); l != 1 {
-					return newError("wrong number of arguments. want=1, got=%d", l)
+					return NewError(ArgumentError, "wrong number of arguments. want=1, got=%d", l)
 				}
 
 				switch arg := args[0].(type) {
@@ -23,7 +23,7 @@ var Builtins = []struct {
 				case *Array:
 					return &Integer{Value: int64(len(arg.Elements))}
 				default:
-					return newError("argument to `len` not supported, got %s", arg.Type())
+					return NewError(TypeError, "argument to `len` not supported, got %s", arg.Type())
 				}
 			},
 		},
@@ -44,11 +44,11 @@ var Builtins = []struct {
 		Builtin: &Builtin{
 			Fn: func(args ...Object) Object {
 				if l := len(args); l != 1 {
-					return newError("wrong number of arguments. want=1, got=%d", l)
+					return NewError(ArgumentError, "wrong number of arguments. want=1, got=%d", l)
 				}
 
 				if typ := args[0].Type(); typ != ArrayType {
-					return newError("argument to `first` must be Array, got %s", typ)
+					return NewError(TypeError, "argument to `first` must be Array, got %s", typ)
 				}
 
 				arr := args[0].(*Array)
@@ -64,11 +64,11 @@ var Builtins = []struct {
 		Builtin: &Builtin{
 			Fn: func(args ...Object) Object {
 				if l := len(args); l != 1 {
-					return newError("wrong number of arguments. want=1, got=%d", l)
+					return NewError(ArgumentError, "wrong number of arguments. want=1, got=%d", l)
 				}
 
 				if typ := args[0].Type(); typ != ArrayType {
-					return newError("argument to `last` must be Array, got %s", typ)
+					return NewError(TypeError, "argument to `last` must be Array, got %s", typ)
 				}
 
 				arr := args[0].(*Array)
@@ -84,11 +84,11 @@ var Builtins = []struct {
 		Builtin: &Builtin{
 			Fn: func(args ...Object) Object {
 				if l := len(args); l != 1 {
-					return newError("wrong number of arguments. want=1, got=%d", l)
+					return NewError(ArgumentError, "wrong number of arguments. want=1, got=%d", l)
 				}
 
 				if typ := args[0].Type(); typ != ArrayType {
-					return newError("argument to `last` must be Array, got %s", typ)
+					return NewError(TypeError, "argument to `last` must be Array, got %s", typ)
 				}
 
 				arr := args[0].(*Array)
@@ -108,11 +108,11 @@ var Builtins = []struct {
 		Builtin: &Builtin{
 			Fn: func(args ...Object) Object {
 				if l := len(args); l != 2 {
-					return newError("wrong number of arguments. want=%d, got=%d", 2, l)
+					return NewError(ArgumentError, "wrong number of arguments. want=%d, got=%d", 2, l)
 				}
 
 				if typ := args[0].Type(); typ != ArrayType {
-					return newError("first argument to `push` must be Array, got %s", typ)
+					return NewError(TypeError, "first argument to `push` must be Array, got %s", typ)
 				}
 
 				arr := args[0].(*Array)
@@ -125,6 +125,30 @@ var Builtins = []struct {
 			},
 		},
 	},
+	{
+		Name: "freeze",
+		Builtin: &Builtin{
+			Fn: func(args ...Object) Object {
+				if l := len(args); l != 1 {
+					return NewError(ArgumentError, "wrong number of arguments. want=1, got=%d", l)
+				}
+
+				// The elements themselves are not frozen
+				switch arg := args[0].(type) {
+				case *Array:
+					arg.Frozen = true
+				case *Hash:
+					arg.Frozen = true
+				default:
+					return NewError(
+						TypeError,
+						"argument to `freeze` must be Array or Hash, got %s", arg.Type(),
+					)
+				}
+				return args[0]
+			},
+		},
+	},
 }
 
 // GetBuiltinByName returns a built-in function matching a given name.
@@ -138,7 +162,3 @@ func GetBuiltinByName(name string) *Builtin {
 
 	return nil
 }
-
-func newError(format string, a ...interface{}) *Error {
-	return &Error{Message: fmt.Sprintf(format, a...)}
-}
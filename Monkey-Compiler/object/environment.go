@@ -9,13 +9,30 @@ type Environment interface {
 
 	// Set sets the `val` of a variable named by the `name` and returns the `val` itself.
 	Set(name string, val Object) Object
+
+	// SetConst sets the `val` of a constant named by the `name` and returns the `val` itself.
+	SetConst(name string, val Object) Object
+
+	// Assign sets the `val` of the existing variable named by the `name` in the innermost
+	// environment which has it, and reports whether the variable exists.
+	Assign(name string, val Object) bool
+
+	// IsConst reports whether the variable named by the `name` in the innermost environment
+	// which has it is a constant.
+	IsConst(name string) bool
+
+	// HasConst reports whether the environment itself, not an outer one, has a constant named
+	// by the `name`.
+	HasConst(name string) bool
 }
 
 // environment implements Environment interface.
 // environment is not thread safe, so do not use it in multiple goroutines.
 type environment struct {
 	store map[string]Object
-	outer Environment
+	// consts holds the names of the constants in the store. It is nil until one is set.
+	consts map[string]bool
+	outer  Environment
 }
 
 // NewEnvironment returns a new Environment.
@@ -40,9 +57,45 @@ func (e *environment) Get(name string) (Object, bool) {
 // Set sets the `val` of a variable named by the `name` and returns the `val` itself.
 func (e *environment) Set(name string, val Object) Object {
 	e.store[name] = val
+	delete(e.consts, name)
+	return val
+}
+
+// SetConst sets the `val` of a constant named by the `name` and returns the `val` itself.
+func (e *environment) SetConst(name string, val Object) Object {
+	if e.consts == nil {
+		e.consts = make(map[string]bool)
+	}
+	e.store[name] = val
+	e.consts[name] = true
 	return val
 }
 
+// Assign sets the `val` of the existing variable named by the `name` in the innermost
+// environment which has it, and reports whether the variable exists.
+func (e *environment) Assign(name string, val Object) bool {
+	if _, exists := e.store[name]; exists {
+		e.store[name] = val
+		return true
+	}
+	return e.outer != nil && e.outer.Assign(name, val)
+}
+
+// IsConst reports whether the variable named by the `name` in the innermost environment which
+// has it is a constant.
+func (e *environment) IsConst(name string) bool {
+	if _, exists := e.store[name]; exists {
+		return e.consts[name]
+	}
+	return e.outer != nil && e.outer.IsConst(name)
+}
+
+// HasConst reports whether the environment itself, not an outer one, has a constant named by
+// the `name`.
+func (e *environment) HasConst(name string) bool {
+	return e.consts[name]
+}
+
 // NewEnclosedEnvironment creates a new Environment which holds the given outer Environment.
 func NewEnclosedEnvironment(outer Environment) Environment {
 	return &environment{
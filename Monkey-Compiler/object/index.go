@@ -0,0 +1,83 @@
+package object
+
+// Index returns the position of the element at index `i` in a sequence of `length` elements,
+// counting from the end if `i` is negative, or false if it is out of range.
+func Index(i int64, length int) (int, bool) {
+	if i < 0 {
+		i += int64(length)
+	}
+	if i < 0 || i >= int64(length) {
+		return 0, false
+	}
+	return int(i), true
+}
+
+// CharAt returns the character at index `i` in `s` as a string, or false if it is out of range.
+// Like iteration over strings, indices count characters rather than bytes.
+func CharAt(s *String, i int64) (*String, bool) {
+	runes := []rune(s.Value)
+	pos, ok := Index(i, len(runes))
+	if !ok {
+		return nil, false
+	}
+	return &String{Value: string(runes[pos])}, true
+}
+
+// Slice returns a new array of the elements, or a new string of the characters, of `obj` from
+// index `start` up to but not including index `end`. Negative indices count from the end, nil
+// ones stand for the start and the end of `obj`, and ones out of range are clamped.
+func Slice(obj, start, end Object) (Object, *Error) {
+	var length int
+	var runes []rune
+	switch obj := obj.(type) {
+	case *Array:
+		length = len(obj.Elements)
+	case *String:
+		runes = []rune(obj.Value)
+		length = len(runes)
+	default:
+		return nil, NewError(TypeError, "slice operator not supported: %s", obj.Type())
+	}
+
+	from, err := sliceBound(start, 0, length)
+	if err != nil {
+		return nil, err
+	}
+	to, err := sliceBound(end, length, length)
+	if err != nil {
+		return nil, err
+	}
+	if to < from {
+		to = from
+	}
+
+	if arr, ok := obj.(*Array); ok {
+		elems := make([]Object, to-from)
+		copy(elems, arr.Elements[from:to])
+		return &Array{Elements: elems}, nil
+	}
+	return &String{Value: string(runes[from:to])}, nil
+}
+
+// sliceBound returns the position in a sequence of `length` elements which the slice index
+// `bound` stands for, or `omitted` if `bound` is nil.
+func sliceBound(bound Object, omitted, length int) (int, *Error) {
+	switch bound := bound.(type) {
+	case *Nil:
+		return omitted, nil
+	case *Integer:
+		i := bound.Value
+		if i < 0 {
+			i += int64(length)
+		}
+		if i < 0 {
+			return 0, nil
+		}
+		if i > int64(length) {
+			return length, nil
+		}
+		return int(i), nil
+	default:
+		return 0, NewError(TypeError, "slice index must be an integer, got %s", bound.Type())
+	}
+}
@@ -0,0 +1,86 @@
+package object
+
+import (
+	"reflect"
+	"unicode/utf8"
+)
+
+// Iterator iterates over the elements of an array, the characters of a string or the pairs of
+// a hash. It reads the iterated object in place rather than copying it.
+type Iterator struct {
+	next func() (key, value Object, ok bool)
+	// keys reports whether a loop with one variable is bound to keys rather than values
+	keys bool
+}
+
+// NewIterator returns a new Iterator over `obj`, or false if `obj` is not iterable.
+//
+// The keys of arrays and strings are the indices of their elements and characters. Strings are
+// iterated over by character, each of which is a string. Hashes are iterated over in no
+// particular order.
+func NewIterator(obj Object) (*Iterator, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		i := 0
+		return &Iterator{next: func() (Object, Object, bool) {
+			if i >= len(obj.Elements) {
+				return nil, nil, false
+			}
+			i++
+			return &Integer{Value: int64(i - 1)}, obj.Elements[i-1], true
+		}}, true
+
+	case *String:
+		i, offset := 0, 0
+		return &Iterator{next: func() (Object, Object, bool) {
+			if offset >= len(obj.Value) {
+				return nil, nil, false
+			}
+			_, width := utf8.DecodeRuneInString(obj.Value[offset:])
+			ch := &String{Value: obj.Value[offset : offset+width]}
+			i++
+			offset += width
+			return &Integer{Value: int64(i - 1)}, ch, true
+		}}, true
+
+	case *Hash:
+		iter := reflect.ValueOf(obj.Pairs).MapRange()
+		return &Iterator{keys: true, next: func() (Object, Object, bool) {
+			if !iter.Next() {
+				return nil, nil, false
+			}
+			pair := iter.Value().Interface().(HashPair)
+			return pair.Key, pair.Value, true
+		}}, true
+
+	default:
+		return nil, false
+	}
+}
+
+// Type returns the type of the Iterator.
+func (it *Iterator) Type() Type {
+	return IteratorType
+}
+
+// Inspect returns a string representation of the Iterator.
+func (it *Iterator) Inspect() string {
+	return "iterator"
+}
+
+// Next advances the iterator and returns the key and the value of the next element. It
+// reports false if there are no more elements.
+func (it *Iterator) Next() (key, value Object, ok bool) {
+	return it.next()
+}
+
+// NextElement advances the iterator and returns what a loop with one variable is bound to:
+// the next key of a hash, or the next value of an array or a string. It reports false if there
+// are no more elements.
+func (it *Iterator) NextElement() (Object, bool) {
+	key, value, ok := it.next()
+	if it.keys {
+		return key, ok
+	}
+	return value, ok
+}
@@ -45,6 +45,14 @@ const (
 	CompiledFunctionType = "CompiledFunction"
 	// ClosureType represents a type of closures.
 	ClosureType = "Closure"
+	// IteratorType represents a type of iterators used by for-in loops.
+	IteratorType = "Iterator"
+	// BoundMethodType represents a type of methods bound to their receivers.
+	BoundMethodType = "BoundMethod"
+	// ModuleType represents a type of imported modules.
+	ModuleType = "Module"
+	// CellType represents a type of cells of captured variables.
+	CellType = "Cell"
 )
 
 // Object represents an object of Monkey language.
@@ -171,9 +179,77 @@ func (rv *ReturnValue) Inspect() string {
 	return rv.Value.Inspect()
 }
 
-// Error represents an error.
+// Kinds of errors, which tell apart the errors a program catches.
+const (
+	// GenericError is the kind of errors which are not of any other kind, and of values thrown
+	// by programs.
+	GenericError = "Error"
+	// TypeError is the kind of errors caused by values of types an operation does not support.
+	TypeError = "TypeError"
+	// IndexError is the kind of errors caused by indices out of range and missing members.
+	IndexError = "IndexError"
+	// ArgumentError is the kind of errors caused by calls with a wrong number of arguments.
+	ArgumentError = "ArgumentError"
+	// ArithmeticError is the kind of errors caused by division by zero and the like.
+	ArithmeticError = "ArithmeticError"
+	// NameError is the kind of errors caused by identifiers which are not defined.
+	NameError = "NameError"
+)
+
+// Error represents an error, which is raised by a failing operation or by a `throw` statement.
 type Error struct {
+	// Kind is the kind of the error, e.g. TypeError.
+	Kind    string
 	Message string
+	// Value is the value thrown by a `throw` statement, or nil if an operation failed.
+	Value Object
+}
+
+// NewError returns an error of `kind` with a formatted message.
+func NewError(kind, format string, a ...interface{}) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
+
+// NewThrownError returns the error raised by throwing `value`. A thrown hash with a "message"
+// and a "type", such as a caught error thrown again, gives the error its message and kind.
+func NewThrownError(value Object) *Error {
+	err := &Error{Kind: GenericError, Message: value.Inspect(), Value: value}
+
+	if hash, ok := value.(*Hash); ok {
+		if msg, ok := hash.Pairs[(&String{Value: "message"}).HashKey()].Value.(*String); ok {
+			err.Message = msg.Value
+		}
+		if kind, ok := hash.Pairs[(&String{Value: "type"}).HashKey()].Value.(*String); ok {
+			err.Kind = kind.Value
+		}
+	}
+
+	return err
+}
+
+// Caught returns the value a `catch` clause binds for the error: the thrown value, or a hash of
+// the "type" and the "message" of the error if an operation failed.
+func (e *Error) Caught() Object {
+	if e.Value != nil {
+		return e.Value
+	}
+
+	kind := e.Kind
+	if kind == "" {
+		kind = GenericError
+	}
+
+	pairs := make(map[HashKey]HashPair)
+	for _, pair := range [][2]string{{"type", kind}, {"message", e.Message}} {
+		key := &String{Value: pair[0]}
+		pairs[key.HashKey()] = HashPair{Key: key, Value: &String{Value: pair[1]}}
+	}
+	return &Hash{Pairs: pairs}
+}
+
+// Error returns the message of the error, so that the VM can return it as a Go error.
+func (e *Error) Error() string {
+	return e.Message
 }
 
 // Type returns the type of the Error.
@@ -188,7 +264,9 @@ func (e *Error) Inspect() string {
 
 // Function represents a function.
 type Function struct {
-	Parameters []*ast.Ident
+	Parameters []ast.Pattern
+	Defaults   []ast.Expression
+	Rest       *ast.Ident
 	Body       *ast.BlockStatement
 	Env        Environment
 }
@@ -202,13 +280,8 @@ func (f *Function) Type() Type {
 func (f *Function) Inspect() string {
 	var out bytes.Buffer
 
-	params := make([]string, 0, len(f.Parameters))
-	for _, p := range f.Parameters {
-		params = append(params, p.String())
-	}
-
 	out.WriteString("fn(")
-	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(ast.ParametersString(f.Parameters, f.Defaults, f.Rest))
 	out.WriteString(") {\n")
 	out.WriteString(f.Body.String())
 	out.WriteString("\n}")
@@ -263,6 +336,8 @@ func (b *Builtin) Inspect() string {
 // Array represents an array.
 type Array struct {
 	Elements []Object
+	// Frozen reports whether the elements cannot be set, see the `freeze` built-in function.
+	Frozen bool
 }
 
 // Type returns the type of the Array.
@@ -297,6 +372,8 @@ type HashPair struct {
 // Hash represents a hash.
 type Hash struct {
 	Pairs map[HashKey]HashPair
+	// Frozen reports whether the pairs cannot be set, see the `freeze` built-in function.
+	Frozen bool
 }
 
 // Type returns the type of the Hash.
@@ -371,8 +448,18 @@ func (m *Macro) Inspect() string {
 type CompiledFunction struct {
 	Instructions code.Instructions
 	// NumLocals is used for reserving slots to store local bindings on the stack
-	NumLocals     int
+	NumLocals int
+	// NumParameters is the number of parameters except the rest parameter. It is the maximum
+	// number of arguments unless the function is variadic.
 	NumParameters int
+	// MinArity is the minimum number of arguments, i.e. the number of parameters without
+	// default values.
+	MinArity int
+	// Variadic reports whether the function has a rest parameter, which takes the arguments
+	// after the first NumParameters ones as an array.
+	Variadic bool
+	// SourceMap maps instructions to the positions in the source they were compiled from
+	SourceMap code.SourceMap
 }
 
 // Type returns the type of `cf`.
@@ -386,7 +473,7 @@ func (cf *CompiledFunction) Inspect() string {
 }
 
 // Closure represents a closure. It has a pointer to the function it wraps, `Fn`, and a place
-// to keep the free variables it carries around, `Free`.
+// to keep the cells of the free variables it carries around, `Free`.
 type Closure struct {
 	Fn   *CompiledFunction
 	Free []Object
@@ -401,3 +488,58 @@ func (c *Closure) Type() Type {
 func (c *Closure) Inspect() string {
 	return fmt.Sprintf("Closure[%p]", c)
 }
+
+// Cell holds the value of a local variable captured by closures. The function defining the
+// variable and the closures share the cell, so that they see the assignments of each other.
+// Cells are never exposed to programs as values.
+type Cell struct {
+	Value Object
+}
+
+// Type returns the type of `c`.
+func (c *Cell) Type() Type {
+	return CellType
+}
+
+// Inspect returns a string representation of `c`.
+func (c *Cell) Inspect() string {
+	return fmt.Sprintf("%s(%s)", CellType, c.Value.Inspect())
+}
+
+// BoundMethod represents a function taken from a hash for a method call, e.g.
+// `obj.method(args)`. It is called with the hash, `Receiver`, as the first argument.
+type BoundMethod struct {
+	Receiver Object
+	Method   Object
+}
+
+// Type returns the type of `bm`.
+func (bm *BoundMethod) Type() Type {
+	return BoundMethodType
+}
+
+// Inspect returns a string representation of `bm`.
+func (bm *BoundMethod) Inspect() string {
+	return bm.Method.Inspect()
+}
+
+// Module represents a module imported from a file.
+type Module struct {
+	Name string
+	// Exports holds the values the module exports, i.e. its macros when macros are expanded.
+	Exports map[string]Object
+	// Globals holds the indices in the globals store of the bindings the module exports, which
+	// are read by the vm when the members of the module are accessed, so that they see the
+	// current values of the bindings.
+	Globals map[string]int
+}
+
+// Type returns the type of `m`.
+func (m *Module) Type() Type {
+	return ModuleType
+}
+
+// Inspect returns a string representation of `m`.
+func (m *Module) Inspect() string {
+	return fmt.Sprintf("%s(%s)", ModuleType, m.Name)
+}
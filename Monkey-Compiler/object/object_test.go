@@ -76,3 +76,176 @@ func TestNilHashKey(t *testing.T) {
 		t.Errorf("nils have different hash keys: %#v != %#v", n1.HashKey(), n2.HashKey())
 	}
 }
+
+func TestIterator(t *testing.T) {
+	tests := []struct {
+		obj      Object
+		wantKeys []string
+		wantVals []string
+		wantElem []string
+	}{
+		{
+			obj:      &Array{Elements: []Object{&Integer{Value: 5}, &String{Value: "a"}}},
+			wantKeys: []string{"0", "1"},
+			wantVals: []string{"5", "a"},
+			wantElem: []string{"5", "a"},
+		},
+		{
+			obj:      &String{Value: "añ😀"},
+			wantKeys: []string{"0", "1", "2"},
+			wantVals: []string{"a", "ñ", "😀"},
+			wantElem: []string{"a", "ñ", "😀"},
+		},
+		{
+			obj: &Hash{Pairs: map[HashKey]HashPair{
+				(&String{Value: "k"}).HashKey(): {Key: &String{Value: "k"}, Value: &Integer{Value: 1}},
+			}},
+			wantKeys: []string{"k"},
+			wantVals: []string{"1"},
+			wantElem: []string{"k"},
+		},
+		{
+			obj: &Array{},
+		},
+	}
+
+	for _, tt := range tests {
+		iter, ok := NewIterator(tt.obj)
+		if !ok {
+			t.Fatalf("%s is not iterable", tt.obj.Inspect())
+		}
+
+		var keys, vals []string
+		for key, val, ok := iter.Next(); ok; key, val, ok = iter.Next() {
+			keys = append(keys, key.Inspect())
+			vals = append(vals, val.Inspect())
+		}
+		if !equalStrings(keys, tt.wantKeys) || !equalStrings(vals, tt.wantVals) {
+			t.Errorf("%s - wrong pairs. want=%q %q, got=%q %q",
+				tt.obj.Inspect(), tt.wantKeys, tt.wantVals, keys, vals)
+		}
+
+		iter, _ = NewIterator(tt.obj)
+
+		var elems []string
+		for elem, ok := iter.NextElement(); ok; elem, ok = iter.NextElement() {
+			elems = append(elems, elem.Inspect())
+		}
+		if !equalStrings(elems, tt.wantElem) {
+			t.Errorf("%s - wrong elements. want=%q, got=%q", tt.obj.Inspect(), tt.wantElem, elems)
+		}
+	}
+
+	if _, ok := NewIterator(&Integer{Value: 1}); ok {
+		t.Errorf("integers should not be iterable")
+	}
+}
+
+func TestIteratorSeesArrayUpdates(t *testing.T) {
+	arr := &Array{Elements: []Object{&Integer{Value: 1}, &Integer{Value: 2}}}
+	iter, _ := NewIterator(arr)
+
+	iter.NextElement()
+	arr.Elements[1] = &Integer{Value: 20}
+
+	elem, ok := iter.NextElement()
+	if !ok || elem.Inspect() != "20" {
+		t.Errorf("iterator does not read the array in place. got=%v (%t)", elem, ok)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSlice(t *testing.T) {
+	arr := &Array{Elements: []Object{
+		&Integer{Value: 1}, &Integer{Value: 2}, &Integer{Value: 3},
+	}}
+	str := &String{Value: "héllo"}
+	null := &Nil{}
+	integer := func(i int64) Object { return &Integer{Value: i} }
+
+	tests := []struct {
+		obj        Object
+		start, end Object
+		want       string
+	}{
+		{arr, integer(1), integer(2), "[2]"},
+		{arr, null, integer(-1), "[1, 2]"},
+		{arr, integer(-2), null, "[2, 3]"},
+		{arr, integer(-5), integer(5), "[1, 2, 3]"},
+		{arr, integer(2), integer(1), "[]"},
+		{str, integer(1), integer(3), "él"},
+		{str, integer(-3), null, "llo"},
+	}
+
+	for _, tt := range tests {
+		got, err := Slice(tt.obj, tt.start, tt.end)
+		if err != nil {
+			t.Fatalf("Slice(%s, %s, %s) returned error: %s",
+				tt.obj.Inspect(), tt.start.Inspect(), tt.end.Inspect(), err)
+		}
+		if got.Inspect() != tt.want {
+			t.Errorf("Slice(%s, %s, %s) = %s, want %s",
+				tt.obj.Inspect(), tt.start.Inspect(), tt.end.Inspect(), got.Inspect(), tt.want)
+		}
+	}
+
+	if _, err := Slice(&Hash{}, null, null); err == nil {
+		t.Errorf("Slice of a hash returned no error")
+	}
+	if _, err := Slice(arr, &String{Value: "a"}, null); err == nil {
+		t.Errorf("Slice with a string index returned no error")
+	}
+}
+
+func TestFloorDivAndMod(t *testing.T) {
+	tests := []struct {
+		x, y     int64
+		div, mod int64
+	}{
+		{7, 2, 3, 1},
+		{-7, 2, -4, 1},
+		{7, -2, -4, -1},
+		{-7, -2, 3, -1},
+		{6, -3, -2, 0},
+	}
+
+	for _, tt := range tests {
+		if got := FloorDiv(tt.x, tt.y); got != tt.div {
+			t.Errorf("FloorDiv(%d, %d) = %d, want %d", tt.x, tt.y, got, tt.div)
+		}
+		if got := FloorMod(tt.x, tt.y); got != tt.mod {
+			t.Errorf("FloorMod(%d, %d) = %d, want %d", tt.x, tt.y, got, tt.mod)
+		}
+		if got := FloatFloorMod(float64(tt.x), float64(tt.y)); got != float64(tt.mod) {
+			t.Errorf("FloatFloorMod(%d, %d) = %g, want %d", tt.x, tt.y, got, tt.mod)
+		}
+	}
+}
+
+func TestIntPow(t *testing.T) {
+	tests := []struct {
+		x, y, want int64
+	}{
+		{2, 0, 1},
+		{2, 10, 1024},
+		{-3, 3, -27},
+		{0, 0, 1},
+	}
+
+	for _, tt := range tests {
+		if got := IntPow(tt.x, tt.y); got != tt.want {
+			t.Errorf("IntPow(%d, %d) = %d, want %d", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
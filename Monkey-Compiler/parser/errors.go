@@ -0,0 +1,57 @@
+package parser
+
+import (
+	"strings"
+
+	"monkey-compiler/token"
+)
+
+// ParseError represents a syntax error found by the parser.
+type ParseError struct {
+	// Pos is the position of the offending token.
+	Pos token.Position
+	// Expected is the token type the parser expected, or empty if it expected no specific type.
+	Expected token.Type
+	// Actual is the offending token.
+	Actual token.Token
+	// Msg is the error message without the position.
+	Msg string
+}
+
+// Error returns the error message prefixed with the position of the error.
+func (e *ParseError) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Msg
+	}
+	return e.Pos.String() + ": " + e.Msg
+}
+
+// Caret returns `line`, the source line the error occurred on, followed by a line with a caret
+// under the column of the error. It returns an empty string if the position is not known.
+func (e *ParseError) Caret(line string) string {
+	if !e.Pos.IsValid() {
+		return ""
+	}
+
+	line = strings.TrimRight(line, "\r\n")
+
+	// Keep tabs so that the caret lines up with the source line
+	var indent strings.Builder
+	column := 1
+	for _, ch := range line {
+		if column == e.Pos.Column {
+			break
+		}
+		if ch == '\t' {
+			indent.WriteRune('\t')
+		} else {
+			indent.WriteRune(' ')
+		}
+		column++
+	}
+	for ; column < e.Pos.Column; column++ {
+		indent.WriteRune(' ')
+	}
+
+	return line + "\n" + indent.String() + "^\n"
+}
@@ -1,8 +1,11 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
 
 	"monkey-compiler/ast"
 	"monkey-compiler/lexer"
@@ -13,6 +16,8 @@ const (
 	_ int = iota
 	// LOWEST represents the lowest precedence.
 	LOWEST
+	// COALESCE represents precedence of nil-coalescing.
+	COALESCE // ??
 	// OR represents precedence of logical OR.
 	OR
 	// AND represents precedence of logical AND.
@@ -21,12 +26,25 @@ const (
 	EQUALS // ==
 	// LESSGREATER represents precedence of less than or greater than.
 	LESSGREATER // > or <
+	// PIPE represents precedence of the pipeline operator.
+	PIPE // |>
+	// BITOR represents precedence of bitwise OR.
+	BITOR // |
+	// BITXOR represents precedence of bitwise XOR.
+	BITXOR // ^
+	// BITAND represents precedence of bitwise AND.
+	BITAND // &
+	// SHIFT represents precedence of bit shifts.
+	SHIFT // << or >>
 	// SUM represents precedence of sum.
 	SUM // +
 	// PRODUCT represents precedence of product.
 	PRODUCT // *
 	// PREFIX represents precedence of prefix operator.
 	PREFIX // -X or !X
+	// POWER represents precedence of exponentiation. It is higher than PREFIX, so `-2 ** 2`
+	// is `-(2 ** 2)`.
+	POWER // **
 	// CALL represents precedence of function call.
 	CALL // myFunc(X)
 	// INDEX represents precedence of array index operator.
@@ -34,20 +52,34 @@ const (
 )
 
 var precedences = map[token.Type]int{
-	token.OR:       OR,
-	token.AND:      AND,
-	token.EQ:       EQUALS,
-	token.NEQ:      EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.LE:       LESSGREATER,
-	token.GE:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTARISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.COALESCE:  COALESCE,
+	token.OR:        OR,
+	token.AND:       AND,
+	token.EQ:        EQUALS,
+	token.NEQ:       EQUALS,
+	token.LT:        LESSGREATER,
+	token.GT:        LESSGREATER,
+	token.LE:        LESSGREATER,
+	token.GE:        LESSGREATER,
+	token.PIPE:      PIPE,
+	token.BIT_OR:    BITOR,
+	token.BIT_XOR:   BITXOR,
+	token.BIT_AND:   BITAND,
+	token.SHL:       SHIFT,
+	token.SHR:       SHIFT,
+	token.PLUS:      SUM,
+	token.MINUS:     SUM,
+	token.SLASH:     PRODUCT,
+	token.FLOOR_DIV: PRODUCT,
+	token.PERCENT:   PRODUCT,
+	token.ASTARISK:  PRODUCT,
+	token.POWER:     POWER,
+	token.LPAREN:    CALL,
+	token.LBRACKET:  INDEX,
+	token.DOT:       INDEX,
+
+	token.OPTIONAL_LBRACKET: INDEX,
+	token.OPTIONAL_DOT:      INDEX,
 }
 
 type (
@@ -58,11 +90,26 @@ type (
 // Parser is a parser of Monkey programming language.
 type Parser struct {
 	l      lexer.Lexer
-	errors []string
+	errors []*ParseError
+	// panicking is set after an error until the parser synchronizes at the end of the
+	// statement, so that one mistake is not reported many times
+	panicking bool
+
+	// loopDepth is the number of loops enclosing the current token in the current function
+	loopDepth int
+	// blockDepth is the number of blocks enclosing the current token
+	blockDepth int
+	// braces is the number of `{` before the current token which are not closed yet. A stray
+	// `}` does not make it negative.
+	braces int
 
 	curToken  token.Token
 	peekToken token.Token
 
+	// doc comments directly before curToken and peekToken
+	curDoc  string
+	peekDoc string
+
 	prefixParseFns map[token.Type]prefixParseFn
 	infixParseFns  map[token.Type]infixParseFn
 }
@@ -71,42 +118,61 @@ type Parser struct {
 func New(l lexer.Lexer) *Parser {
 	p := &Parser{
 		l:      l,
-		errors: []string{},
+		errors: []*ParseError{},
 	}
 
 	p.prefixParseFns = map[token.Type]prefixParseFn{
-		token.IDENT:    p.parseIdent,
-		token.INT:      p.parseIntegerLiteral,
-		token.FLOAT:    p.parseFloatLiteral,
-		token.BANG:     p.parsePrefixExpression,
-		token.MINUS:    p.parsePrefixExpression,
-		token.TRUE:     p.parseBoolean,
-		token.FALSE:    p.parseBoolean,
-		token.NIL:      p.parseNil,
-		token.LPAREN:   p.parseGroupedExpression,
-		token.IF:       p.parseIfExpression,
-		token.FUNCTION: p.parseFunctionLiteral,
-		token.STRING:   p.parseStringLiteral,
-		token.LBRACKET: p.parseArrayLiteral,
-		token.LBRACE:   p.parseHashLiteral,
-		token.MACRO:    p.parseMacroLiteral,
+		token.ILLEGAL:     p.parseIllegal,
+		token.IDENT:       p.parseIdent,
+		token.INT:         p.parseIntegerLiteral,
+		token.FLOAT:       p.parseFloatLiteral,
+		token.BANG:        p.parsePrefixExpression,
+		token.MINUS:       p.parsePrefixExpression,
+		token.BIT_NOT:     p.parsePrefixExpression,
+		token.TRUE:        p.parseBoolean,
+		token.FALSE:       p.parseBoolean,
+		token.NIL:         p.parseNil,
+		token.LPAREN:      p.parseGroupedExpression,
+		token.IF:          p.parseIfExpression,
+		token.FUNCTION:    p.parseFunctionLiteral,
+		token.STRING:      p.parseStringLiteral,
+		token.STRING_HEAD: p.parseInterpolatedString,
+		token.LBRACKET:    p.parseArrayLiteral,
+		token.LBRACE:      p.parseHashLiteral,
+		token.MACRO:       p.parseMacroLiteral,
+		token.BIT_OR:      p.parseLambda,
+		token.OR:          p.parseLambda,
 	}
 
 	p.infixParseFns = map[token.Type]infixParseFn{
-		token.PLUS:     p.parseInfixExpression,
-		token.MINUS:    p.parseInfixExpression,
-		token.ASTARISK: p.parseInfixExpression,
-		token.SLASH:    p.parseInfixExpression,
-		token.EQ:       p.parseInfixExpression,
-		token.NEQ:      p.parseInfixExpression,
-		token.LT:       p.parseInfixExpression,
-		token.GT:       p.parseInfixExpression,
-		token.LE:       p.parseInfixExpression,
-		token.GE:       p.parseInfixExpression,
-		token.AND:      p.parseInfixExpression,
-		token.OR:       p.parseInfixExpression,
-		token.LPAREN:   p.parseCallExpression,
-		token.LBRACKET: p.parseIndexExpression,
+		token.PLUS:      p.parseInfixExpression,
+		token.MINUS:     p.parseInfixExpression,
+		token.ASTARISK:  p.parseInfixExpression,
+		token.SLASH:     p.parseInfixExpression,
+		token.FLOOR_DIV: p.parseInfixExpression,
+		token.PERCENT:   p.parseInfixExpression,
+		token.POWER:     p.parseInfixExpression,
+		token.BIT_AND:   p.parseInfixExpression,
+		token.BIT_OR:    p.parseInfixExpression,
+		token.BIT_XOR:   p.parseInfixExpression,
+		token.SHL:       p.parseInfixExpression,
+		token.SHR:       p.parseInfixExpression,
+		token.EQ:        p.parseInfixExpression,
+		token.NEQ:       p.parseInfixExpression,
+		token.LT:        p.parseInfixExpression,
+		token.GT:        p.parseInfixExpression,
+		token.LE:        p.parseInfixExpression,
+		token.GE:        p.parseInfixExpression,
+		token.AND:       p.parseInfixExpression,
+		token.OR:        p.parseInfixExpression,
+		token.COALESCE:  p.parseInfixExpression,
+		token.PIPE:      p.parsePipeExpression,
+		token.LPAREN:    p.parseCallExpression,
+		token.LBRACKET:  p.parseIndexExpression,
+		token.DOT:       p.parseMemberExpression,
+
+		token.OPTIONAL_LBRACKET: p.parseIndexExpression,
+		token.OPTIONAL_DOT:      p.parseMemberExpression,
 	}
 
 	// Read two tokens, so curToken and peekToken are both set
@@ -117,18 +183,102 @@ func New(l lexer.Lexer) *Parser {
 }
 
 func (p *Parser) nextToken() {
+	switch p.curToken.Type {
+	case token.LBRACE:
+		p.braces++
+	case token.RBRACE:
+		if p.braces > 0 {
+			p.braces--
+		}
+	}
+
 	p.curToken = p.peekToken
-	p.peekToken = p.l.NextToken()
+	p.curDoc = p.peekDoc
+	p.peekToken, p.peekDoc = p.readToken()
 }
 
-// Errors returns error messages.
-func (p *Parser) Errors() []string {
+// readToken reads the next token from the lexer, skipping doc comments. The doc comment
+// is returned along with the token if its last line directly precedes the token.
+// Consecutive comment lines are joined into one doc comment.
+func (p *Parser) readToken() (token.Token, string) {
+	var lines []string
+	var lastLine int
+
+	tok := p.l.NextToken()
+	for tok.Type == token.DOC_COMMENT {
+		if len(lines) > 0 && tok.Pos.Line != lastLine+1 {
+			lines = nil
+		}
+		lines = append(lines, tok.Literal)
+		lastLine = tok.Pos.Line
+
+		tok = p.l.NextToken()
+	}
+
+	if len(lines) == 0 || tok.Pos.Line != lastLine+1 {
+		return tok, ""
+	}
+	return tok, strings.Join(lines, "\n")
+}
+
+// Errors returns parse errors.
+func (p *Parser) Errors() []*ParseError {
 	return p.errors
 }
 
+// addError records a parse error unless an error was already recorded in the current statement.
+func (p *Parser) addError(err *ParseError) {
+	if p.panicking {
+		return
+	}
+	p.errors = append(p.errors, err)
+	p.panicking = true
+}
+
+// errorf records a parse error at the offending token `tok`.
+func (p *Parser) errorf(tok token.Token, format string, a ...interface{}) {
+	p.addError(&ParseError{Pos: tok.Pos, Actual: tok, Msg: fmt.Sprintf(format, a...)})
+}
+
 func (p *Parser) peekError(typ token.Type) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", typ, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.addError(&ParseError{
+		Pos:      p.peekToken.Pos,
+		Expected: typ,
+		Actual:   p.peekToken,
+		Msg:      fmt.Sprintf("expected next token to be %s, got %s instead", typ, p.peekToken.Type),
+	})
+}
+
+// synchronize skips the rest of a statement in which an error occurred, so that parsing
+// resumes at the next statement. `start` is the number of unclosed braces before the
+// statement. A statement ends at a `;` or an unmatched `}` outside the braces opened in it, or
+// before the `}` closing the enclosing block if `inBlock` is true.
+func (p *Parser) synchronize(start int, inBlock bool) {
+	p.panicking = false
+
+	for !p.curTokenIs(token.EOF) {
+		// The error may occur inside braces opened in the statement, e.g. in a hash literal
+		depth := p.braces - start
+		switch p.curToken.Type {
+		case token.SEMICOLON:
+			if depth == 0 {
+				return
+			}
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth == 0 {
+				return
+			}
+			depth--
+		}
+
+		if inBlock && depth == 0 && p.peekTokenIs(token.RBRACE) {
+			return
+		}
+
+		p.nextToken()
+	}
 }
 
 func (p *Parser) curTokenIs(typ token.Type) bool {
@@ -156,8 +306,11 @@ func (p *Parser) ParseProgram() *ast.Program {
 	}
 
 	for !p.curTokenIs(token.EOF) {
+		start := p.braces
 		stmt := p.parseStatement()
-		if stmt != nil {
+		if p.panicking {
+			p.synchronize(start, false)
+		} else if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
 		p.nextToken()
@@ -168,28 +321,116 @@ func (p *Parser) ParseProgram() *ast.Program {
 
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
-	case token.LET:
+	case token.LET, token.CONST:
 		return p.parseLetStatement()
 	case token.IDENT, token.INT, token.FLOAT, token.STRING, token.FUNCTION, token.LPAREN,
-		token.LBRACKET, token.MINUS, token.BANG:
+		token.LBRACKET, token.LBRACE, token.MINUS, token.BANG:
 		return p.parseSimpleStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK:
+		return p.parseBreakStatement()
+	case token.CONTINUE:
+		return p.parseContinueStatement()
+	case token.IMPORT:
+		return p.parseImportStatement()
+	case token.EXPORT:
+		return p.parseExportStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
+	case token.TRY:
+		return p.parseTryStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
 }
 
 func (p *Parser) parseLetStatement() *ast.LetStatement {
-	stmt := &ast.LetStatement{Token: p.curToken}
+	stmt := p.parseLet()
+	p.skipSemicolons()
+	return stmt
+}
+
+// parseImportStatement parses `import "path" as name`, which is only allowed at the top level.
+func (p *Parser) parseImportStatement() *ast.ImportStatement {
+	stmt := &ast.ImportStatement{Token: p.curToken}
+
+	if p.blockDepth > 0 {
+		p.errorf(p.curToken, "import is only allowed at the top level")
+		return nil
+	}
+
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	stmt.Path = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+
+	// `as` is not a keyword, so it can still be used as an identifier elsewhere
+	if !p.peekTokenIs(token.IDENT) || p.peekToken.Literal != "as" {
+		p.errorf(p.peekToken, "expected as after the path of an import, got %s instead",
+			p.peekToken.Type)
+		return nil
+	}
+	p.nextToken()
 
 	if !p.expectPeek(token.IDENT) {
 		return nil
 	}
+	stmt.Name = &ast.Ident{Token: p.curToken, Value: p.curToken.Literal}
 
-	stmt.Name = &ast.Ident{
-		Token: p.curToken,
-		Value: p.curToken.Literal,
+	p.skipSemicolons()
+
+	return stmt
+}
+
+// parseExportStatement parses `export let ...` or `export const ...`, which is only allowed at the top level.
+func (p *Parser) parseExportStatement() *ast.LetStatement {
+	tok, doc := p.curToken, p.curDoc
+
+	if p.blockDepth > 0 {
+		p.errorf(tok, "export is only allowed at the top level")
+		return nil
+	}
+
+	if p.peekTokenIs(token.CONST) {
+		p.nextToken()
+	} else if !p.expectPeek(token.LET) {
+		return nil
+	}
+
+	stmt := p.parseLetStatement()
+	if stmt == nil {
+		return nil
+	}
+	stmt.Exported = true
+	stmt.Doc = doc
+
+	return stmt
+}
+
+// parseLet parses a let statement without the semicolons following it.
+func (p *Parser) parseLet() *ast.LetStatement {
+	stmt := &ast.LetStatement{Token: p.curToken, Doc: p.curDoc}
+
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+
+		if stmt.Pattern = p.parsePattern(); stmt.Pattern == nil {
+			return nil
+		}
+	} else {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+
+		stmt.Name = &ast.Ident{
+			Token: p.curToken,
+			Value: p.curToken.Literal,
+		}
 	}
 
 	if !p.expectPeek(token.ASSIGN) {
@@ -200,25 +441,157 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Value = p.parseExpression(LOWEST)
 
-	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok && stmt.Name != nil {
 		fl.Name = stmt.Name.Value
 	}
 
-	for p.peekTokenIs(token.SEMICOLON) {
+	return stmt
+}
+
+// parsePattern parses a binding target: an identifier, an array pattern or a hash pattern.
+func (p *Parser) parsePattern() ast.Pattern {
+	switch p.curToken.Type {
+	case token.IDENT:
+		return &ast.Ident{Token: p.curToken, Value: p.curToken.Literal}
+	case token.LBRACKET:
+		return p.parseArrayPattern(&ast.ArrayPattern{Token: p.curToken})
+	case token.LBRACE:
+		return p.parseHashPattern(&ast.HashPattern{Token: p.curToken})
+	default:
+		p.errorf(p.curToken, "expected an identifier or a pattern, got %s instead", p.curToken.Type)
+		return nil
+	}
+}
+
+// parseArrayPattern parses the elements of an array pattern after the ones already in
+// `pattern`, up to the closing bracket.
+func (p *Parser) parseArrayPattern(pattern *ast.ArrayPattern) ast.Pattern {
+	for !p.peekTokenIs(token.RBRACKET) {
 		p.nextToken()
+
+		if p.curTokenIs(token.ELLIPSIS) {
+			return p.parseArrayPatternRest(pattern)
+		}
+
+		elem := p.parsePattern()
+		if elem == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, elem)
+
+		if !p.peekTokenIs(token.RBRACKET) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return pattern
+}
+
+// parseArrayPatternRest parses the rest element `...name` of an array pattern, which must be
+// the last element, and the closing bracket.
+func (p *Parser) parseArrayPatternRest(pattern *ast.ArrayPattern) ast.Pattern {
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	pattern.Rest = &ast.Ident{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return pattern
+}
+
+// parseHashPattern parses the keys of a hash pattern after the ones already in `pattern`, up
+// to the closing brace.
+func (p *Parser) parseHashPattern(pattern *ast.HashPattern) ast.Pattern {
+	for !p.peekTokenIs(token.RBRACE) {
+		if len(pattern.Keys) > 0 && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		pattern.Keys = append(pattern.Keys, &ast.Ident{Token: p.curToken, Value: p.curToken.Literal})
 	}
 
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	return pattern
+}
+
+// toPattern converts an array literal on the left side of an assignment, which has been parsed
+// as an expression, to an array pattern. Its elements must be identifiers or patterns, and a
+// spread identifier at the end becomes the rest element.
+func (p *Parser) toPattern(expr ast.Expression) ast.Pattern {
+	switch expr := expr.(type) {
+	case ast.Pattern:
+		return expr
+	case *ast.ArrayLiteral:
+		pattern := &ast.ArrayPattern{Token: expr.Token}
+		for i, elem := range expr.Elements {
+			if spread, ok := elem.(*ast.Spread); ok {
+				rest, ok := spread.Value.(*ast.Ident)
+				if !ok || i != len(expr.Elements)-1 {
+					p.addError(&ParseError{
+						Pos: spread.Pos(),
+						Msg: fmt.Sprintf("rest element %s must be the last element and an identifier", spread),
+					})
+					return nil
+				}
+				pattern.Rest = rest
+				break
+			}
+
+			elemPattern := p.toPattern(elem)
+			if elemPattern == nil {
+				return nil
+			}
+			pattern.Elements = append(pattern.Elements, elemPattern)
+		}
+		return pattern
+	default:
+		if expr != nil {
+			p.addError(&ParseError{
+				Pos: expr.Pos(),
+				Msg: fmt.Sprintf("expected an identifier or a pattern, got %s instead", expr),
+			})
+		}
+		return nil
+	}
+}
+
+func (p *Parser) parseSimpleStatement() ast.Statement {
+	stmt := p.parseSimple()
+	p.skipSemicolons()
 	return stmt
 }
 
-func (p *Parser) parseSimpleStatement() (stmt ast.Statement) {
+// parseSimple parses an assignment or expression statement without the semicolons
+// following it.
+func (p *Parser) parseSimple() (stmt ast.Statement) {
+	first := p.curToken
 	lhs := p.parseExpression(LOWEST)
 
 	switch p.peekToken.Type {
-	case token.ASSIGN:
+	case token.ASSIGN, token.PLUS_ASSIGN, token.MINUS_ASSIGN, token.ASTARISK_ASSIGN,
+		token.SLASH_ASSIGN, token.PERCENT_ASSIGN:
 		p.nextToken()
 
-		tok := p.curToken // Equal sign '='
+		tok := p.curToken // Assignment operator, e.g. '=' or '+='
+
+		// An array literal on the left side destructures the value, e.g. `[a, b] = [b, a]`
+		if _, ok := lhs.(*ast.ArrayLiteral); ok && tok.Type == token.ASSIGN {
+			if lhs = p.toPattern(lhs); lhs == nil {
+				return nil
+			}
+		}
 
 		p.nextToken()
 
@@ -233,13 +606,20 @@ func (p *Parser) parseSimpleStatement() (stmt ast.Statement) {
 
 		stmt = &ast.AssignStatement{Token: tok, LHS: lhs, RHS: rhs}
 
+	case token.INC, token.DEC:
+		p.nextToken()
+
+		stmt = &ast.AssignStatement{Token: p.curToken, LHS: lhs}
+
 	default:
-		// Expression
-		stmt = &ast.ExpressionStatement{Token: p.curToken, Expression: lhs}
-	}
+		// Patterns parsed in place of literals can only be assigned to
+		if _, ok := lhs.(*ast.HashPattern); ok {
+			p.peekError(token.ASSIGN)
+			return nil
+		}
 
-	for p.peekTokenIs(token.SEMICOLON) {
-		p.nextToken()
+		// Expression
+		stmt = &ast.ExpressionStatement{Token: first, Expression: lhs}
 	}
 
 	return stmt
@@ -254,19 +634,250 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 
 	stmt.ReturnValue = p.parseExpression(LOWEST)
 
-	for p.peekTokenIs(token.SEMICOLON) {
+	p.skipSemicolons()
+
+	return stmt
+}
+
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	p.skipSemicolons()
+
+	return stmt
+}
+
+// parseTryStatement parses `try { ... } catch (e) { ... } finally { ... }`. Either the catch or
+// the finally clause may be omitted, and so may the parameter of the catch clause.
+func (p *Parser) parseTryStatement() *ast.TryStatement {
+	stmt := &ast.TryStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
 		p.nextToken()
+
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken()
+
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			stmt.Param = &ast.Ident{Token: p.curToken, Value: p.curToken.Literal}
+
+			if !p.expectPeek(token.RPAREN) {
+				return nil
+			}
+		}
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+
+		stmt.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+
+		stmt.Finally = p.parseBlockStatement()
+	}
+
+	if stmt.Catch == nil && stmt.Finally == nil {
+		p.errorf(p.peekToken, "expected catch or finally after try, got %s instead", p.peekToken.Type)
+		return nil
 	}
 
+	p.skipSemicolons()
+
 	return stmt
 }
 
-func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
-	stmt := &ast.ExpressionStatement{
-		Token:      p.curToken,
-		Expression: p.parseExpression(LOWEST),
+func (p *Parser) parseWhileStatement() *ast.WhileStatement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	p.skipSemicolons()
+
+	return stmt
+}
+
+// parseForStatement parses a C-style for loop `for (init; condition; update) { ... }`, any of
+// whose three clauses may be omitted, or a for-in loop.
+func (p *Parser) parseForStatement() ast.Statement {
+	tok := p.curToken
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
 	}
 
+	p.nextToken()
+
+	if p.curTokenIs(token.IDENT) && (p.peekTokenIs(token.IN) || p.peekTokenIs(token.COMMA)) {
+		return p.parseForInStatement(tok)
+	}
+
+	stmt := &ast.ForStatement{Token: tok}
+
+	if !p.curTokenIs(token.SEMICOLON) {
+		stmt.Init = p.parseForClause()
+		if !p.expectPeek(token.SEMICOLON) {
+			return nil
+		}
+	}
+
+	p.nextToken()
+
+	if !p.curTokenIs(token.SEMICOLON) {
+		stmt.Condition = p.parseExpression(LOWEST)
+		if !p.expectPeek(token.SEMICOLON) {
+			return nil
+		}
+	}
+
+	p.nextToken()
+
+	if !p.curTokenIs(token.RPAREN) {
+		stmt.Update = p.parseForClause()
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	p.skipSemicolons()
+
+	return stmt
+}
+
+// parseForInStatement parses a for-in loop `for (value in iterable) { ... }` or
+// `for (key, value in iterable) { ... }` from the first loop variable.
+func (p *Parser) parseForInStatement(tok token.Token) *ast.ForInStatement {
+	stmt := &ast.ForInStatement{Token: tok}
+
+	stmt.Value = &ast.Ident{Token: p.curToken, Value: p.curToken.Literal}
+
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+
+		stmt.Key = stmt.Value
+		stmt.Value = &ast.Ident{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	p.skipSemicolons()
+
+	return stmt
+}
+
+// parseForClause parses the init or update clause of a for loop.
+func (p *Parser) parseForClause() ast.Statement {
+	if p.curTokenIs(token.LET) {
+		return p.parseLet()
+	}
+	return p.parseSimple()
+}
+
+// parseLoopBody parses the body of a loop, in which break and continue statements are allowed.
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+
+	return p.parseBlockStatement()
+}
+
+func (p *Parser) parseBreakStatement() *ast.BreakStatement {
+	stmt := &ast.BreakStatement{Token: p.curToken}
+
+	if p.loopDepth == 0 {
+		p.errorf(p.curToken, "break is not in a loop")
+		return nil
+	}
+
+	p.skipSemicolons()
+
+	return stmt
+}
+
+func (p *Parser) parseContinueStatement() *ast.ContinueStatement {
+	stmt := &ast.ContinueStatement{Token: p.curToken}
+
+	if p.loopDepth == 0 {
+		p.errorf(p.curToken, "continue is not in a loop")
+		return nil
+	}
+
+	p.skipSemicolons()
+
+	return stmt
+}
+
+func (p *Parser) skipSemicolons() {
+	for p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+}
+
+func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
+	// Take the token before parsing the expression advances the parser
+	stmt := &ast.ExpressionStatement{Token: p.curToken}
+	stmt.Expression = p.parseExpression(LOWEST)
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -277,8 +888,7 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 func (p *Parser) parseExpression(precedence int) ast.Expression {
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
-		msg := fmt.Sprintf("no prefix parse function for %s found", p.curToken.Type)
-		p.errors = append(p.errors, msg)
+		p.errorf(p.curToken, "expected an expression, got %s instead", p.curToken.Type)
 		return nil
 	}
 
@@ -298,7 +908,16 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	return expr
 }
 
+func (p *Parser) parseIllegal() ast.Expression {
+	p.errorf(p.curToken, "%s", p.curToken.Literal)
+	return nil
+}
+
 func (p *Parser) parseIdent() ast.Expression {
+	if p.peekTokenIs(token.ARROW) {
+		return p.parseArrowFunction()
+	}
+
 	return &ast.Ident{
 		Token: p.curToken,
 		Value: p.curToken.Literal,
@@ -309,22 +928,46 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	tok := p.curToken
 
 	val, err := strconv.ParseInt(tok.Literal, 0, 64)
-	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", tok.Literal)
-		p.errors = append(p.errors, msg)
+	if errors.Is(err, strconv.ErrRange) {
+		p.errorf(tok, "integer literal %s overflows int64 (max %d)", tok.Literal, math.MaxInt64)
+		return nil
+	} else if err != nil {
+		p.errorf(tok, "could not parse %q as integer", tok.Literal)
 		return nil
 	}
 
 	return &ast.IntegerLiteral{Token: tok, Value: val}
 }
 
+// parseMinInt64 parses the smallest int64 from the integer literal after the prefix operator
+// `tok` if it is a minus, and returns nil otherwise. The magnitude of the smallest int64 is out
+// of range on its own, so the minus is a part of the literal unless the literal is the operand
+// of an operator binding tighter than it, e.g. `-9223372036854775808 ** 2`.
+func (p *Parser) parseMinInt64(tok token.Token) *ast.IntegerLiteral {
+	if tok.Type != token.MINUS || !p.curTokenIs(token.INT) || p.peekPrecedence() > PREFIX {
+		return nil
+	}
+
+	val, err := strconv.ParseUint(p.curToken.Literal, 0, 64)
+	if err != nil || val != -math.MinInt64 {
+		return nil
+	}
+
+	return &ast.IntegerLiteral{
+		Token: token.Token{Type: token.INT, Literal: tok.Literal + p.curToken.Literal, Pos: tok.Pos},
+		Value: math.MinInt64,
+	}
+}
+
 func (p *Parser) parseFloatLiteral() ast.Expression {
 	tok := p.curToken
 
 	val, err := strconv.ParseFloat(tok.Literal, 64)
-	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as float", tok.Literal)
-		p.errors = append(p.errors, msg)
+	if errors.Is(err, strconv.ErrRange) {
+		p.errorf(tok, "float literal %s overflows float64", tok.Literal)
+		return nil
+	} else if err != nil {
+		p.errorf(tok, "could not parse %q as float", tok.Literal)
 		return nil
 	}
 
@@ -336,6 +979,10 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 
 	p.nextToken()
 
+	if lit := p.parseMinInt64(tok); lit != nil {
+		return lit
+	}
+
 	return &ast.PrefixExpression{
 		Token:    tok,
 		Operator: tok.Literal,
@@ -362,6 +1009,11 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	tok := p.curToken
 	prec := p.curPrecedence()
 
+	// Exponentiation is right-associative
+	if tok.Type == token.POWER {
+		prec--
+	}
+
 	p.nextToken()
 
 	return &ast.InfixExpression{
@@ -392,6 +1044,18 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 		return nil
 	}
 
+	// The parentheses end an optional chain inside them
+	switch expr := expr.(type) {
+	case *ast.IndexExpression:
+		expr.Grouped = true
+	case *ast.MemberExpression:
+		expr.Grouped = true
+	case *ast.SliceExpression:
+		expr.Grouped = true
+	case *ast.CallExpression:
+		expr.Grouped = true
+	}
+
 	return expr
 }
 
@@ -435,10 +1099,20 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		Statements: []ast.Statement{},
 	}
 
+	p.blockDepth++
+	defer func() { p.blockDepth-- }()
+
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		if stmt := p.parseStatement(); stmt != nil {
+		start := p.braces
+		stmt := p.parseStatement()
+		if p.panicking {
+			p.synchronize(start, true)
+			if p.curTokenIs(token.RBRACE) {
+				break
+			}
+		} else if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
 
@@ -455,48 +1129,157 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Parameters = p.parseFunctionParameters()
+	lit.Parameters, lit.Defaults, lit.Rest = p.parseFunctionParameters(token.RPAREN)
+	if lit.Parameters == nil {
+		return nil
+	}
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
 
-	lit.Body = p.parseBlockStatement()
+	lit.Body = p.parseFunctionBody()
 
 	return lit
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Ident {
-	idents := []*ast.Ident{}
+// parseLambda parses a short function literal, e.g. `|x, y| x + y`, or `|| x` without
+// parameters.
+func (p *Parser) parseLambda() ast.Expression {
+	lit := &ast.FunctionLiteral{Token: lambdaToken(p.curToken)}
+
+	if p.curTokenIs(token.OR) {
+		lit.Parameters = []ast.Pattern{}
+	} else {
+		lit.Parameters, lit.Defaults, lit.Rest = p.parseFunctionParameters(token.BIT_OR)
+		if lit.Parameters == nil {
+			return nil
+		}
+	}
+
+	lit.Body = p.parseLambdaBody()
+	if lit.Body == nil {
+		return nil
+	}
+
+	return lit
+}
+
+// parseArrowFunction parses a short function literal with a single parameter, e.g. `x => x * 2`.
+func (p *Parser) parseArrowFunction() ast.Expression {
+	param := &ast.Ident{Token: p.curToken, Value: p.curToken.Literal}
+	p.nextToken()
+
+	lit := &ast.FunctionLiteral{
+		Token:      lambdaToken(p.curToken),
+		Parameters: []ast.Pattern{param},
+	}
+
+	lit.Body = p.parseLambdaBody()
+	if lit.Body == nil {
+		return nil
+	}
+
+	return lit
+}
 
-	if p.peekTokenIs(token.RPAREN) {
+// parseLambdaBody parses the body of a short function literal. A body starting with `{` is a
+// block like the body of `fn`, and any other body is an expression whose value is returned.
+func (p *Parser) parseLambdaBody() *ast.BlockStatement {
+	if p.peekTokenIs(token.LBRACE) {
 		p.nextToken()
-		return idents
+		return p.parseFunctionBody()
 	}
 
+	outer := p.loopDepth
+	p.loopDepth = 0
+	defer func() { p.loopDepth = outer }()
+
 	p.nextToken()
+	stmt := &ast.ExpressionStatement{Token: p.curToken, Expression: p.parseExpression(LOWEST)}
+	if stmt.Expression == nil {
+		return nil
+	}
 
-	ident := &ast.Ident{
-		Token: p.curToken,
-		Value: p.curToken.Literal,
+	return &ast.BlockStatement{Token: stmt.Token, Statements: []ast.Statement{stmt}}
+}
+
+// lambdaToken returns the token of a short function literal starting at `tok`. It is the `fn`
+// keyword, so the literal is printed and compiled like any other function literal.
+func lambdaToken(tok token.Token) token.Token {
+	return token.Token{Type: token.FUNCTION, Literal: "fn", Pos: tok.Pos}
+}
+
+// parseFunctionBody parses the body of a function or a macro. Loops outside of the function
+// do not enclose its body.
+func (p *Parser) parseFunctionBody() *ast.BlockStatement {
+	outer := p.loopDepth
+	p.loopDepth = 0
+	defer func() { p.loopDepth = outer }()
+
+	return p.parseBlockStatement()
+}
+
+// parseFunctionParameters parses the parameters of a function or a macro up to the `end` token,
+// the default values of the last parameters, e.g. `y = 10`, and the rest parameter `...name` at
+// the end. It returns nil parameters if they are invalid.
+func (p *Parser) parseFunctionParameters(end token.Type) (
+	params []ast.Pattern, defaults []ast.Expression, rest *ast.Ident,
+) {
+	params = []ast.Pattern{}
+
+	// A default value between `|` cannot contain a bitwise OR which would end the parameters
+	defaultPrecedence := LOWEST
+	if end == token.BIT_OR {
+		defaultPrecedence = BITOR
 	}
-	idents = append(idents, ident)
 
-	for p.peekTokenIs(token.COMMA) {
+	if p.peekTokenIs(end) {
 		p.nextToken()
+		return params, nil, nil
+	}
+
+	for {
 		p.nextToken()
-		ident := &ast.Ident{
-			Token: p.curToken,
-			Value: p.curToken.Literal,
+
+		// The rest parameter must be the last one
+		if p.curTokenIs(token.ELLIPSIS) {
+			if !p.expectPeek(token.IDENT) {
+				return nil, nil, nil
+			}
+			rest = &ast.Ident{Token: p.curToken, Value: p.curToken.Literal}
+			break
 		}
-		idents = append(idents, ident)
+
+		param := p.parsePattern()
+		if param == nil {
+			return nil, nil, nil
+		}
+		params = append(params, param)
+
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken()
+			p.nextToken()
+			defaults = append(defaults, p.parseExpression(defaultPrecedence))
+		} else if len(defaults) > 0 {
+			p.addError(&ParseError{
+				Pos: param.Pos(),
+				Msg: fmt.Sprintf("non-default parameter %s follows a default parameter", param),
+			})
+			return nil, nil, nil
+		}
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
 	}
 
-	if !p.expectPeek(token.RPAREN) {
-		return nil
+	if !p.expectPeek(end) {
+		return nil, nil, nil
 	}
 
-	return idents
+	return params, defaults, rest
 }
 
 func (p *Parser) parseExpressionList(end token.Type) []ast.Expression {
@@ -508,12 +1291,12 @@ func (p *Parser) parseExpressionList(end token.Type) []ast.Expression {
 	}
 
 	p.nextToken()
-	list = append(list, p.parseExpression(LOWEST))
+	list = append(list, p.parseListElement())
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		list = append(list, p.parseExpression(LOWEST))
+		list = append(list, p.parseListElement())
 	}
 
 	if !p.expectPeek(end) {
@@ -523,12 +1306,46 @@ func (p *Parser) parseExpressionList(end token.Type) []ast.Expression {
 	return list
 }
 
+// parseListElement parses an element of an array literal or an argument of a call, which may
+// be spread, e.g. `...xs`.
+func (p *Parser) parseListElement() ast.Expression {
+	if !p.curTokenIs(token.ELLIPSIS) {
+		return p.parseExpression(LOWEST)
+	}
+
+	spread := &ast.Spread{Token: p.curToken}
+	p.nextToken()
+	spread.Value = p.parseExpression(LOWEST)
+
+	return spread
+}
+
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
-	return &ast.CallExpression{
-		Token:     p.curToken,
-		Function:  function,
-		Arguments: p.parseExpressionList(token.RPAREN),
+	expr := &ast.CallExpression{Token: p.curToken, Function: function}
+	expr.Arguments = p.parseExpressionList(token.RPAREN)
+	return expr
+}
+
+// parsePipeExpression lowers `left |> right` into a call of `right` with `left` as the argument.
+// If `right` is a call itself, `left` is prepended to its arguments, e.g. `xs |> map(f)` into
+// `map(xs, f)`.
+func (p *Parser) parsePipeExpression(left ast.Expression) ast.Expression {
+	tok := p.curToken
+	precedence := p.curPrecedence()
+	p.nextToken()
+
+	right := p.parseExpression(precedence)
+	if right == nil {
+		return nil
+	}
+
+	// A call in parentheses is the function to call, e.g. `x |> (f(y))` is `f(y)(x)`
+	if call, ok := right.(*ast.CallExpression); ok && !call.Grouped {
+		call.Arguments = append([]ast.Expression{left}, call.Arguments...)
+		return call
 	}
+
+	return &ast.CallExpression{Token: tok, Function: right, Arguments: []ast.Expression{left}}
 }
 
 func (p *Parser) parseStringLiteral() ast.Expression {
@@ -538,21 +1355,104 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	}
 }
 
-func (p *Parser) parseArrayLiteral() ast.Expression {
-	return &ast.ArrayLiteral{
-		Token:    p.curToken,
-		Elements: p.parseExpressionList(token.RBRACKET),
+// parseInterpolatedString lowers an interpolated string into a concatenation of its text and
+// its embedded expressions converted to strings, e.g. `"a ${x} b"` into `"a " + ${x} + " b"`.
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	parts := make([]ast.Expression, 0)
+
+	addText := func(tok token.Token) {
+		if tok.Literal != "" {
+			parts = append(parts, &ast.StringLiteral{Token: tok, Value: tok.Literal})
+		}
 	}
+
+	addText(p.curToken)
+
+	for {
+		if p.peekTokenIs(token.STRING_MIDDLE) || p.peekTokenIs(token.STRING_TAIL) {
+			p.errorf(p.peekToken, "empty expression in string interpolation")
+			return nil
+		}
+
+		p.nextToken()
+		tok := p.curToken
+		parts = append(parts, &ast.StringConversion{Token: tok, Value: p.parseExpression(LOWEST)})
+
+		if p.peekTokenIs(token.STRING_MIDDLE) {
+			p.nextToken()
+			addText(p.curToken)
+			continue
+		}
+
+		if !p.expectPeek(token.STRING_TAIL) {
+			return nil
+		}
+		addText(p.curToken)
+		break
+	}
+
+	expr := parts[0]
+	for _, part := range parts[1:] {
+		expr = &ast.InfixExpression{
+			Token:    token.Token{Type: token.PLUS, Literal: "+", Pos: part.Pos()},
+			Left:     expr,
+			Operator: "+",
+			Right:    part,
+		}
+	}
+
+	return expr
+}
+
+func (p *Parser) parseArrayLiteral() ast.Expression {
+	array := &ast.ArrayLiteral{Token: p.curToken}
+	array.Elements = p.parseExpressionList(token.RBRACKET)
+	return array
 }
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	expr := &ast.IndexExpression{
-		Token: p.curToken,
-		Left:  left,
+	tok := p.curToken
+
+	var index ast.Expression
+	if !p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		index = p.parseExpression(LOWEST)
 	}
 
-	p.nextToken()
-	expr.Index = p.parseExpression(LOWEST)
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		return p.parseSliceExpression(tok, left, index)
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return &ast.IndexExpression{Token: tok, Left: left, Index: index}
+}
+
+func (p *Parser) parseMemberExpression(object ast.Expression) ast.Expression {
+	expr := &ast.MemberExpression{Token: p.curToken, Object: object}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	expr.Member = &ast.Ident{Token: p.curToken, Value: p.curToken.Literal}
+
+	return expr
+}
+
+// parseSliceExpression parses the rest of a slice expression after the colon. `start` is nil if
+// it is omitted.
+func (p *Parser) parseSliceExpression(
+	tok token.Token, left ast.Expression, start ast.Expression,
+) ast.Expression {
+	expr := &ast.SliceExpression{Token: tok, Left: left, Start: start}
+
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		expr.End = p.parseExpression(LOWEST)
+	}
 
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
@@ -569,6 +1469,17 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 
 	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
+
+		// An identifier without a value makes the hash a pattern, e.g. `{name, age} = person`
+		if len(hash.Pairs) == 0 && p.curTokenIs(token.IDENT) &&
+			(p.peekTokenIs(token.COMMA) || p.peekTokenIs(token.RBRACE)) {
+			pattern := &ast.HashPattern{
+				Token: hash.Token,
+				Keys:  []*ast.Ident{{Token: p.curToken, Value: p.curToken.Literal}},
+			}
+			return p.parseHashPattern(pattern)
+		}
+
 		key := p.parseExpression(LOWEST)
 
 		if !p.expectPeek(token.COLON) {
@@ -598,17 +1509,38 @@ func (p *Parser) parseMacroLiteral() ast.Expression {
 		return nil
 	}
 
-	params := p.parseFunctionParameters()
+	params, defaults, rest := p.parseFunctionParameters(token.RPAREN)
+	if params == nil {
+		return nil
+	}
+	if len(defaults) > 0 || rest != nil {
+		p.errorf(tok, "macro parameters cannot have default values or a rest parameter")
+		return nil
+	}
+
+	// Macros take unevaluated arguments, so they cannot be destructured
+	idents := make([]*ast.Ident, 0, len(params))
+	for _, param := range params {
+		ident, ok := param.(*ast.Ident)
+		if !ok {
+			p.addError(&ParseError{
+				Pos: param.Pos(),
+				Msg: fmt.Sprintf("macro parameter %s is not an identifier", param),
+			})
+			return nil
+		}
+		idents = append(idents, ident)
+	}
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
 
-	body := p.parseBlockStatement()
+	body := p.parseFunctionBody()
 
 	return &ast.MacroLiteral{
 		Token:      tok,
-		Parameters: params,
+		Parameters: idents,
 		Body:       body,
 	}
 }
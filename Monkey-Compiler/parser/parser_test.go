@@ -6,6 +6,7 @@ import (
 
 	"monkey-compiler/ast"
 	"monkey-compiler/lexer"
+	"monkey-compiler/token"
 )
 
 func TestLetStatements(t *testing.T) {
@@ -40,6 +41,85 @@ func TestLetStatements(t *testing.T) {
 	}
 }
 
+func TestLetStatementDocComments(t *testing.T) {
+	input := `
+## Doubles a number.
+##
+## It works with floats too.
+let double = fn(x) { x * 2 };
+
+## Not attached: a blank line follows.
+
+let a = 1;
+let b = 2; ## Not attached: trailing comment.
+# An ordinary comment.
+let c = 3;
+## Attached to d.
+#{ block }# let d = 4;
+`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	want := []string{
+		"Doubles a number.\n\nIt works with floats too.",
+		"",
+		"",
+		"",
+		"Attached to d.",
+	}
+
+	if len(program.Statements) != len(want) {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d", len(want), len(program.Statements))
+	}
+
+	for i, doc := range want {
+		stmt, ok := program.Statements[i].(*ast.LetStatement)
+		if !ok {
+			t.Fatalf("program.Statements[%d] is not *ast.LetStatement. got=%T", i, program.Statements[i])
+		}
+
+		if stmt.Doc != doc {
+			t.Errorf("program.Statements[%d] - doc wrong. want=%q, got=%q", i, doc, stmt.Doc)
+		}
+	}
+}
+
+func TestConstStatements(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"const LIMIT = 10;", "const LIMIT = 10;"},
+		{"const [a, b] = xs;", "const [a, b] = xs;"},
+		{"const {name} = person", "const {name} = person;"},
+		{"const f = fn(x) { x };", "const f = fn<f>(x) x;"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.want {
+			t.Errorf("wrong program. want=%q, got=%q", tt.want, got)
+		}
+
+		stmt, ok := program.Statements[0].(*ast.LetStatement)
+		if !ok || !stmt.IsConst() {
+			t.Errorf("not a const statement. got=%#v", program.Statements[0])
+		}
+	}
+
+	p := New(lexer.New("const 1 = 2;"))
+	p.ParseProgram()
+	want := "1:7: expected next token to be IDENT, got INT instead"
+	if errors := p.Errors(); len(errors) == 0 || errors[0].Error() != want {
+		t.Errorf("wrong parser errors. want=%q, got=%v", want, errors)
+	}
+}
+
 func TestLetStatementErrors(t *testing.T) {
 	tests := []struct {
 		input string
@@ -63,6 +143,190 @@ func TestLetStatementErrors(t *testing.T) {
 	}
 }
 
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let = 5;", "test.monkey:1:5: expected next token to be IDENT, got = instead"},
+		{"let x = 1;\nlet y 2;", "test.monkey:2:7: expected next token to be =, got INT instead"},
+		{"let x = 1;\n  if (x) { 1 } else }", "test.monkey:2:21: expected next token to be {, got } instead"},
+		{`let s = "abc\q";`, `test.monkey:1:13: unknown escape sequence "\q"`},
+		{`let s = "abc`, `test.monkey:1:9: unterminated string literal`},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.NewFile("test.monkey", tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("parser has no errors for %q", tt.input)
+		}
+
+		if got := errors[0].Error(); got != tt.want {
+			t.Errorf("wrong error. want=%q, got=%q", tt.want, got)
+		}
+	}
+}
+
+func TestParserErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"puts(1 +", []string{"1:9: expected an expression, got EOF instead"}},
+		{
+			"let x 1; let y = 2; let = 3; y;",
+			[]string{
+				"1:7: expected next token to be =, got INT instead",
+				"1:25: expected next token to be IDENT, got = instead",
+			},
+		},
+		{
+			"let f = fn() { let a = ; a + (1 }; let b 2;",
+			[]string{
+				"1:24: expected an expression, got ; instead",
+				"1:33: expected next token to be ), got } instead",
+				"1:42: expected next token to be =, got INT instead",
+			},
+		},
+		{
+			"if (x) { 1 } else }\nlet y 2;",
+			[]string{
+				"1:19: expected next token to be {, got } instead",
+				"2:7: expected next token to be =, got INT instead",
+			},
+		},
+		{
+			"let s = \"a\\qb\" + \"ok\"; let t = [1, 2;\nt",
+			[]string{
+				`1:11: unknown escape sequence "\q"`,
+				"1:37: expected next token to be ], got ; instead",
+			},
+		},
+		{
+			`let h = {"a": 1, "b" 2}; let y = 2;`,
+			[]string{"1:22: expected next token to be :, got INT instead"},
+		},
+		{
+			`foo({"a" 1}); let y = 2;`,
+			[]string{"1:10: expected next token to be :, got INT instead"},
+		},
+		{
+			"let f = fn() { let h = {\"a\" 1}; h };\nlet y 2;",
+			[]string{
+				"1:29: expected next token to be :, got INT instead",
+				"2:7: expected next token to be =, got INT instead",
+			},
+		},
+		{
+			"let a = 1; }; let b = ;\nlet c 3;",
+			[]string{
+				"1:12: expected an expression, got } instead",
+				"1:23: expected an expression, got ; instead",
+				"2:7: expected next token to be =, got INT instead",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.want) {
+			t.Errorf("%q - want %d errors, got %d (%q)", tt.input, len(tt.want), len(errors), errors)
+			continue
+		}
+
+		for i, want := range tt.want {
+			if got := errors[i].Error(); got != want {
+				t.Errorf("%q - errors[%d] wrong. want=%q, got=%q", tt.input, i, want, got)
+			}
+		}
+	}
+}
+
+func TestParserRecoveryKeepsValidStatements(t *testing.T) {
+	input := `
+let a = 1;
+let b = ) 2;
+let c = fn(x) {
+	let d = ;
+	x
+};
+let e = 5;
+`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 2 {
+		t.Fatalf("want 2 errors, got %d (%q)", len(p.Errors()), p.Errors())
+	}
+
+	want := []string{"let a = 1;", "let c = fn<c>(x) x;", "let e = 5;"}
+	if len(program.Statements) != len(want) {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d (%s)",
+			len(want), len(program.Statements), program)
+	}
+
+	for i, stmt := range program.Statements {
+		if got := stmt.String(); got != want[i] {
+			t.Errorf("program.Statements[%d] wrong. want=%q, got=%q", i, want[i], got)
+		}
+	}
+}
+
+func TestParseErrorFields(t *testing.T) {
+	p := New(lexer.NewFile("test.monkey", "let x = 1;\nlet y 2;"))
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("want 1 error, got %d (%q)", len(errors), errors)
+	}
+
+	err := errors[0]
+	wantPos := token.Position{Filename: "test.monkey", Offset: 17, Line: 2, Column: 7}
+	if err.Pos != wantPos {
+		t.Errorf("err.Pos wrong. want=%s, got=%s", wantPos, err.Pos)
+	}
+
+	if err.Expected != token.ASSIGN {
+		t.Errorf("err.Expected wrong. want=%q, got=%q", token.ASSIGN, err.Expected)
+	}
+
+	if err.Actual.Type != token.INT || err.Actual.Literal != "2" {
+		t.Errorf("err.Actual wrong. got=%#v", err.Actual)
+	}
+
+	if want := "expected next token to be =, got INT instead"; err.Msg != want {
+		t.Errorf("err.Msg wrong. want=%q, got=%q", want, err.Msg)
+	}
+}
+
+func TestParseErrorCaret(t *testing.T) {
+	tests := []struct {
+		line   string
+		column int
+		want   string
+	}{
+		{"let y 2;", 7, "let y 2;\n      ^\n"},
+		{"\tlet y 2;\r\n", 8, "\tlet y 2;\n\t      ^\n"},
+		{"let größe 2;", 11, "let größe 2;\n          ^\n"},
+		{"puts(1 +", 9, "puts(1 +\n        ^\n"},
+	}
+
+	for _, tt := range tests {
+		err := &ParseError{Pos: token.Position{Line: 1, Column: tt.column}}
+		if got := err.Caret(tt.line); got != tt.want {
+			t.Errorf("%q - caret wrong. want=%q, got=%q", tt.line, tt.want, got)
+		}
+	}
+}
+
 func testLetStatement(t *testing.T, s ast.Statement, name string) {
 	if s.TokenLiteral() != "let" {
 		t.Errorf("s.TokenLiteral not 'let'. got=%q", s.TokenLiteral())
@@ -119,6 +383,105 @@ func TestAssignmentStatements(t *testing.T) {
 	}
 }
 
+func TestCompoundAssignmentStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		operator token.Type
+		want     string
+	}{
+		{"x += 1", token.PLUS_ASSIGN, "x += 1;"},
+		{"x -= y * 2;", token.MINUS_ASSIGN, "x -= (y * 2);"},
+		{"xs[i] *= 3", token.ASTARISK_ASSIGN, "(xs[i]) *= 3;"},
+		{"x /= 2.5", token.SLASH_ASSIGN, "x /= 2.5;"},
+		{`counts["a"] %= 7`, token.PERCENT_ASSIGN, "(counts[a]) %= 7;"},
+		{"i++", token.INC, "i++;"},
+		{"h[k]--;", token.DEC, "(h[k])--;"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d",
+				len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.AssignStatement)
+		if !ok {
+			t.Fatalf("statement not *ast.AssignStatement. got=%T", program.Statements[0])
+		}
+		if stmt.Token.Type != tt.operator {
+			t.Errorf("wrong operator. want=%q, got=%q", tt.operator, stmt.Token.Type)
+		}
+		if got := stmt.String(); got != tt.want {
+			t.Errorf("wrong statement. want=%q, got=%q", tt.want, got)
+		}
+	}
+}
+
+func TestDestructuringPatterns(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let [a, b] = xs;", "let [a, b] = xs;"},
+		{"let [a, [b, c], ...rest] = xs;", "let [a, [b, c], ...rest] = xs;"},
+		{"let [] = xs;", "let [] = xs;"},
+		{"let {name, age} = person;", "let {name, age} = person;"},
+		{"let [{x}, {y}] = points;", "let [{x}, {y}] = points;"},
+		{"[a, b] = [b, a]", "[a, b] = [b, a];"},
+		{"[first, ...rest] = xs", "[first, ...rest] = xs;"},
+		{"{name, age} = person", "{name, age} = person;"},
+		{"fn([a, b], {c}) {}", "fn([a, b], {c}) "},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d",
+				len(program.Statements))
+		}
+
+		if got := program.Statements[0].String(); got != tt.want {
+			t.Errorf("wrong statement. want=%q, got=%q", tt.want, got)
+		}
+	}
+}
+
+func TestDestructuringPatternErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let [a, 1] = xs;", "1:9: expected an identifier or a pattern, got INT instead"},
+		{"let [...rest, a] = xs;", "1:13: expected next token to be ], got , instead"},
+		{"let {a: b} = h;", "1:7: expected next token to be ,, got : instead"},
+		{"[a, f()] = xs", "1:6: expected an identifier or a pattern, got f() instead"},
+		{"{a, b}", "1:7: expected next token to be =, got EOF instead"},
+		{"[...rest, a] = xs", "1:2: rest element ...rest must be the last element and an identifier"},
+		{"[a, ...f()] = xs", "1:5: rest element ...f() must be the last element and an identifier"},
+		{"macro([a]) { a }", "1:7: macro parameter [a] is not an identifier"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("%q - expected parser errors, but got none", tt.input)
+		}
+		if got := errors[0].Error(); got != tt.want {
+			t.Errorf("%q - wrong error. want=%q, got=%q", tt.input, tt.want, got)
+		}
+	}
+}
+
 func testAssignmentStatement(t *testing.T, s ast.Statement, name string) {
 	stmt, ok := s.(*ast.AssignStatement)
 	if !ok {
@@ -139,6 +502,121 @@ func testAssignmentStatement(t *testing.T, s ast.Statement, name string) {
 	}
 }
 
+func TestImportAndExportStatements(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`import "lib/strings.monkey" as strings`, `import "lib/strings.monkey" as strings;`},
+		{`import "a.monkey" as a; import "b.monkey" as b;`, `import "a.monkey" as a;import "b.monkey" as b;`},
+		{"export let x = 1;", "export let x = 1;"},
+		{"export let [a, b] = xs;", "export let [a, b] = xs;"},
+		{"export let f = fn(x) { x };", "export let f = fn<f>(x) x;"},
+		{"export const x = 1;", "export const x = 1;"},
+		{"let as = 1; as", "let as = 1;as"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.want {
+			t.Errorf("wrong program. want=%q, got=%q", tt.want, got)
+		}
+	}
+
+	p := New(lexer.New("## Doubles x.\nexport let double = fn(x) { x * 2 };"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.LetStatement)
+	if !stmt.Exported || stmt.Doc != "Doubles x." {
+		t.Errorf("wrong let statement. want exported with doc %q, got exported=%t with doc %q",
+			"Doubles x.", stmt.Exported, stmt.Doc)
+	}
+}
+
+func TestImportAndExportErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`import lib as lib`, "1:8: expected next token to be STRING, got IDENT instead"},
+		{`import "lib.monkey"`, "1:20: expected as after the path of an import, got EOF instead"},
+		{`import "lib.monkey" as "lib"`, "1:24: expected next token to be IDENT, got STRING instead"},
+		{`fn() { import "lib.monkey" as lib }`, "1:8: import is only allowed at the top level"},
+		{"export x = 1", "1:8: expected next token to be LET, got IDENT instead"},
+		{"if (true) { export let x = 1 }", "1:13: export is only allowed at the top level"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("%q - expected parser errors, but got none", tt.input)
+		}
+		if got := errors[0].Error(); got != tt.want {
+			t.Errorf("%q - wrong error. want=%q, got=%q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestTryAndThrowStatements(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`throw "boom"`, "throw boom;"},
+		{"throw f(x);", "throw f(x);"},
+		{"try { f() } catch (e) { g(e) }", "try f() catch(e) g(e)"},
+		{"try { f() } catch { g() }", "try f() catch g()"},
+		{"try { f() } finally { g() }", "try f() finally g()"},
+		{"try { f() } catch (e) { throw e } finally { g() }", "try f() catch(e) throw e; finally g()"},
+		{"try { try { f() } finally { g() } } catch (e) {}", "try try f() finally g() catch(e) "},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.want {
+			t.Errorf("wrong program. want=%q, got=%q", tt.want, got)
+		}
+	}
+}
+
+func TestTryAndThrowErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"throw", "1:6: expected an expression, got EOF instead"},
+		{"try f()", "1:5: expected next token to be {, got IDENT instead"},
+		{"try { f() }", "1:12: expected catch or finally after try, got EOF instead"},
+		{"try { f() } catch e { g() }", "1:19: expected next token to be {, got IDENT instead"},
+		{"try { f() } catch (1) { g() }", "1:20: expected next token to be IDENT, got INT instead"},
+		{"try { f() } catch (e { g() }", "1:22: expected next token to be ), got { instead"},
+		{"try { f() } finally g()", "1:21: expected next token to be {, got IDENT instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("%q - expected parser errors, but got none", tt.input)
+		}
+		if got := errors[0].Error(); got != tt.want {
+			t.Errorf("%q - wrong error. want=%q, got=%q", tt.input, tt.want, got)
+		}
+	}
+}
+
 func TestReturnStatement(t *testing.T) {
 	tests := []struct {
 		input         string
@@ -216,6 +694,71 @@ func TestIntegerExpression(t *testing.T) {
 	testIntegerLiteral(t, stmt.Expression, 5)
 }
 
+func TestNumericLiteralValues(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interface{}
+	}{
+		{"0xFF", int64(255)},
+		{"0o17", int64(15)},
+		{"0b1010", int64(10)},
+		{"1_000_000", int64(1000000)},
+		{"9223372036854775807", int64(9223372036854775807)},
+		{"-9223372036854775808", int64(-9223372036854775808)},
+		{"-0x8000_0000_0000_0000", int64(-9223372036854775808)},
+		{"6.02e23", 6.02e23},
+		{"1_000.5", 1000.5},
+		{"1e-3", 0.001},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+
+		switch want := tt.want.(type) {
+		case int64:
+			lit, ok := stmt.Expression.(*ast.IntegerLiteral)
+			if !ok {
+				t.Errorf("%s - expr not *ast.IntegerLiteral. got=%T", tt.input, stmt.Expression)
+			} else if lit.Value != want {
+				t.Errorf("%s - value wrong. want=%d, got=%d", tt.input, want, lit.Value)
+			}
+		case float64:
+			testFloatLiteral(t, stmt.Expression, want)
+		}
+	}
+}
+
+func TestNumericLiteralOverflow(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"9223372036854775808", "1:1: integer literal 9223372036854775808 overflows int64 (max 9223372036854775807)"},
+		{"x = 0xFFFF_FFFF_FFFF_FFFF", "1:5: integer literal 0xFFFF_FFFF_FFFF_FFFF overflows int64 (max 9223372036854775807)"},
+		{"1e400", "1:1: float literal 1e400 overflows float64"},
+		{"-9223372036854775808 ** 2", "1:2: integer literal 9223372036854775808 overflows int64 (max 9223372036854775807)"},
+		{"-9223372036854775809", "1:2: integer literal 9223372036854775809 overflows int64 (max 9223372036854775807)"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("%s - want 1 error, got %d (%q)", tt.input, len(errors), errors)
+		}
+
+		if got := errors[0].Error(); got != tt.want {
+			t.Errorf("wrong error. want=%q, got=%q", tt.want, got)
+		}
+	}
+}
+
 func testFloatLiteral(t *testing.T, expr ast.Expression, value float64) {
 	fl, ok := expr.(*ast.FloatLiteral)
 	if !ok {
@@ -476,6 +1019,22 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		{"add(a + b + c * d / f + g)", "add((((a + b) + ((c * d) / f)) + g))"},
 		{"a * [1, 2, 3, 4][b * c] * d", "((a * ([1, 2, 3, 4][(b * c)])) * d)"},
 		{"add(a * b[2], b[1], 2 * [1, 2][1])", "add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))"},
+		{"a % b * c // d", "(((a % b) * c) // d)"},
+		{"a + b % c", "(a + (b % c))"},
+		{"2 ** 3 ** 2", "(2 ** (3 ** 2))"},
+		{"-2 ** 2", "(-(2 ** 2))"},
+		{"2 ** -1", "(2 ** (-1))"},
+		{"a * b ** c", "(a * (b ** c))"},
+		{"a | b ^ c & d", "(a | (b ^ (c & d)))"},
+		{"a & b == c", "((a & b) == c)"},
+		{"a << 1 + b", "(a << (1 + b))"},
+		{"a & b << c", "(a & (b << c))"},
+		{"~a & b", "((~a) & b)"},
+		{"x % 3 == 0 && x % 5 == 0", "(((x % 3) == 0) && ((x % 5) == 0))"},
+		{"a ?? b || c", "(a ?? (b || c))"},
+		{"a ?? b ?? c", "((a ?? b) ?? c)"},
+		{"a?.b ?? c + 1", "((a?.b) ?? (c + 1))"},
+		{"-a?[0]", "(-(a?[0]))"},
 	}
 
 	for _, tt := range tests {
@@ -644,6 +1203,101 @@ func TestIfElseExpression(t *testing.T) {
 	testIdent(t, alt.Expression, "y")
 }
 
+func TestLoopStatements(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"while (x < 10) { x = x + 1; }", "while(x < 10) x = (x + 1);"},
+		{"while (true) { break; continue }", "whiletrue break;continue;"},
+		{"for (let i = 0; i < 10; i = i + 1) { puts(i) }", "for (let i = 0; (i < 10); i = (i + 1)) puts(i)"},
+		{"for (i = 0; i < 10; i = i + 1) { }", "for (i = 0; (i < 10); i = (i + 1)) "},
+		{"for (;;) { break }", "for (; ; ) break;"},
+		{"for (; x;) { }", "for (; x; ) "},
+		{"for (f();;) { }", "for (f(); ; ) "},
+		{"while (a) { for (;;) { if (b) { break } } continue; }", "whilea for (; ; ) ifb break;continue;"},
+		{"while (i < 3) { i++ };", "while(i < 3) i++;"},
+		{"for (;i < 3;) { i++ };;", "for (; (i < 3); ) i++;"},
+		{"for (x in [1, 2]) { puts(x) }", "for (x in [1, 2]) puts(x)"},
+		{"for (k, v in h) { continue }", "for (k, v in h) continue;"},
+		{"for (c in \"abc\" + s) { }", "for (c in (abc + s)) "},
+		{"for (x in [1]) { x };", "for (x in [1]) x"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("%s - program.Statements does not contain 1 statement. got=%d",
+				tt.input, len(program.Statements))
+		}
+
+		if got := program.String(); got != tt.want {
+			t.Errorf("%s - wrong program. want=%q, got=%q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestSemicolonsAfterLoopStatements(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"while (i < 3) { i++ }; i", "while(i < 3) i++;i"},
+		{"for (;i < 3;) { i++ }; i", "for (; (i < 3); ) i++;i"},
+		{"for (x in [1]) { x }; x", "for (x in [1]) xx"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 2 {
+			t.Fatalf("%s - program.Statements does not contain 2 statements. got=%d",
+				tt.input, len(program.Statements))
+		}
+
+		if got := program.String(); got != tt.want {
+			t.Errorf("%s - wrong program. want=%q, got=%q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestLoopStatementErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"break;", "1:1: break is not in a loop"},
+		{"if (x) { continue }", "1:10: continue is not in a loop"},
+		{"while (x) { fn() { break } }", "1:20: break is not in a loop"},
+		{"while x { }", "1:7: expected next token to be (, got IDENT instead"},
+		{"for (let i = 0, i < 1) { }", "1:15: expected next token to be ;, got , instead"},
+		{"for (;; i = i + 1 { }", "1:19: expected next token to be ), got { instead"},
+		{"for (k, in h) { }", "1:9: expected next token to be IDENT, got IN instead"},
+		{"for (k, v, w in h) { }", "1:10: expected next token to be IN, got , instead"},
+		{"for (x in xs { }", "1:14: expected next token to be ), got { instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Errorf("%s - want 1 error, got %d (%q)", tt.input, len(errors), errors)
+			continue
+		}
+
+		if got := errors[0].Error(); got != tt.want {
+			t.Errorf("%s - wrong error. want=%q, got=%q", tt.input, tt.want, got)
+		}
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := "fn(x, y) { x + y; }"
 
@@ -743,6 +1397,151 @@ func TestFunctionParameterParsing(t *testing.T) {
 	}
 }
 
+func TestDefaultAndRestParameterParsing(t *testing.T) {
+	tests := []struct {
+		input        string
+		want         string
+		wantDefaults int
+		wantRest     string
+	}{
+		{"fn(x, y = 10) {}", "fn(x, y = 10) ", 1, ""},
+		{"fn(x = 1, y = x * 2) {}", "fn(x = 1, y = (x * 2)) ", 2, ""},
+		{"fn(first, ...rest) {}", "fn(first, ...rest) ", 0, "rest"},
+		{"fn(...args) {}", "fn(...args) ", 0, "args"},
+		{"fn([a, b] = [1, 2], ...c) {}", "fn([a, b] = [1, 2], ...c) ", 1, "c"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		f := stmt.Expression.(*ast.FunctionLiteral)
+
+		if got := f.String(); got != tt.want {
+			t.Errorf("wrong function. want=%q, got=%q", tt.want, got)
+		}
+		if len(f.Defaults) != tt.wantDefaults {
+			t.Errorf("wrong number of defaults. want=%d, got=%d", tt.wantDefaults, len(f.Defaults))
+		}
+		if tt.wantRest == "" {
+			if f.Rest != nil {
+				t.Errorf("unexpected rest parameter %s", f.Rest)
+			}
+		} else if f.Rest == nil || f.Rest.Value != tt.wantRest {
+			t.Errorf("wrong rest parameter. want=%q, got=%v", tt.wantRest, f.Rest)
+		}
+	}
+}
+
+func TestParameterErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"fn(x = 1, y) {}", "1:11: non-default parameter y follows a default parameter"},
+		{"fn(...xs, y) {}", "1:9: expected next token to be ), got , instead"},
+		{"fn(...) {}", "1:7: expected next token to be IDENT, got ) instead"},
+		{"macro(x, y = 1) { x }", "1:1: macro parameters cannot have default values or a rest parameter"},
+		{"macro(...xs) { xs }", "1:1: macro parameters cannot have default values or a rest parameter"},
+		{"|x, y x", "1:7: expected next token to be |, got IDENT instead"},
+		{"|x|", "1:4: expected an expression, got EOF instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("%q - expected parser errors, but got none", tt.input)
+		}
+		if got := errors[0].Error(); got != tt.want {
+			t.Errorf("%q - wrong error. want=%q, got=%q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestShortFunctionLiteralParsing(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"|x| x * 2", "fn(x) (x * 2)"},
+		{"x => x * 2", "fn(x) (x * 2)"},
+		{"|x, y| x + y", "fn(x, y) (x + y)"},
+		{"|| 42", "fn() 42"},
+		{"|[a, b], ...cs| a", "fn([a, b], ...cs) a"},
+		{"|x, y = 1| x", "fn(x, y = 1) x"},
+		{"|x| { let y = x; y }", "fn(x) let y = x;y"},
+		{"map(xs, |x| x + 1)", "map(xs, fn(x) (x + 1))"},
+		{"map(xs, x => x + 1)", "map(xs, fn(x) (x + 1))"},
+		{"|x| y => x + y", "fn(x) fn(y) (x + y)"},
+		{"let double = |x| x * 2", "let double = fn<double>(x) (x * 2);"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.want {
+			t.Errorf("wrong program. want=%q, got=%q", tt.want, got)
+		}
+	}
+}
+
+func TestPipeParsing(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"x |> f", "f(x)"},
+		{"x |> f(y)", "f(x, y)"},
+		{"xs |> map(double) |> filter(odd)", "filter(map(xs, double), odd)"},
+		{"a + b |> f", "f((a + b))"},
+		{"xs |> len == 3", "(len(xs) == 3)"},
+		{"x |> |y| y * 2", "fn(y) (y * 2)(x)"},
+		{"x |> obj.method(1)", "(obj.method)(x, 1)"},
+		{"xs |> f(...ys)", "f(xs, ...ys)"},
+		{"1 |> (add(2))", "add(2)(1)"},
+		{"1 |> (add(2)) |> f(3)", "f(add(2)(1), 3)"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.want {
+			t.Errorf("wrong program. want=%q, got=%q", tt.want, got)
+		}
+	}
+}
+
+func TestSpreadParsing(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"f(...args)", "f(...args)"},
+		{"f(1, ...xs, g(...ys))", "f(1, ...xs, g(...ys))"},
+		{"[...a, ...b]", "[...a, ...b]"},
+		{"[0, ...xs + ys]", "[0, ...(xs + ys)]"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.want {
+			t.Errorf("wrong program. want=%q, got=%q", tt.want, got)
+		}
+	}
+}
+
 func TestCallFunctionParsing(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 
@@ -805,6 +1604,54 @@ func TestStringLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestStringInterpolationParsing(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`"Hello ${name}!"`, `((Hello  + ${name}) + !)`},
+		{`"${a + b}"`, `${(a + b)}`},
+		{`"${x}${y}"`, `(${x} + ${y})`},
+		{`"a ${ {"k": 1}["k"] } b"`, `((a  + ${({, k: 1}[k])}) +  b)`},
+		{`"outer ${"inner ${x}"}"`, `(outer  + ${(inner  + ${x})})`},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.want {
+			t.Errorf("%s - wrong lowering. want=%s, got=%s", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestStringInterpolationErrors(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr string
+	}{
+		{`"a ${} b"`, "1:6: empty expression in string interpolation"},
+		{`"a ${x y} b"`, "1:8: expected next token to be STRING_TAIL, got IDENT instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("%s - expected parser errors, got none", tt.input)
+			continue
+		}
+
+		if got := errors[0].Error(); got != tt.wantErr {
+			t.Errorf("%s - wrong error. want=%q, got=%q", tt.input, tt.wantErr, got)
+		}
+	}
+}
+
 func TestParsingArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 
@@ -862,6 +1709,116 @@ func TestParsingIndexExpressions(t *testing.T) {
 	testInfixExpression(t, idxExpr.Index, 1, "+", 1)
 }
 
+func TestParsingSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"xs[1:3]", "(xs[1:3])"},
+		{"xs[:-1]", "(xs[:(-1)])"},
+		{"s[2:]", "(s[2:])"},
+		{"s[:]", "(s[:])"},
+		{"xs[i + 1:len(xs)][0]", "((xs[(i + 1):len(xs)])[0])"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.want {
+			t.Errorf("wrong program. want=%q, got=%q", tt.want, got)
+		}
+	}
+}
+
+func TestParsingMemberExpressions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"person.name", "(person.name)"},
+		{"person.address.city", "((person.address).city)"},
+		{"person.tags[0]", "((person.tags)[0])"},
+		{"people[0].name", "((people[0]).name)"},
+		{"-person.age", "(-(person.age))"},
+		{"person.age + 1", "((person.age) + 1)"},
+		{"person.greet(1, 2)", "(person.greet)(1, 2)"},
+		{"person.age = 30", "(person.age) = 30;"},
+		{"person.age += 1", "(person.age) += 1;"},
+		{"person?.name", "(person?.name)"},
+		{"person?.address.city", "((person?.address).city)"},
+		{"person?.greet(1)", "(person?.greet)(1)"},
+		{"people?[0]?.name", "((people?[0])?.name)"},
+		{"people?[1:]", "(people?[1:])"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.want {
+			t.Errorf("wrong program. want=%q, got=%q", tt.want, got)
+		}
+	}
+}
+
+func TestGroupedChainLinks(t *testing.T) {
+	tests := []struct {
+		input       string
+		wantGrouped bool
+	}{
+		{"(a?.b).c", true},
+		{"(a?[0]).c", true},
+		{"(a?[1:]).c", true},
+		{"(a?.f()).c", true},
+		{"a?.b.c", false},
+		{"(a?.b.c)", false},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		member, ok := stmt.Expression.(*ast.MemberExpression)
+		if !ok {
+			t.Fatalf("exp not *ast.MemberExpression. got=%T", stmt.Expression)
+		}
+
+		if got := ast.IsGrouped(member.Object); got != tt.wantGrouped {
+			t.Errorf("wrong grouping of %q. want=%t, got=%t", member.Object, tt.wantGrouped, got)
+		}
+	}
+}
+
+func TestMemberExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"person.", "1:8: expected next token to be IDENT, got EOF instead"},
+		{`person."name"`, `1:8: expected next token to be IDENT, got STRING instead`},
+		{"person.(name)", "1:8: expected next token to be IDENT, got ( instead"},
+		{"person?.[0]", "1:9: expected next token to be IDENT, got [ instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("%q - expected parser errors, but got none", tt.input)
+		}
+		if got := errors[0].Error(); got != tt.want {
+			t.Errorf("%q - wrong error. want=%q, got=%q", tt.input, tt.want, got)
+		}
+	}
+}
+
 func TestParsingHashLiterals(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -1024,8 +1981,8 @@ func checkParserErrors(t *testing.T, p *Parser) {
 	}
 
 	t.Errorf("parser has %d errors", length)
-	for _, msg := range errors {
-		t.Errorf("parser error: %q", msg)
+	for _, err := range errors {
+		t.Errorf("parser error: %q", err)
 	}
 	t.FailNow()
 }
@@ -6,8 +6,8 @@ import (
 	"io"
 
 	"monkey-compiler/compiler"
-	"monkey-compiler/eval"
 	"monkey-compiler/lexer"
+	"monkey-compiler/module"
 	"monkey-compiler/object"
 	"monkey-compiler/parser"
 	"monkey-compiler/vm"
@@ -20,6 +20,7 @@ func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 
 	macroEnv := object.NewEnvironment()
+	loader := module.NewLoader()
 
 	symbolTable := compiler.NewSymbolTable()
 
@@ -43,16 +44,20 @@ func Start(in io.Reader, out io.Writer) {
 
 		program := p.ParseProgram()
 		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+			printParserErrors(out, line, p.Errors())
 			continue
 		}
 
-		// Process macros
-		eval.DefineMacros(program, macroEnv)
-		expanded := eval.ExpandMacros(program, macroEnv)
+		// Process macros, including the ones of imported modules
+		expanded, err := loader.ExpandMacros(program, macroEnv)
+		if err != nil {
+			fmt.Fprintf(out, "Woops! Import failed: %s\n", err)
+			continue
+		}
 
 		// Compile the AST to bytecode
 		complr := compiler.NewWithState(symbolTable, constants)
+		complr.SetLoader(loader)
 		if err := complr.Compile(expanded); err != nil {
 			fmt.Fprintf(out, "Woops! Compilation failed: %s\n", err)
 			continue
@@ -80,9 +85,12 @@ func Start(in io.Reader, out io.Writer) {
 	}
 }
 
-func printParserErrors(out io.Writer, errors []string) {
-	for _, msg := range errors {
-		io.WriteString(out, msg)
+// printParserErrors prints parse errors, each followed by the input `line` with a caret under
+// the offending column.
+func printParserErrors(out io.Writer, line string, errors []*parser.ParseError) {
+	for _, err := range errors {
+		io.WriteString(out, err.Error())
 		io.WriteString(out, "\n")
+		io.WriteString(out, err.Caret(line))
 	}
 }
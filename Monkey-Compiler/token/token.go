@@ -1,13 +1,17 @@
 package token
 
+import "fmt"
+
 // Type is a token type.
 type Type string
 
 const (
-	// ILLEGAL is a token type for illegal tokens.
+	// ILLEGAL is a token type for illegal tokens. Its literal is a diagnostic message.
 	ILLEGAL Type = "ILLEGAL"
 	// EOF is a token type that represents end of file.
 	EOF = "EOF"
+	// DOC_COMMENT is a token type for a `##` doc comment. Its literal is the comment text.
+	DOC_COMMENT = "DOC_COMMENT"
 
 	// IDENT is a token type for identifiers.
 	IDENT = "IDENT" // add, foobar, x, y, ...
@@ -17,11 +21,34 @@ const (
 	FLOAT = "FLOAT"
 	// STRING is a token type for strings.
 	STRING = "STRING"
+	// STRING_HEAD is a token type for the text of an interpolated string before the first
+	// embedded expression, e.g. `"Hello ${`.
+	STRING_HEAD = "STRING_HEAD"
+	// STRING_MIDDLE is a token type for the text of an interpolated string between two
+	// embedded expressions, e.g. `}, you are ${`.
+	STRING_MIDDLE = "STRING_MIDDLE"
+	// STRING_TAIL is a token type for the text of an interpolated string after the last
+	// embedded expression, e.g. `}!"`.
+	STRING_TAIL = "STRING_TAIL"
 
 	// BANG is a token type for NOT operator.
 	BANG = "!"
 	// ASSIGN is a token type for assignment operators.
 	ASSIGN = "="
+	// PLUS_ASSIGN is a token type for addition assignment operators.
+	PLUS_ASSIGN = "+="
+	// MINUS_ASSIGN is a token type for subtraction assignment operators.
+	MINUS_ASSIGN = "-="
+	// ASTARISK_ASSIGN is a token type for multiplication assignment operators.
+	ASTARISK_ASSIGN = "*="
+	// SLASH_ASSIGN is a token type for division assignment operators.
+	SLASH_ASSIGN = "/="
+	// PERCENT_ASSIGN is a token type for remainder assignment operators.
+	PERCENT_ASSIGN = "%="
+	// INC is a token type for increment operators.
+	INC = "++"
+	// DEC is a token type for decrement operators.
+	DEC = "--"
 	// PLUS is a token type for addition.
 	PLUS = "+"
 	// MINUS is a token type for subtraction.
@@ -30,6 +57,24 @@ const (
 	ASTARISK = "*"
 	// SLASH is a token type for division.
 	SLASH = "/"
+	// FLOOR_DIV is a token type for integer (floor) division.
+	FLOOR_DIV = "//"
+	// PERCENT is a token type for remainder.
+	PERCENT = "%"
+	// POWER is a token type for exponentiation.
+	POWER = "**"
+	// BIT_AND is a token type for bitwise AND.
+	BIT_AND = "&"
+	// BIT_OR is a token type for bitwise OR.
+	BIT_OR = "|"
+	// BIT_XOR is a token type for bitwise XOR.
+	BIT_XOR = "^"
+	// BIT_NOT is a token type for bitwise NOT.
+	BIT_NOT = "~"
+	// SHL is a token type for left shift.
+	SHL = "<<"
+	// SHR is a token type for right shift.
+	SHR = ">>"
 	// LT is a token ype for 'less than' operator.
 	LT = "<"
 	// GT is a token ype for 'greater than' operator.
@@ -46,6 +91,12 @@ const (
 	AND = "&&"
 	// OR is a token type for binary OR logical operator.
 	OR = "||"
+	// COALESCE is a token type for the nil-coalescing operator.
+	COALESCE = "??"
+	// PIPE is a token type for the pipeline operator.
+	PIPE = "|>"
+	// ARROW is a token type for the arrow of short function literals.
+	ARROW = "=>"
 
 	// COMMA is a token type for commas.
 	COMMA = ","
@@ -53,6 +104,12 @@ const (
 	SEMICOLON = ";"
 	// COLON is a token type for colons.
 	COLON = ":"
+	// ELLIPSIS is a token type for ellipses.
+	ELLIPSIS = "..."
+	// DOT is a token type for dots.
+	DOT = "."
+	// OPTIONAL_DOT is a token type for the dots of optional member expressions.
+	OPTIONAL_DOT = "?."
 
 	// LPAREN is a token type for left parentheses.
 	LPAREN = "("
@@ -66,11 +123,15 @@ const (
 	LBRACKET = "["
 	// RBRACKET is a token type for right brackets.
 	RBRACKET = "]"
+	// OPTIONAL_LBRACKET is a token type for the left brackets of optional index expressions.
+	OPTIONAL_LBRACKET = "?["
 
 	// FUNCTION is a token type for functions.
 	FUNCTION = "FUNCTION"
 	// LET is a token type for lets.
 	LET = "LET"
+	// CONST is a token type for consts.
+	CONST = "CONST"
 	// TRUE is a token type for true.
 	TRUE = "TRUE"
 	// FALSE is a token type for false.
@@ -85,25 +146,93 @@ const (
 	RETURN = "RETURN"
 	// MACRO is a token type for macros.
 	MACRO = "MACRO"
+	// WHILE is a token type for while loops.
+	WHILE = "WHILE"
+	// FOR is a token type for for loops.
+	FOR = "FOR"
+	// BREAK is a token type for break.
+	BREAK = "BREAK"
+	// CONTINUE is a token type for continue.
+	CONTINUE = "CONTINUE"
+	// IN is a token type for in.
+	IN = "IN"
+	// IMPORT is a token type for import.
+	IMPORT = "IMPORT"
+	// EXPORT is a token type for export.
+	EXPORT = "EXPORT"
+	// TRY is a token type for try.
+	TRY = "TRY"
+	// CATCH is a token type for catch.
+	CATCH = "CATCH"
+	// FINALLY is a token type for finally.
+	FINALLY = "FINALLY"
+	// THROW is a token type for throw.
+	THROW = "THROW"
 )
 
-// Token represents a token which has a token type and literal.
+// Position represents a location in a source file.
+type Position struct {
+	Filename string // filename, if any
+	Offset   int    // byte offset, starting at 0
+	Line     int    // line number, starting at 1
+	Column   int    // column number in characters, starting at 1
+}
+
+// IsValid reports whether the position is valid.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns a string in one of the following forms:
+//
+//	file:line:col    valid position with filename
+//	line:col         valid position without filename
+//	file             invalid position with filename
+//	-                invalid position without filename
+func (p Position) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
+
+// Token represents a token which has a token type, literal and position in the source.
 type Token struct {
 	Type    Type
 	Literal string
+	Pos     Position
 }
 
 // Language keywords
 var keywords = map[string]Type{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"nil":    NIL,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
-	"macro":  MACRO,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"const":    CONST,
+	"true":     TRUE,
+	"false":    FALSE,
+	"nil":      NIL,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"macro":    MACRO,
+	"while":    WHILE,
+	"for":      FOR,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"in":       IN,
+	"import":   IMPORT,
+	"export":   EXPORT,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+	"throw":    THROW,
 }
 
 // LookupIdent checks the language keywords to see whether the given identifier is a keyword.
@@ -13,6 +13,8 @@ type Frame struct {
 	// Base pointer points to the bottom of the stack of the current stack frame.
 	// It's also called "frame pointer".
 	bp int
+	// Number of arguments passed to the function.
+	numArgs int
 }
 
 // NewFrame creates a new stack frame for a given compiled function.
@@ -1,8 +1,9 @@
 package vm
 
 import (
-	"errors"
 	"fmt"
+	"math"
+	"strconv"
 
 	"monkey-compiler/code"
 	"monkey-compiler/compiler"
@@ -42,6 +43,17 @@ type VM struct {
 
 	frames    []*Frame
 	framesIdx int
+
+	// handlers is a stack of the exception handlers pushed by try statements.
+	handlers []handler
+}
+
+// handler is an exception handler, which catches an exception by unwinding the frames and the
+// stack to where the handler was pushed and jumping to the catch clause.
+type handler struct {
+	framesIdx, sp int
+	// catchIP is the position of the catch clause in the instructions of the frame.
+	catchIP int
 }
 
 // New creates a new VM instance which executes the given bytecode.
@@ -52,7 +64,11 @@ func New(bytecode *compiler.Bytecode) *VM {
 // NewWithGlobalStore creates a new VM instance which executes the given bytecode with the
 // given globals store.
 func NewWithGlobalStore(bytecode *compiler.Bytecode, globals []object.Object) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		SourceMap:    bytecode.SourceMap,
+		NumLocals:    bytecode.NumLocals,
+	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0) // Base pointer points to zero
 
@@ -62,8 +78,9 @@ func NewWithGlobalStore(bytecode *compiler.Bytecode, globals []object.Object) *V
 	return &VM{
 		consts: bytecode.Constants,
 
+		// The local slots of the blocks at the top level are at the bottom of the stack
 		stack: make([]object.Object, StackSize),
-		sp:    0,
+		sp:    bytecode.NumLocals,
 
 		globals: globals,
 
@@ -87,7 +104,23 @@ func (vm *VM) LastPoppedStackElem() object.Object {
 }
 
 // Run executes bytecode instructions.
+// A runtime error which is not caught is prefixed with the source position of the instruction
+// which caused it.
 func (vm *VM) Run() error {
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+
+		// Resume the execution at the catch clause of the innermost try statement
+		if !vm.catch(err) {
+			return vm.positionedError(err)
+		}
+	}
+}
+
+func (vm *VM) run() error {
 	frame := vm.currentFrame()
 	insns := frame.Instructions()
 
@@ -181,18 +214,20 @@ func (vm *VM) Run() error {
 				return err
 			}
 
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
-			if err := vm.execBinaryOp(op); err != nil {
+		case code.OpBitNot:
+			if err := vm.execBitNotOp(); err != nil {
 				return err
 			}
 
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual:
-			if err := vm.execComparison(op); err != nil {
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpFloorDiv,
+			code.OpPow, code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft,
+			code.OpShiftRight:
+			if err := vm.execBinaryOp(op); err != nil {
 				return err
 			}
 
-		case code.OpAnd, code.OpOr:
-			if err := vm.execLogicalOp(op); err != nil {
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual:
+			if err := vm.execComparison(op); err != nil {
 				return err
 			}
 
@@ -211,6 +246,37 @@ func (vm *VM) Run() error {
 				frame.ip = pos - 1
 			}
 
+		case code.OpJumpNotTruthyOrPop, code.OpJumpTruthyOrPop:
+			pos := int(code.ReadUint16(insns[ip+1:]))
+			frame.ip += 2
+
+			// The value of the left operand is the value of the whole expression if the jump
+			// is taken
+			if isTruthy(vm.stack[vm.sp-1]) == (op == code.OpJumpTruthyOrPop) {
+				frame.ip = pos - 1
+			} else {
+				vm.pop()
+			}
+
+		case code.OpJumpNotNilOrPop:
+			pos := int(code.ReadUint16(insns[ip+1:]))
+			frame.ip += 2
+
+			if vm.stack[vm.sp-1] != Nil {
+				frame.ip = pos - 1
+			} else {
+				vm.pop()
+			}
+
+		case code.OpJumpNil:
+			pos := int(code.ReadUint16(insns[ip+1:]))
+			frame.ip += 2
+
+			// Nil is left on the stack as the value of the optional chain
+			if vm.stack[vm.sp-1] == Nil {
+				frame.ip = pos - 1
+			}
+
 		case code.OpSetGlobal:
 			globalIdx := code.ReadUint16(insns[ip+1:])
 			frame.ip += 2
@@ -221,7 +287,12 @@ func (vm *VM) Run() error {
 			globalIdx := code.ReadUint16(insns[ip+1:])
 			frame.ip += 2
 
-			if err := vm.push(vm.globals[globalIdx]); err != nil {
+			// A global function hoisted ahead of its let statement is nil until it runs
+			global := vm.globals[globalIdx]
+			if global == nil {
+				global = Nil
+			}
+			if err := vm.push(global); err != nil {
 				return err
 			}
 
@@ -233,6 +304,19 @@ func (vm *VM) Run() error {
 				return err
 			}
 
+		case code.OpCallSpread:
+			// Push the arguments collected in an array
+			args := vm.pop().(*object.Array).Elements
+			for _, arg := range args {
+				if err := vm.push(arg); err != nil {
+					return err
+				}
+			}
+
+			if err := vm.execCall(len(args)); err != nil {
+				return err
+			}
+
 		case code.OpReturnValue:
 			// Pop the return value off the stack before clearing the stack frame
 			retVal := vm.pop()
@@ -260,13 +344,44 @@ func (vm *VM) Run() error {
 			localIdx := int(code.ReadUint8(insns[ip+1:]))
 			frame.ip++
 
+			// A new binding replaces the cell the closures created in an earlier iteration of a
+			// loop captured, so that each of them keeps its own binding
 			vm.stack[frame.bp+localIdx] = vm.pop()
 
+		case code.OpAssignLocal:
+			localIdx := int(code.ReadUint8(insns[ip+1:]))
+			frame.ip++
+
+			if cell, ok := vm.stack[frame.bp+localIdx].(*object.Cell); ok {
+				cell.Value = vm.pop()
+			} else {
+				vm.stack[frame.bp+localIdx] = vm.pop()
+			}
+
 		case code.OpGetLocal:
 			localIdx := int(code.ReadUint8(insns[ip+1:]))
 			frame.ip++
 
-			if err := vm.push(vm.stack[frame.bp+localIdx]); err != nil {
+			value := vm.stack[frame.bp+localIdx]
+			if cell, ok := value.(*object.Cell); ok {
+				value = cell.Value
+			}
+
+			if err := vm.push(value); err != nil {
+				return err
+			}
+
+		case code.OpCaptureLocal:
+			localIdx := int(code.ReadUint8(insns[ip+1:]))
+			frame.ip++
+
+			cell, ok := vm.stack[frame.bp+localIdx].(*object.Cell)
+			if !ok {
+				cell = &object.Cell{Value: vm.stack[frame.bp+localIdx]}
+				vm.stack[frame.bp+localIdx] = cell
+			}
+
+			if err := vm.push(cell); err != nil {
 				return err
 			}
 
@@ -293,8 +408,22 @@ func (vm *VM) Run() error {
 			freeIdx := code.ReadUint8(insns[ip+1:])
 			frame.ip++
 
-			currentClosure := frame.cl
-			if err := vm.push(currentClosure.Free[freeIdx]); err != nil {
+			cell := frame.cl.Free[freeIdx].(*object.Cell)
+			if err := vm.push(cell.Value); err != nil {
+				return err
+			}
+
+		case code.OpSetFree:
+			freeIdx := code.ReadUint8(insns[ip+1:])
+			frame.ip++
+
+			frame.cl.Free[freeIdx].(*object.Cell).Value = vm.pop()
+
+		case code.OpCaptureFree:
+			freeIdx := code.ReadUint8(insns[ip+1:])
+			frame.ip++
+
+			if err := vm.push(frame.cl.Free[freeIdx]); err != nil {
 				return err
 			}
 
@@ -303,6 +432,114 @@ func (vm *VM) Run() error {
 			if err := vm.push(currentClosure); err != nil {
 				return err
 			}
+
+		case code.OpToString:
+			if err := vm.push(toString(vm.pop())); err != nil {
+				return err
+			}
+
+		case code.OpIter:
+			iterable := vm.pop()
+			iter, ok := object.NewIterator(iterable)
+			if !ok {
+				return object.NewError(object.TypeError, "%s is not iterable", iterable.Type())
+			}
+
+			if err := vm.push(iter); err != nil {
+				return err
+			}
+
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(insns[ip+1:]))
+			numVars := int(code.ReadUint8(insns[ip+3:]))
+			frame.ip += 3
+
+			ok, err := vm.execIterNext(numVars)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				frame.ip = pos - 1
+			}
+
+		case code.OpDup:
+			n := int(code.ReadUint8(insns[ip+1:]))
+			frame.ip++
+
+			for i := 0; i < n; i++ {
+				if err := vm.push(vm.stack[vm.sp-n]); err != nil {
+					return err
+				}
+			}
+
+		case code.OpSlice:
+			end := vm.pop()
+			start := vm.pop()
+			left := vm.pop()
+
+			slice, err := object.Slice(left, start, end)
+			if err != nil {
+				return err
+			}
+
+			if err := vm.push(slice); err != nil {
+				return err
+			}
+
+		case code.OpGetMethod:
+			nameIdx := int(code.ReadUint16(insns[ip+1:]))
+			frame.ip += 2
+
+			if err := vm.execGetMethod(vm.consts[nameIdx]); err != nil {
+				return err
+			}
+
+		case code.OpJumpIfPassed:
+			pos := int(code.ReadUint16(insns[ip+1:]))
+			paramIdx := int(code.ReadUint8(insns[ip+3:]))
+			frame.ip += 3
+
+			if paramIdx < frame.numArgs {
+				frame.ip = pos - 1
+			}
+
+		case code.OpExtendArray:
+			if err := vm.execExtendArray(); err != nil {
+				return err
+			}
+
+		case code.OpUnpackArray:
+			numElems := int(code.ReadUint8(insns[ip+1:]))
+			hasRest := code.ReadUint8(insns[ip+2:]) == 1
+			frame.ip += 2
+
+			if err := vm.execUnpackArray(numElems, hasRest); err != nil {
+				return err
+			}
+
+		case code.OpUnpackHash:
+			numKeys := int(code.ReadUint8(insns[ip+1:]))
+			frame.ip++
+
+			if err := vm.execUnpackHash(numKeys); err != nil {
+				return err
+			}
+
+		case code.OpTry:
+			catchIP := int(code.ReadUint16(insns[ip+1:]))
+			frame.ip += 2
+
+			vm.handlers = append(vm.handlers, handler{
+				framesIdx: vm.framesIdx,
+				sp:        vm.sp,
+				catchIP:   catchIP,
+			})
+
+		case code.OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case code.OpThrow:
+			return object.NewThrownError(vm.pop())
 		}
 
 		// Update current frame and instructions for the next interation
@@ -313,6 +550,40 @@ func (vm *VM) Run() error {
 	return nil
 }
 
+// positionedError prefixes `err` with the source position of the current instruction, if the
+// position is known.
+func (vm *VM) positionedError(err error) error {
+	frame := vm.currentFrame()
+	pos := frame.cl.Fn.SourceMap.Lookup(frame.ip)
+	if !pos.IsValid() {
+		return err
+	}
+	return fmt.Errorf("%s: %w", pos, err)
+}
+
+// catch passes the exception raised by `err` to the innermost exception handler, unwinding the
+// frames and the stack to where the handler was pushed. It reports false if there is no handler.
+func (vm *VM) catch(err error) bool {
+	if len(vm.handlers) == 0 {
+		return false
+	}
+
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	exc, ok := err.(*object.Error)
+	if !ok {
+		exc = object.NewError(object.GenericError, "%s", err)
+	}
+
+	vm.framesIdx = h.framesIdx
+	vm.sp = h.sp
+	// The instruction pointer is incremented before the next instruction is fetched
+	vm.currentFrame().ip = h.catchIP - 1
+
+	return vm.push(exc.Caught()) == nil
+}
+
 func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIdx-1]
 }
@@ -329,7 +600,7 @@ func (vm *VM) popFrame() *Frame {
 
 func (vm *VM) push(obj object.Object) error {
 	if vm.sp >= StackSize {
-		return errors.New("stack overflow")
+		return object.NewError(object.GenericError, "stack overflow")
 	}
 
 	// Push the object on to the stack
@@ -375,7 +646,7 @@ func (vm *VM) buildHash(startIdx, endIdx int) (object.Object, error) {
 
 		hashKey, ok := key.(object.Hashable)
 		if !ok {
-			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			return nil, object.NewError(object.TypeError, "unusable as hash key: %s", key.Type())
 		}
 
 		m[hashKey.HashKey()] = pair
@@ -404,10 +675,21 @@ func (vm *VM) execMinusOp() error {
 	case *object.Float:
 		return vm.push(&object.Float{Value: -operand.Value})
 	default:
-		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
+		return object.NewError(
+			object.TypeError,
+			"unsupported type for negation: %s", operand.Type(),
+		)
 	}
 }
 
+func (vm *VM) execBitNotOp() error {
+	operand := vm.pop()
+	if operand, ok := operand.(*object.Integer); ok {
+		return vm.push(&object.Integer{Value: ^operand.Value})
+	}
+	return object.NewError(object.TypeError, "unsupported type for bitwise not: %s", operand.Type())
+}
+
 func (vm *VM) execBinaryOp(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
@@ -420,7 +702,8 @@ func (vm *VM) execBinaryOp(op code.Opcode) error {
 	case isBothType(object.StringType, left, right):
 		return vm.execBinaryStrOp(op, left, right)
 	default:
-		return fmt.Errorf(
+		return object.NewError(
+			object.TypeError,
 			"unsupported types for binary operation %d: %s and %s", op, left.Type(), right.Type(),
 		)
 	}
@@ -439,10 +722,35 @@ func (vm *VM) execBinaryIntOp(op code.Opcode, left, right object.Object) error {
 		result = leftVal - rightVal
 	case code.OpMul:
 		result = leftVal * rightVal
-	case code.OpDiv:
-		result = leftVal / rightVal
+	case code.OpFloorDiv:
+		if rightVal == 0 {
+			return object.NewError(object.ArithmeticError, "integer division by zero")
+		}
+		result = object.FloorDiv(leftVal, rightVal)
+	case code.OpMod:
+		if rightVal == 0 {
+			return object.NewError(object.ArithmeticError, "integer modulo by zero")
+		}
+		result = object.FloorMod(leftVal, rightVal)
+	case code.OpPow:
+		result = object.IntPow(leftVal, rightVal)
+	case code.OpBitAnd:
+		result = leftVal & rightVal
+	case code.OpBitOr:
+		result = leftVal | rightVal
+	case code.OpBitXor:
+		result = leftVal ^ rightVal
+	case code.OpShiftLeft, code.OpShiftRight:
+		if rightVal < 0 {
+			return object.NewError(object.ArithmeticError, "negative shift count: %d", rightVal)
+		}
+		if op == code.OpShiftLeft {
+			result = leftVal << rightVal
+		} else {
+			result = leftVal >> rightVal
+		}
 	default:
-		return fmt.Errorf("unknown integer operator: %d", op)
+		return object.NewError(object.GenericError, "unknown integer operator: %d", op)
 	}
 
 	return vm.push(&object.Integer{Value: result})
@@ -470,8 +778,14 @@ func (vm *VM) execBinaryFloatOp(op code.Opcode, left, right object.Object) error
 		result = leftVal * rightVal
 	case code.OpDiv:
 		result = leftVal / rightVal
+	case code.OpFloorDiv:
+		result = math.Floor(leftVal / rightVal)
+	case code.OpMod:
+		result = object.FloatFloorMod(leftVal, rightVal)
+	case code.OpPow:
+		result = math.Pow(leftVal, rightVal)
 	default:
-		return fmt.Errorf("unknown float operator: %d", op)
+		return object.NewError(object.GenericError, "unknown float operator: %d", op)
 	}
 
 	return vm.push(&object.Float{Value: result})
@@ -479,7 +793,7 @@ func (vm *VM) execBinaryFloatOp(op code.Opcode, left, right object.Object) error
 
 func (vm *VM) execBinaryStrOp(op code.Opcode, left, right object.Object) error {
 	if op != code.OpAdd {
-		return fmt.Errorf("unknown string operator: %d", op)
+		return object.NewError(object.GenericError, "unknown string operator: %d", op)
 	}
 
 	leftVal := left.(*object.String).Value
@@ -496,17 +810,22 @@ func (vm *VM) execSetIndexExpr(left, idx, val object.Object) error {
 	case leftType == object.HashType:
 		return vm.execHashSetIndex(left, idx, val)
 	default:
-		return fmt.Errorf("index operator not supported: %s", leftType)
+		return object.NewError(object.TypeError, "index operator not supported: %s", leftType)
 	}
 }
 
 func (vm *VM) execArraySetIndex(array, idx, val object.Object) error {
 	arr := array.(*object.Array)
-	i := idx.(*object.Integer).Value
-	max := int64(len(arr.Elements) - 1)
+	if arr.Frozen {
+		return object.NewError(object.TypeError, "cannot modify frozen Array")
+	}
 
-	if i < 0 || i > max {
-		return fmt.Errorf("array index %d out of range", i)
+	i, ok := object.Index(idx.(*object.Integer).Value, len(arr.Elements))
+	if !ok {
+		return object.NewError(
+			object.IndexError,
+			"array index %d out of range", idx.(*object.Integer).Value,
+		)
 	}
 
 	arr.Elements[i] = val
@@ -516,10 +835,13 @@ func (vm *VM) execArraySetIndex(array, idx, val object.Object) error {
 
 func (vm *VM) execHashSetIndex(hash, idx, val object.Object) error {
 	h := hash.(*object.Hash)
+	if h.Frozen {
+		return object.NewError(object.TypeError, "cannot modify frozen Hash")
+	}
 
 	key, ok := idx.(object.Hashable)
 	if !ok {
-		return fmt.Errorf("unusable as hash key: %s", idx.Type())
+		return object.NewError(object.TypeError, "unusable as hash key: %s", idx.Type())
 	}
 
 	h.Pairs[key.HashKey()] = object.HashPair{Key: idx, Value: val}
@@ -532,31 +854,42 @@ func (vm *VM) execGetIndexExpr(left, idx object.Object) error {
 	switch {
 	case leftType == object.ArrayType && idx.Type() == object.IntegerType:
 		return vm.execArrayGetIndex(left, idx)
+	case leftType == object.StringType && idx.Type() == object.IntegerType:
+		return vm.execStringGetIndex(left, idx)
 	case leftType == object.HashType:
 		return vm.execHashGetIndex(left, idx)
+	case leftType == object.ModuleType && idx.Type() == object.StringType:
+		return vm.execModuleGetIndex(left, idx)
 	default:
-		return fmt.Errorf("index operator not supported: %s", leftType)
+		return object.NewError(object.TypeError, "index operator not supported: %s", leftType)
 	}
 }
 
 func (vm *VM) execArrayGetIndex(array, idx object.Object) error {
 	arr := array.(*object.Array)
-	i := idx.(*object.Integer).Value
-	max := int64(len(arr.Elements) - 1)
-
-	if i < 0 || i > max {
+	i, ok := object.Index(idx.(*object.Integer).Value, len(arr.Elements))
+	if !ok {
 		return vm.push(Nil)
 	}
 
 	return vm.push(arr.Elements[i])
 }
 
+func (vm *VM) execStringGetIndex(str, idx object.Object) error {
+	ch, ok := object.CharAt(str.(*object.String), idx.(*object.Integer).Value)
+	if !ok {
+		return vm.push(Nil)
+	}
+
+	return vm.push(ch)
+}
+
 func (vm *VM) execHashGetIndex(hash, idx object.Object) error {
 	h := hash.(*object.Hash)
 
 	key, ok := idx.(object.Hashable)
 	if !ok {
-		return fmt.Errorf("unusable as hash key: %s", idx.Type())
+		return object.NewError(object.TypeError, "unusable as hash key: %s", idx.Type())
 	}
 
 	pair, ok := h.Pairs[key.HashKey()]
@@ -567,6 +900,19 @@ func (vm *VM) execHashGetIndex(hash, idx object.Object) error {
 	return vm.push(pair.Value)
 }
 
+func (vm *VM) execModuleGetIndex(module, idx object.Object) error {
+	mod := module.(*object.Module)
+	name := idx.(*object.String).Value
+
+	globalIdx, ok := mod.Globals[name]
+	if !ok {
+		return object.NewError(object.IndexError, "module %s does not export %s", mod.Name, name)
+	}
+
+	// The binding is read when it is accessed, so that it is live
+	return vm.push(vm.globals[globalIdx])
+}
+
 func (vm *VM) execComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
@@ -585,7 +931,10 @@ func (vm *VM) execComparison(op code.Opcode) error {
 	case code.OpNotEqual:
 		result = left != right
 	default:
-		return fmt.Errorf("unknown operator %d: %s and %s", op, left.Type(), right.Type())
+		return object.NewError(
+			object.TypeError,
+			"unknown operator %d: %s and %s", op, left.Type(), right.Type(),
+		)
 	}
 
 	return vm.push(nativeBoolToBooleanObject(result))
@@ -607,7 +956,7 @@ func (vm *VM) execIntComparison(op code.Opcode, left, right object.Object) error
 	case code.OpGreaterThanOrEqual:
 		result = leftVal >= rightVal
 	default:
-		return fmt.Errorf("unknown operator %d for integers", op)
+		return object.NewError(object.GenericError, "unknown operator %d for integers", op)
 	}
 
 	return vm.push(nativeBoolToBooleanObject(result))
@@ -636,32 +985,12 @@ func (vm *VM) execFloatComparison(op code.Opcode, left, right object.Object) err
 	case code.OpGreaterThanOrEqual:
 		result = leftVal >= rightVal
 	default:
-		return fmt.Errorf("unknown operator %d for floats", op)
+		return object.NewError(object.GenericError, "unknown operator %d for floats", op)
 	}
 
 	return vm.push(nativeBoolToBooleanObject(result))
 }
 
-func (vm *VM) execLogicalOp(op code.Opcode) error {
-	right := vm.pop()
-	left := vm.pop()
-
-	switch op {
-	case code.OpAnd:
-		if isTruthy(left) {
-			return vm.push(right)
-		}
-		return vm.push(left)
-	case code.OpOr:
-		if isTruthy(left) {
-			return vm.push(left)
-		}
-		return vm.push(right)
-	default:
-		return fmt.Errorf("unknow logical operator: %d", op)
-	}
-}
-
 func (vm *VM) execCall(numArgs int) error {
 	callee := vm.stack[vm.sp-1-numArgs]
 	switch callee := callee.(type) {
@@ -669,32 +998,85 @@ func (vm *VM) execCall(numArgs int) error {
 		return vm.callClosure(callee, numArgs)
 	case *object.Builtin:
 		return vm.callBuiltin(callee, numArgs)
+	case *object.BoundMethod:
+		return vm.callBoundMethod(callee, numArgs)
 	default:
 		var typ interface{}
 		if callee != nil {
 			typ = callee.Type()
 		}
-		return fmt.Errorf("calling non-function and non-built-in: type %v", typ)
+		return object.NewError(
+			object.TypeError,
+			"calling non-function and non-built-in: type %v", typ,
+		)
 	}
 }
 
 func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
-	if numArgs != cl.Fn.NumParameters {
-		return fmt.Errorf(
-			"wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs,
-		)
+	fn := cl.Fn
+	if numArgs < fn.MinArity || (!fn.Variadic && numArgs > fn.NumParameters) {
+		return wrongNumberOfArguments(fn, numArgs)
 	}
 
 	// Create a new stack frame
 	basePtr := vm.sp - numArgs
+	if vm.framesIdx >= MaxFrames || basePtr+fn.NumLocals >= StackSize {
+		return object.NewError(object.GenericError, "stack overflow")
+	}
 	frame := NewFrame(cl, basePtr)
+	frame.numArgs = numArgs
+
+	// Collect the arguments after the parameters into an array for the rest parameter
+	if fn.Variadic {
+		rest := []object.Object{}
+		if numArgs > fn.NumParameters {
+			rest = append(rest, vm.stack[basePtr+fn.NumParameters:vm.sp]...)
+		}
+		vm.stack[basePtr+fn.NumParameters] = &object.Array{Elements: rest}
+	}
+
+	// Parameters without arguments are nil until their default values are set
+	for i := numArgs; i < fn.NumParameters; i++ {
+		vm.stack[basePtr+i] = Nil
+	}
+
 	vm.pushFrame(frame)
 
-	vm.sp = frame.bp + cl.Fn.NumLocals // Reserve slots for local bindings on the stack
+	vm.sp = frame.bp + fn.NumLocals // Reserve slots for local bindings on the stack
 
 	return nil
 }
 
+// wrongNumberOfArguments returns an error for calling `fn` with `numArgs` arguments.
+func wrongNumberOfArguments(fn *object.CompiledFunction, numArgs int) error {
+	want := strconv.Itoa(fn.NumParameters)
+	if fn.Variadic {
+		want = fmt.Sprintf("at least %d", fn.MinArity)
+	} else if fn.MinArity < fn.NumParameters {
+		want = fmt.Sprintf("%d to %d", fn.MinArity, fn.NumParameters)
+	}
+
+	return object.NewError(
+		object.ArgumentError,
+		"wrong number of arguments: want=%s, got=%d", want, numArgs,
+	)
+}
+
+// callBoundMethod calls the method of `bm` with its receiver inserted before the arguments.
+func (vm *VM) callBoundMethod(bm *object.BoundMethod, numArgs int) error {
+	// Make room for the receiver by shifting the arguments up
+	if err := vm.push(nil); err != nil {
+		return err
+	}
+	calleeIdx := vm.sp - 2 - numArgs
+	copy(vm.stack[calleeIdx+2:vm.sp], vm.stack[calleeIdx+1:vm.sp-1])
+
+	vm.stack[calleeIdx] = bm.Method
+	vm.stack[calleeIdx+1] = bm.Receiver
+
+	return vm.execCall(numArgs + 1)
+}
+
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
 
@@ -703,10 +1085,15 @@ func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	// Take the arguments and the function we just executed off the stack
 	vm.sp -= (numArgs + 1)
 
-	if result == nil {
+	switch result := result.(type) {
+	case nil:
 		return vm.push(Nil)
+	case *object.Error:
+		// A built-in function fails by returning an error, which is raised as an exception
+		return result
+	default:
+		return vm.push(result)
 	}
-	return vm.push(result)
 }
 
 func (vm *VM) pushClosure(constIdx int, numFree int) error {
@@ -714,7 +1101,7 @@ func (vm *VM) pushClosure(constIdx int, numFree int) error {
 	c := vm.consts[constIdx]
 	fn, ok := c.(*object.CompiledFunction)
 	if !ok {
-		return fmt.Errorf("not a function: %+v", c)
+		return object.NewError(object.TypeError, "not a function: %+v", c)
 	}
 
 	// Fetch free variables
@@ -722,11 +1109,141 @@ func (vm *VM) pushClosure(constIdx int, numFree int) error {
 	copy(free, vm.stack[vm.sp-numFree:vm.sp])
 	vm.sp -= numFree
 
+	// A function referred to by its name is not a variable and is captured by its value
+	for i, v := range free {
+		if _, ok := v.(*object.Cell); !ok {
+			free[i] = &object.Cell{Value: v}
+		}
+	}
+
 	// Create a closure and push it on to the stack
 	closure := &object.Closure{Fn: fn, Free: free}
 	return vm.push(closure)
 }
 
+// execIterNext advances the iterator on top of the stack, leaving the iterator in place, and
+// pushes the key and the value of the next element, or the element only if `numVars` is 1.
+// It pops the iterator and reports false if the iterator has no more elements.
+func (vm *VM) execIterNext(numVars int) (bool, error) {
+	iter := vm.stack[vm.sp-1].(*object.Iterator)
+
+	if numVars == 1 {
+		elem, ok := iter.NextElement()
+		if !ok {
+			vm.pop()
+			return false, nil
+		}
+		return true, vm.push(elem)
+	}
+
+	key, value, ok := iter.Next()
+	if !ok {
+		vm.pop()
+		return false, nil
+	}
+	if err := vm.push(key); err != nil {
+		return false, err
+	}
+	return true, vm.push(value)
+}
+
+// execGetMethod replaces the object on top of the stack with its member `name`. A function
+// which is a member of a hash is bound to the hash, so that it is called as a method.
+func (vm *VM) execGetMethod(name object.Object) error {
+	obj := vm.pop()
+	if err := vm.execGetIndexExpr(obj, name); err != nil {
+		return err
+	}
+
+	if obj.Type() != object.HashType {
+		return nil
+	}
+
+	switch method := vm.stack[vm.sp-1].(type) {
+	case *object.Closure, *object.Builtin:
+		vm.stack[vm.sp-1] = &object.BoundMethod{Receiver: obj, Method: method}
+	}
+
+	return nil
+}
+
+// execExtendArray pops an array and appends its elements to the array on top of the stack,
+// which must be created for the array literal being built.
+func (vm *VM) execExtendArray() error {
+	value := vm.pop()
+	elems, ok := value.(*object.Array)
+	if !ok {
+		return object.NewError(object.TypeError, "cannot spread %s", value.Type())
+	}
+
+	arr := vm.stack[vm.sp-1].(*object.Array)
+	arr.Elements = append(arr.Elements, elems.Elements...)
+
+	return nil
+}
+
+// execUnpackArray replaces the array on top of the stack with its first `numElems` elements in
+// reverse order, so that they are popped in order. Missing elements are nil. If `hasRest` is
+// true, an array of the remaining elements is pushed before them.
+func (vm *VM) execUnpackArray(numElems int, hasRest bool) error {
+	value := vm.pop()
+	arr, ok := value.(*object.Array)
+	if !ok {
+		return object.NewError(object.TypeError, "cannot destructure %s as an array", value.Type())
+	}
+
+	if hasRest {
+		rest := []object.Object{}
+		if len(arr.Elements) > numElems {
+			rest = append(rest, arr.Elements[numElems:]...)
+		}
+		if err := vm.push(&object.Array{Elements: rest}); err != nil {
+			return err
+		}
+	}
+
+	for i := numElems - 1; i >= 0; i-- {
+		var elem object.Object = Nil
+		if i < len(arr.Elements) {
+			elem = arr.Elements[i]
+		}
+		if err := vm.push(elem); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// execUnpackHash replaces the hash and the `numKeys` keys above it on the stack with the values
+// of the keys in reverse order, so that they are popped in order. Missing keys are nil.
+func (vm *VM) execUnpackHash(numKeys int) error {
+	keys := make([]object.Object, numKeys)
+	copy(keys, vm.stack[vm.sp-numKeys:vm.sp])
+	vm.sp -= numKeys
+
+	value := vm.pop()
+	if _, ok := value.(*object.Hash); !ok {
+		return object.NewError(object.TypeError, "cannot destructure %s as a hash", value.Type())
+	}
+
+	for i := numKeys - 1; i >= 0; i-- {
+		if err := vm.execHashGetIndex(value, keys[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// toString converts `obj` to a string object using its string representation.
+func toString(obj object.Object) *object.String {
+	if s, ok := obj.(*object.String); ok {
+		return s
+	}
+	return &object.String{Value: obj.Inspect()}
+}
+
 func castToFloat(obj object.Object) (float64, error) {
 	switch obj := obj.(type) {
 	case *object.Integer:
@@ -734,13 +1251,25 @@ func castToFloat(obj object.Object) (float64, error) {
 	case *object.Float:
 		return obj.Value, nil
 	default:
-		return 0.0, fmt.Errorf("could not cast to float: %s", obj.Type())
+		return 0.0, object.NewError(object.TypeError, "could not cast to float: %s", obj.Type())
 	}
 }
 
 func isFloatArithmeticRequired(op code.Opcode, left, right object.Object) bool {
-	// Division always returns a floating-point number
-	return op == code.OpDiv || isEitherType(object.FloatType, left, right)
+	switch op {
+	case code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight:
+		// Bitwise operators are only defined for integers
+		return false
+	case code.OpDiv:
+		// Division always returns a floating-point number
+		return true
+	case code.OpPow:
+		// An integer to the power of a negative integer is a fraction
+		if object.IsNegativeInteger(right) {
+			return true
+		}
+	}
+	return isEitherType(object.FloatType, left, right)
 }
 
 func isBothType(typ object.Type, left, right object.Object) bool {
@@ -6,6 +6,7 @@ import (
 
 	"monkey-compiler/ast"
 	"monkey-compiler/compiler"
+	"monkey-compiler/eval"
 	"monkey-compiler/lexer"
 	"monkey-compiler/object"
 	"monkey-compiler/parser"
@@ -25,6 +26,8 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"1 * 2", 2},
 		{"50 * 2 * 2 + 10 - 5", 205},
 		{"5 + 5 + 5 + 5 - 10", 10},
+		{"-9223372036854775808", -9223372036854775808},
+		{"-9223372036854775808 + 1", -9223372036854775807},
 		{"2 * 2 * 2 * 2 * 2", 32},
 		{"5 * 2 + 10", 20},
 		{"5 + 2 * 10", 25},
@@ -33,9 +36,108 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"-10", -10},
 		{"-50 + 100 + -50", 0},
 		{"(5 + 10 * 2 + 15 - 3) * 2 + -10", 64},
+		{"0xFF + 0o17 + 0b1010 + 1_000", 1280},
 	}
 
 	runVMTests(t, tests)
+	runVMTestsAgainstEval(t, tests)
+}
+
+func TestIntegerOperators(t *testing.T) {
+	tests := []vmTestCase{
+		{"7 // 2", 3},
+		{"-7 // 2", -4},
+		{"7 // -2", -4},
+		{"6 // 3", 2},
+		{"7 % 3", 1},
+		{"-7 % 3", 2},
+		{"7 % -3", -2},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"(-2) ** 3", -8},
+		{"5 ** 0", 1},
+		{"2 * 3 ** 2", 18},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"~5", -6},
+		{"1 << 4", 16},
+		{"-16 >> 2", -4},
+		{"1 << 64", 0},
+		{"1 | 2 ^ 3 & 4", 3},
+		{"1 + 2 << 3", 24},
+		{"10 % 4 * 3", 6},
+		{
+			input: `
+			let out = [];
+			for (let i = 1; i <= 15; i++) {
+				if (i % 15 == 0) {
+					out = push(out, -15);
+				} else {
+					if (i % 3 == 0) {
+						out = push(out, -3);
+					} else {
+						if (i % 5 == 0) { out = push(out, -5) } else { out = push(out, i) }
+					}
+				}
+			}
+			out;
+			`,
+			want: []int{1, 2, -3, 4, -5, -3, 7, 8, -3, -5, 11, -3, 13, 14, -15},
+		},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestFloatOperators(t *testing.T) {
+	tests := []vmTestCase{
+		{"7.5 // 2", 3.0},
+		{"-7.5 // 2", -4.0},
+		{"7.5 % 2", 1.5},
+		{"-7.5 % 2", 0.5},
+		{"7 % 2.5", 2.0},
+		{"2 ** 0.5 * 2 ** 0.5", 2.0000000000000004},
+		{"2.0 ** 3", 8.0},
+		{"2 ** -1", 0.5},
+		{"10 ** -2", 0.01},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestArithmeticErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"1 // 0", "1:3: integer division by zero"},
+		{"let x = 0; 5 % x", "1:14: integer modulo by zero"},
+		{"1 << -1", "1:3: negative shift count: -1"},
+		{`~"a"`, "1:1: unsupported type for bitwise not: String"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		complr := compiler.New()
+		if err := complr.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(complr.Bytecode())
+		err := vm.Run()
+		if err == nil {
+			t.Fatalf("%s - expected vm error, but got nil", tt.input)
+		}
+
+		if err.Error() != tt.want {
+			t.Errorf("%s - wrong error. want=%q, got=%q", tt.input, tt.want, err)
+		}
+	}
+
+	runVMTestErrors(t, []string{"1.5 & 1", "1 | 2.5", "1.0 << 1", `"a" % 2`})
 }
 
 func TestFloatArithmetic(t *testing.T) {
@@ -65,6 +167,7 @@ func TestFloatArithmetic(t *testing.T) {
 		{"-10.0", -10.0},
 		{"-50.5 + 101 + -50.5", 0.0},
 		{"(5.5 + 10 * 2.2 + 15 / 3) * 2.2 + -10", 61.5},
+		{"1.5e3 + 2.5E-1", 1500.25},
 	}
 
 	runVMTests(t, tests)
@@ -160,9 +263,50 @@ func TestLogicalExpressions(t *testing.T) {
 		{"true || 1", true},
 		{"true && false", false},
 		{"false || true", true},
+		{"nil && 1", Nil},
+		{"nil || false", false},
+		{"1 && 2 && 3", 3},
+		{"false || nil || 3", 3},
+		{"1 && false || 4", 4},
+		{"let xs = nil; xs != nil && xs[0] > 1", false},
+		{"let xs = [5]; xs != nil && xs[0] > 1", true},
+		{"let f = fn(x) { x > 0 || x % 0 }; f(1)", true},
+		{
+			// The right operands with side effects are not evaluated
+			input: `
+			let calls = [0];
+			let touch = fn(v) { calls[0]++; v };
+			false && touch(1);
+			true || touch(2);
+			nil && touch(3);
+			true && touch(4);
+			false || touch(5);
+			calls[0];
+			`,
+			want: 2,
+		},
+		{"nil ?? 1", 1},
+		{"false ?? 1", false},
+		{"0 ?? 1", 0},
+		{"nil ?? nil ?? 3", 3},
+		{`let h = {}; h["missing"] ?? "default"`, "default"},
+		{"nil ?? 1 == 1", true},
+		{"nil ?? false || true", true},
+		{
+			// The right operand is evaluated only if the left one is nil
+			input: `
+			let calls = [0];
+			let touch = fn(v) { calls[0]++; v };
+			1 ?? touch(1);
+			nil ?? touch(2);
+			calls[0];
+			`,
+			want: 1,
+		},
 	}
 
 	runVMTests(t, tests)
+	runVMTestsAgainstEval(t, tests)
 }
 
 func TestConditionals(t *testing.T) {
@@ -180,9 +324,247 @@ func TestConditionals(t *testing.T) {
 		{"if (1 >= 2) { 10 } else { 20 }", 20},
 		{"if (1 >= 2) { 10 }", Nil},
 		{"if (false) { 10 }", Nil},
+		{"if (true) { }", Nil},
+		{"if (true) { let a = 1; }", Nil},
+		{"let x = 1; if (true) { x = 2 } else { 3 }", Nil},
+		{"let x = 1; [if (true) { x = 2 } else { 3 }, x]; x", 2},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let i = 0;
+			let sum = 0;
+			while (i < 10) {
+				i = i + 1;
+				sum = sum + i;
+			}
+			sum;
+			`,
+			want: 55,
+		},
+		{
+			input: `
+			let sum = 0;
+			for (let i = 0; i < 10; i = i + 1) {
+				if (i == 3) { continue; }
+				if (i == 6) { break; }
+				sum = sum + i;
+			}
+			sum;
+			`,
+			want: 12,
+		},
+		{
+			input: `
+			let n = 0;
+			for (;;) {
+				n = n + 1;
+				if (n >= 5) { break }
+			}
+			n;
+			`,
+			want: 5,
+		},
+		{
+			input: `
+			let count = 0;
+			for (let i = 0; i < 3; i = i + 1) {
+				for (let j = 0; j < 10; j = j + 1) {
+					if (j == 2) { break }
+					count = count + 1;
+				}
+			}
+			count;
+			`,
+			want: 6,
+		},
+		{
+			input: `
+			let i = 0;
+			let odd = [];
+			while (i < 6) {
+				i = i + 1;
+				if (i == 2 || i == 4 || i == 6) { continue }
+				odd = push(odd, i);
+			}
+			odd;
+			`,
+			want: []int{1, 3, 5},
+		},
+		{
+			input: `
+			let find = fn(xs, x) {
+				for (let i = 0; i < len(xs); i = i + 1) {
+					if (xs[i] == x) { return i; }
+				}
+				-1;
+			};
+			[find([5, 6, 7], 7), find([5, 6, 7], 8)];
+			`,
+			want: []int{2, -1},
+		},
+		{
+			input: `
+			let countdown = fn(n) {
+				let steps = 0;
+				while (n > 0) {
+					n = n - 1;
+					steps = steps + 1;
+				}
+				steps;
+			};
+			countdown(100000);
+			`,
+			want: 100000,
+		},
+		{
+			input: `
+			let total = 0;
+			for (let i = 0; i < 3; i = i + 1) {
+				let add = fn(x) { x * 10 };
+				total = total + add(i);
+			}
+			total;
+			`,
+			want: 30,
+		},
+		{
+			input: `
+			let x = 0;
+			while (false) { x = 1; }
+			x;
+			`,
+			want: 0,
+		},
+		// A finished loop statement has no value
+		{"let i = 0; while (i < 3) { i += 1 }", Nil},
+		{"for (let i = 0; i < 3; i += 1) { i }", Nil},
+		{"while (true) { break }", Nil},
+		{"let f = fn() { let i = 0; while (i < 3) { i += 1 } }; f()", Nil},
+	}
+
+	runVMTests(t, tests)
+	runVMTestsAgainstEval(t, tests)
+}
+
+func TestForInLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let sum = 0; for (x in [1, 2, 3, 4]) { sum = sum + x; } sum", 10},
+		{"let sum = 0; for (i, x in [10, 20, 30]) { sum = sum + i * x; } sum", 80},
+		{`let sum = 0; for (k, v in {"a": 1, "b": 2, "c": 3}) { sum = sum + v; } sum`, 6},
+		{`let keys = ""; for (k in {"a": 1}) { keys = keys + k; } keys`, "a"},
+		{`let out = ""; for (c in "añ😀") { out = c + out; } out`, "😀ña"},
+		{`let n = 0; for (c in "añ😀") { n = n + 1; } n`, 3},
+		{`let last = -1; for (i, c in "héllo") { last = i; } last`, 4},
+		{"let n = 0; for (x in []) { n = n + 1; } n", 0},
+		{"let f = fn(xs) { for (x in xs) { x } }; f([1, 2])", Nil},
+		{"let f = fn(xs) { for (x in xs) { break } }; f([1, 2])", Nil},
+		{"if (true) { for (x in [1]) { } }", Nil},
+		{"let xs = [1, 2]; let n = 0; while (n < 3) { for (x in xs) { if (x == 2) { break } } n = n + 1 } n", 3},
+		{
+			input: `
+			let sum = 0;
+			for (x in [1, 2, 3, 4, 5, 6]) {
+				if (x == 2) { continue; }
+				if (x == 5) { break; }
+				sum = sum + x;
+			}
+			sum;
+			`,
+			want: 8,
+		},
+		{
+			input: `
+			let pairs = 0;
+			for (x in [1, 2, 3]) {
+				for (y in [1, 2, 3]) {
+					if (y > x) { break }
+					pairs = pairs + 1;
+				}
+			}
+			pairs;
+			`,
+			want: 6,
+		},
+		{
+			input: `
+			let indexOf = fn(xs, want) {
+				for (i, x in xs) {
+					if (x == want) { return i; }
+				}
+				-1;
+			};
+			indexOf([5, 6, 7], 7) * 10 + indexOf([5], 9);
+			`,
+			want: 19,
+		},
+		{
+			input: `
+			let squares = fn(xs) {
+				let out = [];
+				for (x in xs) { out = push(out, x * x); }
+				out;
+			};
+			squares([1, 2, 3]);
+			`,
+			want: []int{1, 4, 9},
+		},
+		{
+			input: `
+			let xs = [1, 2, 3];
+			let sum = 0;
+			for (i, x in xs) {
+				if (i < 2) { xs[i + 1] = xs[i + 1] * 10; }
+				sum = sum + x;
+			}
+			sum;
+			`,
+			want: 51,
+		},
+		// A finished loop statement has no value, rather than its iterator
+		{"for (x in [1, 2]) {}", Nil},
+		{`for (k, v in {"a": 1}) { break }`, Nil},
+		// Each iteration binds fresh loop variables, even at the top level
+		{"let fs = []; for (x in [1, 2, 3]) { fs = push(fs, fn() { x }) }; [fs[0](), fs[1](), fs[2]()]", []int{1, 2, 3}},
+		{"let fs = []; for (i, x in [5, 6]) { fs = push(fs, fn() { i * 10 + x }) }; [fs[0](), fs[1]()]", []int{5, 16}},
 	}
 
 	runVMTests(t, tests)
+	runVMTestsAgainstEval(t, tests)
+}
+
+func TestForInLoopErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"for (x in 5) { }", "1:1: Integer is not iterable"},
+		{"let f = fn() { for (x in nil) { } }; f()", "1:16: Nil is not iterable"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		complr := compiler.New()
+		if err := complr.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(complr.Bytecode())
+		err := vm.Run()
+		if err == nil {
+			t.Fatalf("%s - expected vm error, but got nil", tt.input)
+		}
+
+		if err.Error() != tt.want {
+			t.Errorf("%s - wrong error. want=%q, got=%q", tt.input, tt.want, err)
+		}
+	}
 }
 
 func TestGlobalLetStatements(t *testing.T) {
@@ -190,6 +572,8 @@ func TestGlobalLetStatements(t *testing.T) {
 		{"let one = 1; one", 1},
 		{"let one = 1; let two = 2; one + two", 3},
 		{"let one = 1; let two = one + one; one + two", 3},
+		{"let größe = 2; let 数量 = 3; größe * 数量", 6},
+		{"let x1 = 1; let x2 = 2; x1 + x2", 3},
 	}
 
 	runVMTests(t, tests)
@@ -240,7 +624,7 @@ func TestAssignmentStatementScopes(t *testing.T) {
 			fn() { num = 66 }();
 			num;
 			`,
-			want: 55,
+			want: 66,
 		},
 		{
 			input: `
@@ -265,7 +649,7 @@ func TestAssignmentStatementScopes(t *testing.T) {
 				a + b;
 			}();
 			`,
-			want: 121,
+			want: 165,
 		},
 	}
 
@@ -297,7 +681,7 @@ func TestShadowingBuiltinFunctions(t *testing.T) {
 			}();
 			len + push;
 			`,
-			want: 3,
+			want: 4,
 		},
 	}
 
@@ -309,6 +693,13 @@ func TestStringExpressions(t *testing.T) {
 		{`"monkey"`, "monkey"},
 		{`"mon" + "key"`, "monkey"},
 		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`"tab\tand \"quote\""`, "tab\tand \"quote\""},
+		{`"\u{1F600}" + "\x21"`, "\U0001F600!"},
+		{`let name = "Bob"; let age = 30; "Hello ${name}, you are ${age}"`, "Hello Bob, you are 30"},
+		{`"${1.5} ${true} ${nil} ${[1, "a"]} ${len("abc") * 2}"`, "1.5 true nil [1, a] 6"},
+		{`let f = fn(x) { "<${x}>" }; "${f("a")}${f(1)}"`, "<a><1>"},
+		{`"price: \${x}"`, "price: ${x}"},
+		{"`raw \\n ${x}\n`", "raw \\n ${x}\n"},
 	}
 
 	runVMTests(t, tests)
@@ -380,6 +771,115 @@ func TestSetIndexExpressions(t *testing.T) {
 	runVMTests(t, tests)
 }
 
+func TestCompoundAssignments(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 10; x += 5; x", 15},
+		{"let x = 10; x -= 5; x", 5},
+		{"let x = 10; x *= 5; x", 50},
+		{"let x = 10; x /= 4; x", 2.5},
+		{"let x = 10; x %= 4; x", 2},
+		{"let x = 7.5; x %= 2; x", 1.5},
+		{"let x = 1; x++; x++; x", 3},
+		{"let x = 1; x--; x", 0},
+		{`let s = "a"; s += "b"; s`, "ab"},
+		{"let xs = [1, 2, 3]; xs[1] += 10; xs", []int{1, 12, 3}},
+		{"let xs = [1, 2, 3]; xs[2]++; xs[0]--; xs", []int{0, 2, 4}},
+		{
+			input: `let counts = {"a": 0, "b": 0}; counts["a"] += 1; counts["a"] += 1; counts["b"]++; counts`,
+			want: map[object.HashKey]int64{
+				(&object.String{Value: "a"}).HashKey(): 2,
+				(&object.String{Value: "b"}).HashKey(): 1,
+			},
+		},
+		{"let f = fn(n) { n *= 2; n += 1; n }; f(5)", 11},
+		{"let sum = 0; for (let i = 0; i < 5; i++) { sum += i; } sum", 10},
+		{
+			// The left-hand side is evaluated only once
+			input: `
+			let calls = [0];
+			let xs = [10, 20];
+			let pick = fn() { calls[0]++; xs };
+			let idx = fn() { calls[0] += 10; 1 };
+			pick()[idx()] += 5;
+			[calls[0], xs[1]];
+			`,
+			want: []int{11, 25},
+		},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []vmTestCase{
+		{"let [a, b] = [1, 2]; a * 10 + b", 12},
+		{"let [a, b, ...rest] = [1, 2, 3, 4]; rest", []int{3, 4}},
+		{"let [a, ...rest] = [1]; rest", []int{}},
+		{"let [a, b, c] = [1, 2]; c", Nil},
+		{"let [a, [b, c]] = [1, [2, 3]]; [a, b, c]", []int{1, 2, 3}},
+		{`let {name, age} = {"name": "Monkey", "age": 5}; age`, 5},
+		{`let {name, age} = {"name": "Monkey"}; age`, Nil},
+		{`let [{x}, {y}] = [{"x": 1}, {"y": 2}]; x + y`, 3},
+		{"let a = 1; let b = 2; [a, b] = [b, a]; [a, b]", []int{2, 1}},
+		{`let x = 0; {x} = {"x": 7}; x`, 7},
+		{"let [a, b] = [1, 2]; let [a, b] = [b, a]; a * 10 + b", 21},
+		{"let f = fn([a, b]) { a - b }; f([5, 3])", 2},
+		{`let f = fn(n, {x, y}) { n * (x + y) }; f(2, {"x": 1, "y": 2})`, 6},
+		{"let f = fn([first, ...rest]) { rest }; f([1, 2, 3])", []int{2, 3}},
+		{
+			input: `
+			let f = fn(xs) {
+				let [a, b] = xs;
+				[b, a] = [a, b];
+				a * 10 + b
+			};
+			f([1, 2])
+			`,
+			want: 21,
+		},
+		{
+			// Variables bound by a pattern can be captured by closures
+			input: `
+			let f = fn([a, b]) { fn() { a + b } };
+			f([1, 2])()
+			`,
+			want: 3,
+		},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestDestructuringErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let [a, b] = 1;", "1:1: cannot destructure Integer as an array"},
+		{"let {a} = [1];", "1:1: cannot destructure Array as a hash"},
+		{"let f = fn([a]) { a }; f(nil)", "1:9: cannot destructure Nil as an array"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		complr := compiler.New()
+		if err := complr.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(complr.Bytecode())
+		err := vm.Run()
+		if err == nil {
+			t.Fatalf("%s - expected vm error, but got nil", tt.input)
+		}
+
+		if err.Error() != tt.want {
+			t.Errorf("%s - wrong error. want=%q, got=%q", tt.input, tt.want, err)
+		}
+	}
+}
+
 func TestSetIndexExpressionErrors(t *testing.T) {
 	tests := []string{
 		"a = []; a[1] = 1",
@@ -397,7 +897,13 @@ func TestGetIndexExpressions(t *testing.T) {
 		{"[[1, 1, 1]][0][0]", 1},
 		{"[][0]", Nil},
 		{"[1, 2, 3][99]", Nil},
-		{"[1][-1]", Nil},
+		{"[1][-1]", 1},
+		{"[1, 2, 3][-2]", 2},
+		{"[1][-2]", Nil},
+		{`"monkey"[0]`, "m"},
+		{`"monkey"[-1]`, "y"},
+		{`"héllo"[1]`, "é"},
+		{`"monkey"[6]`, Nil},
 		{"{1: 1, 2: 2}[1]", 1},
 		{"{1: 1, 2: 2}[2]", 2},
 		{"{1: 1}[0]", Nil},
@@ -407,68 +913,348 @@ func TestGetIndexExpressions(t *testing.T) {
 	runVMTests(t, tests)
 }
 
-func TestCallingFunctionsWithoutArguments(t *testing.T) {
+func TestSliceExpressions(t *testing.T) {
 	tests := []vmTestCase{
-		{
-			input: `
-			let fivePlusTen = fn() { 5 + 10; };
-			fivePlusTen();
-			`,
-			want: 15,
-		},
-		{
-			input: `
-			let one = fn() { 1; };
-			let two = fn() { 2; };
-			one() + two();
-			`,
-			want: 3,
-		},
-		{
-			input: `
-			let a = fn() { 1; };
-			let b = fn() { a() + 1; };
-			let c = fn() { b() + 1; };
-			c();
-			`,
-			want: 3,
-		},
+		{"[1, 2, 3, 4][1:3]", []int{2, 3}},
+		{"[1, 2, 3, 4][:-1]", []int{1, 2, 3}},
+		{"[1, 2, 3, 4][2:]", []int{3, 4}},
+		{"[1, 2, 3, 4][:]", []int{1, 2, 3, 4}},
+		{"[1, 2, 3, 4][-2:]", []int{3, 4}},
+		{"[1, 2, 3, 4][3:1]", []int{}},
+		{"[1, 2, 3, 4][-10:10]", []int{1, 2, 3, 4}},
+		{"let i = 1; [1, 2, 3, 4][i:i + 2]", []int{2, 3}},
+		{"let xs = [1, 2]; let ys = xs[:]; ys[0] = 9; xs", []int{1, 2}},
+		{`"monkey"[2:]`, "nkey"},
+		{`"monkey"[:-3]`, "mon"},
+		{`"monkey"[1:1]`, ""},
+		{`"héllo"[1:3]`, "él"},
+		{"let xs = [1, 2, 3]; xs[-1] = 9; xs", []int{1, 2, 9}},
 	}
 
 	runVMTests(t, tests)
+
+	runVMTestErrors(t, []string{
+		"{}[1:2]",
+		`[1, 2]["a":]`,
+		"let xs = [1]; xs[-2] = 0",
+	})
 }
 
-func TestFunctionsWithReturnStatements(t *testing.T) {
+func TestMemberExpressions(t *testing.T) {
 	tests := []vmTestCase{
+		{`let p = {"name": "Thorsten"}; p.name`, "Thorsten"},
+		{`let p = {"address": {"city": "Berlin"}}; p.address.city`, "Berlin"},
+		{`let p = {}; p.name`, Nil},
+		{`let p = {}; p.age = 30; p["age"]`, 30},
+		{`let p = {"age": 30}; p.age += 1; p.age++; p.age`, 32},
+		{`let p = {"address": {}}; p.address.city = "Berlin"; p["address"]["city"]`, "Berlin"},
+		{`let p = {"age": 30, "older": fn(self, n) { self.age + n }}; p.older(2)`, 32},
+		{`let p = {"age": 30, "birthday": fn(self) { self.age++ }}; p.birthday(); p.birthday(); p.age`, 32},
+		{`let p = {"add": fn(a, b) { a + b }}; let add = p.add; add(1, 2)`, 3},
+		{`fn() { let p = {"count": len}; try { p.count() } catch (e) { return e.message } }()`, "argument to `len` not supported, got Hash"},
+		{`let p = {"sum": fn(self, ...xs) { len(xs) }}; p.sum(...[1, 2, 3])`, 3},
+		{`let p = {"get": fn(self, k) { self[k] }, "x": 1}; let q = {"p": p}; q.p.get("x")`, 1},
+		{`let m = {"inner": {"f": fn(self) { self.v }, "v": 7}}; m.inner.f()`, 7},
+		{`fn() { let p = {"n": 1, "inc": fn(self) { self.n += 1 }}; p.inc(); p.n }()`, 2},
+	}
+
+	runVMTests(t, tests)
+
+	runVMTestErrors(t, []string{
+		"let x = 1; x.name",
+		"let xs = [1]; xs.first = 1",
+		"let p = {}; p.missing()",
+	})
+}
+
+func TestOptionalChaining(t *testing.T) {
+	tests := []vmTestCase{
+		{"let p = nil; p?.name", Nil},
+		{`let p = {"name": "Thorsten"}; p?.name`, "Thorsten"},
+		{`let p = {}; p.address?.city`, Nil},
+		{`let p = {"address": {"city": "Berlin"}}; p.address?.city`, "Berlin"},
+		{"let xs = nil; xs?[0]", Nil},
+		{"let xs = [1, 2]; xs?[1]", 2},
+		{"let xs = nil; xs?[1:]", Nil},
+		{"let xs = [1, 2, 3]; xs?[1:]", []int{2, 3}},
+		// The rest of the chain is skipped
+		{"let p = nil; p?.address.city", Nil},
+		{"let p = nil; p?.items[0][1]", Nil},
+		{"let p = nil; p?.greet()", Nil},
+		{`let p = {"greet": fn(self, x) { x }}; p?.greet(2)`, 2},
+		{`let p = {"f": nil}; p.f?.g.h`, Nil},
+		{"let p = nil; p?.f(1)?.g ?? 3", 3},
+		{`let p = {"n": 1}; p?.n + 1`, 2},
+		{"nil?.n", Nil},
+		{"let p = nil; len([p?.name, 1])", 2},
+		{"let f = fn(p) { p?.name ?? \"anonymous\" }; f(nil)", "anonymous"},
+		// Parentheses end the chain inside them
+		{"let a = nil; (a?.b)?.c", Nil},
+		{"let a = nil; (a?.b) ?? 1", 1},
+		{`let a = {"b": {"c": 1}}; (a?.b).c`, 1},
+		{`let a = {"b": fn(self) { [2] }}; (a?.b)()[0]`, 2},
 		{
+			// The arguments of a skipped call are not evaluated
 			input: `
-			let earlyExit = fn() { return 99; 100; };
-			earlyExit();
-			`,
-			want: 99,
-		},
-		{
-			input: `
-			let earlyExit = fn() { return 99; return 100; };
-			earlyExit();
+			let calls = [0];
+			let touch = fn(v) { calls[0]++; v };
+			let p = nil;
+			p?.f(touch(1));
+			p?[touch(2)];
+			calls[0];
 			`,
-			want: 99,
+			want: 0,
 		},
 	}
 
 	runVMTests(t, tests)
+	runVMTestsAgainstEval(t, tests)
+
+	runVMTestErrors(t, []string{
+		"let x = 1; x?.name",
+		"let p = nil; p?.a; p.a",
+		"let p = {}; p?.a.b",
+		"let a = nil; (a?.b).c",
+		"let a = nil; (a?[0])[1]",
+		"let a = nil; (a?[1:])[0]",
+		"let a = nil; (a?.f()).g",
+		"let a = nil; (a?.f)()",
+		"let a = nil; (a?.b).c()",
+	})
 }
 
-func TestFunctionsWithoutReturnValue(t *testing.T) {
+func TestImports(t *testing.T) {
 	tests := []vmTestCase{
+		{`import "testdata/counter.monkey" as counter; counter.next(); counter.next()`, 2},
+		{`import "testdata/counter.monkey" as counter; counter.state.loads`, 1},
+		{`import "testdata/counter.monkey" as a; import "testdata/counter.monkey" as b; a.next(); b.next()`, 2},
+		{`import "testdata/lib/greeter.monkey" as greeter; greeter.greet("Monkey")`, "Hello, Monkey"},
 		{
-			input: `
-			let noReturn = fn() { };
-			noReturn();
-			`,
-			want: Nil,
+			`import "testdata/lib/greeter.monkey" as greeter; import "testdata/counter.monkey" as counter;
+			greeter.greet("Monkey"); counter.next() * 10 + counter.state.loads`,
+			21,
 		},
-		{
+		{`import "testdata/private.monkey" as p; p.reveal()`, 42},
+		{`import "testdata/private.monkey" as p; p["reveal"]()`, 42},
+		{`import "testdata/private.monkey" as p; let secret = 1; p.reveal() + secret`, 43},
+		{`import "testdata/private.monkey" as p; let f = fn() { p.reveal() }; f()`, 42},
+		// The members of a module are its bindings, not copies of their values
+		{`import "testdata/live.monkey" as live; live.bump(); live.bump(); live.counter`, 2},
+		{`import "testdata/live.monkey" as live; let f = fn() { live.counter }; live.bump(); f()`, 1},
+		// The blocks at the top level of a module bind their variables anew on each pass
+		{`import "testdata/blocks.monkey" as b; if (true) { let k = 5; [b.getters[0](), b.getters[2](), k] }`, []int{0, 20, 5}},
+	}
+
+	runVMTests(t, tests)
+
+	errTests := []struct {
+		input string
+		want  string
+	}{
+		{`import "testdata/private.monkey" as p; p.secret`, "1:41: module testdata/private.monkey does not export secret"},
+		{`import "testdata/private.monkey" as p; p.reveal = 1`, "1:49: index operator not supported: Module"},
+	}
+
+	for _, tt := range errTests {
+		program := parse(tt.input)
+
+		complr := compiler.New()
+		if err := complr.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(complr.Bytecode())
+		err := vm.Run()
+		if err == nil {
+			t.Fatalf("%s - expected vm error, but got nil", tt.input)
+		}
+
+		if err.Error() != tt.want {
+			t.Errorf("%s - wrong error. want=%q, got=%q", tt.input, tt.want, err)
+		}
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	tests := []vmTestCase{
+		{`fn() { try { throw "boom" } catch (e) { return e } }()`, "boom"},
+		{`fn() { try { throw 1 } catch { return "caught" } }()`, "caught"},
+		{`fn() { try { 1 } catch (e) { return "caught" }; "not caught" }()`, "not caught"},
+		{`fn() { try { 1 % 0 } catch (e) { return e.type } }()`, "ArithmeticError"},
+		{`fn() { try { 1 % 0 } catch (e) { return e.message } }()`, "integer modulo by zero"},
+		{`fn() { try { 1[0] } catch (e) { return e.type } }()`, "TypeError"},
+		{`fn() { try { 1[0] } catch (e) { return e.message } }()`, "index operator not supported: Integer"},
+		{`fn() { try { let a = [1]; a[5] = 0 } catch (e) { return e.type } }()`, "IndexError"},
+		{`fn() { try { fn(x) { x }() } catch (e) { return e.type } }()`, "ArgumentError"},
+		{`fn() { try { 1 + "a" } catch (e) { return e.type } }()`, "TypeError"},
+		{`fn() { try { len(1) } catch (e) { return e.type } }()`, "TypeError"},
+		{`fn() { try { throw {"type": "Custom", "message": "bad"} } catch (e) { return e.type + ": " + e.message } }()`, "Custom: bad"},
+		// Unwinding the frames of the calls between the throw and the catch
+		{
+			`let f = fn(n) { if (n == 0) { throw "bottom" }; 1 + f(n - 1) };
+			fn() { try { f(10) } catch (e) { return e } }()`,
+			"bottom",
+		},
+		// Unwinding the stack with the operands of unfinished expressions
+		{`fn() { let x = 1 + fn() { try { 2 + [3, fn() { throw 4 }()][0] } catch (e) { return e } }(); x }()`, 5},
+		{`fn() { try { try { 1 % 0 } catch (e) { throw e } } catch (e) { return e.type } }()`, "ArithmeticError"},
+		{`fn() { try { try { throw "a" } catch (e) { throw e + "b" } } catch (e) { return e } }()`, "ab"},
+		// Finally blocks
+		{`let log = {"n": 0}; let f = fn() { try { return 1 } finally { log.n += 1 } }; [f(), f(), log.n]`, []int{1, 1, 2}},
+		{`fn() { try { return 1 } finally { return 2 } }()`, 2},
+		{`fn() { try { throw 1 } catch (e) { return e } finally { return 2 } }()`, 2},
+		{
+			`let s = {"v": ""};
+			fn() { try { try { throw "x" } finally { s.v += "f" } } catch (e) { s.v += e } }();
+			s.v`,
+			"fx",
+		},
+		{
+			`let s = {"v": ""};
+			fn() { try { try { throw "a" } catch (e) { throw e + "b" } finally { s.v += "f" } } catch (e) { s.v += e } }();
+			s.v`,
+			"fab",
+		},
+		{`fn() { while (true) { try { throw 1 } finally { break } } "swallowed" }()`, "swallowed"},
+		// Leaving loops through try statements
+		{
+			`let n = {"f": 0}; let i = 0;
+			while (true) { try { i += 1; if (i > 3) { break }; continue } finally { n.f += 1 } }
+			[i, n.f]`,
+			[]int{4, 4},
+		},
+		{`let r = []; for (x in [1, 2, 3]) { try { if (x == 2) { break } } finally { r = push(r, x) } } r`, []int{1, 2}},
+		{`let sum = 0; for (x in [1, 0, 2]) { try { sum += 10 // x } catch (e) { sum += 100 } } sum`, 115},
+		{
+			`fn() { for (x in [1, 2]) { for (y in [3, 4]) { try { return x * y } finally { 5 } } } }()`,
+			3,
+		},
+		// A handler is popped when its function returns
+		{`let f = fn() { try { return 1 } catch (e) { return 2 } }; fn() { f(); try { throw 3 } catch (e) { return e } }()`, 3},
+	}
+
+	runVMTests(t, tests)
+
+	// Running out of stack frames or of the stack is an exception too, in both engines
+	overflowTests := []vmTestCase{
+		{`let f = fn() { f() }; fn() { try { f() } catch (e) { return e.message } }()`, "stack overflow"},
+		{`let f = fn(n) { let a = n; let b = n; f(n + 1) }; fn() { try { f(0) } catch (e) { return e.message } }()`, "stack overflow"},
+		{`let f = fn() { f() }; let r = fn() { try { f() } catch (e) { return 1 } }; r() + r()`, 2},
+		{`let f = fn(n) { f(n + 1) }; try { f(0) } catch (e) { e.message }`, "stack overflow"},
+	}
+
+	runVMTests(t, overflowTests)
+	runVMTestsAgainstEval(t, overflowTests)
+
+	// A try statement has the value of the body, or of the catch clause if it caught an error
+	valueTests := []vmTestCase{
+		{`try { throw "x" } catch (e) { e }`, "x"},
+		{`try { 1 } catch (e) { 2 }`, 1},
+		{`try { 1 } finally { 2 }`, 1},
+		{`try { throw 1 } catch (e) { 3 } finally { 2 }`, 3},
+		{`try { let y = 1 } catch (e) { 2 }`, Nil},
+		{`fn() { try { 5 } finally { 6 } }()`, 5},
+		{`fn() { try { throw 5 } catch (e) { e * 2 } }()`, 10},
+		{`let r = []; for (x in [1, 2, 3]) { try { x } finally { r = push(r, x); continue } } r`, []int{1, 2, 3}},
+		{`let n = 0; while (true) { try { n } finally { break } }`, Nil},
+	}
+
+	runVMTests(t, valueTests)
+	runVMTestsAgainstEval(t, valueTests)
+
+	errTests := []struct {
+		input string
+		want  string
+	}{
+		{`throw "boom"`, "1:1: boom"},
+		{`throw {"type": "Custom", "message": "bad"}`, "1:1: bad"},
+		{`let x = 1; try { x % 0 } catch (e) { throw e }`, "1:38: integer modulo by zero"},
+		{`let f = fn() { try { return 1 } catch (e) { return 2 } }; f(); 1 % 0`, "1:66: integer modulo by zero"},
+		{`let f = fn() { f() }; f()`, "1:17: stack overflow"},
+	}
+
+	for _, tt := range errTests {
+		program := parse(tt.input)
+
+		complr := compiler.New()
+		if err := complr.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(complr.Bytecode())
+		err := vm.Run()
+		if err == nil {
+			t.Fatalf("%s - expected vm error, but got nil", tt.input)
+		}
+
+		if err.Error() != tt.want {
+			t.Errorf("%s - wrong error. want=%q, got=%q", tt.input, tt.want, err)
+		}
+	}
+}
+
+func TestCallingFunctionsWithoutArguments(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let fivePlusTen = fn() { 5 + 10; };
+			fivePlusTen();
+			`,
+			want: 15,
+		},
+		{
+			input: `
+			let one = fn() { 1; };
+			let two = fn() { 2; };
+			one() + two();
+			`,
+			want: 3,
+		},
+		{
+			input: `
+			let a = fn() { 1; };
+			let b = fn() { a() + 1; };
+			let c = fn() { b() + 1; };
+			c();
+			`,
+			want: 3,
+		},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestFunctionsWithReturnStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let earlyExit = fn() { return 99; 100; };
+			earlyExit();
+			`,
+			want: 99,
+		},
+		{
+			input: `
+			let earlyExit = fn() { return 99; return 100; };
+			earlyExit();
+			`,
+			want: 99,
+		},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestFunctionsWithoutReturnValue(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let noReturn = fn() { };
+			noReturn();
+			`,
+			want: Nil,
+		},
+		{
 			input: `
 			let noReturn = fn() { };
 			let noReturnTwo = fn() { noReturn(); };
@@ -630,19 +1416,156 @@ func TestCallingFunctionsWithArgumentsAndBindings(t *testing.T) {
 	runVMTests(t, tests)
 }
 
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn(x, y = 10) { x + y }; f(1)", 11},
+		{"let f = fn(x, y = 10) { x + y }; f(1, 2)", 3},
+		{"let f = fn(x, y = 10) { y }; f(1, nil)", Nil},
+		{"let f = fn(x = 1, y = x + 1) { [x, y] }; f()", []int{1, 2}},
+		{"let f = fn(x = 1, y = x + 1) { [x, y] }; f(5)", []int{5, 6}},
+		{"let f = fn([a, b] = [1, 2]) { a * 10 + b }; f()", 12},
+		{"let f = fn(first, ...rest) { rest }; f(1, 2, 3)", []int{2, 3}},
+		{"let f = fn(first, ...rest) { rest }; f(1)", []int{}},
+		{"let f = fn(...xs) { len(xs) }; f() + f(1, 2, 3)", 3},
+		{"let f = fn(x, y = 2, ...rest) { [x, y, len(rest)] }; f(1)", []int{1, 2, 0}},
+		{"let f = fn(x, y = 2, ...rest) { [x, y, len(rest)] }; f(1, 5, 6, 7)", []int{1, 5, 2}},
+		{
+			// A default value can capture outer variables
+			input: `
+			let step = 3;
+			let next = fn(n, by = step) { n + by };
+			next(1)
+			`,
+			want: 4,
+		},
+		{
+			input: `
+			let sum = fn(...xs) {
+				let total = 0;
+				for (x in xs) { total += x; }
+				total
+			};
+			sum(1, 2, 3, 4)
+			`,
+			want: 10,
+		},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestSpread(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = [1, 2]; let b = [3]; [...a, ...b]", []int{1, 2, 3}},
+		{"let a = [1, 2]; [0, ...a, 3, 4, ...a, 5]", []int{0, 1, 2, 3, 4, 1, 2, 5}},
+		{"[...[]]", []int{}},
+		{"let a = [1, 2]; let b = [...a]; b[0] = 9; a", []int{1, 2}},
+		{"let add = fn(x, y) { x + y }; add(...[1, 2])", 3},
+		{"let add = fn(x, y) { x + y }; add(1, ...[2])", 3},
+		{"let f = fn(...xs) { xs }; f(0, ...[1, 2], 3)", []int{0, 1, 2, 3}},
+		{`len(...["four"])`, 4},
+	}
+
+	runVMTests(t, tests)
+
+	runVMTestErrors(t, []string{"[...1]", "let f = fn(x) { x }; f(...nil)"})
+}
+
+func TestShortFunctionsAndPipes(t *testing.T) {
+	prelude := `
+	let map = fn(xs, f) { let out = []; for (x in xs) { out = push(out, f(x)); } out };
+	let filter = fn(xs, f) { let out = []; for (x in xs) { if (f(x)) { out = push(out, x); } } out };
+	`
+	tests := []vmTestCase{
+		{"let double = |x| x * 2; double(4)", 8},
+		{"let double = x => x * 2; double(4)", 8},
+		{"(|a, b| a - b)(5, 3)", 2},
+		{"(|| 42)()", 42},
+		{"(|x, y = 10| x + y)(1)", 11},
+		{"(|...xs| len(xs))(1, 2, 3)", 3},
+		{"(|[a, b]| a + b)([1, 2])", 3},
+		{"let add = |x| y => x + y; add(1)(2)", 3},
+		{"(|x| { let y = x + 1; y * y })(2)", 9},
+		{"let n = 3; (|x| x + n)(1)", 4},
+		{"4 |> |x| x * x", 16},
+		{"let inc = |x| x + 1; 1 |> inc |> inc", 3},
+		{`"four" |> len`, 4},
+		{"let add = |x, y| x + y; 1 |> add(2)", 3},
+		{"let add = |x| |y| x * 10 + y; 1 |> (add(2))", 21},
+		{prelude + "[1, 2, 3, 4, 5] |> filter(|x| x % 2 == 1) |> map(x => x * 2)", []int{2, 6, 10}},
+		{`let p = {"n": 1, "add": fn(self, x) { self.n + x }}; 2 |> p.add()`, 3},
+		{"[1, 2] |> push(...[3])", []int{1, 2, 3}},
+	}
+
+	runVMTests(t, tests)
+}
+
 func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
 			input: "fn() { 1; }(1);",
-			want:  "wrong number of arguments: want=0, got=1",
+			want:  "1:12: wrong number of arguments: want=0, got=1",
 		},
 		{
 			input: "fn(a) { a; }();",
-			want:  "wrong number of arguments: want=1, got=0",
+			want:  "1:13: wrong number of arguments: want=1, got=0",
 		},
 		{
 			input: "fn(a, b) { a + b; }(1);",
-			want:  "wrong number of arguments: want=2, got=1",
+			want:  "1:20: wrong number of arguments: want=2, got=1",
+		},
+		{
+			input: "fn(a, b = 1) { a + b; }();",
+			want:  "1:24: wrong number of arguments: want=1 to 2, got=0",
+		},
+		{
+			input: "fn(a, b = 1) { a + b; }(1, 2, 3);",
+			want:  "1:24: wrong number of arguments: want=1 to 2, got=3",
+		},
+		{
+			input: "fn(a, ...b) { a; }();",
+			want:  "1:19: wrong number of arguments: want=at least 1, got=0",
+		},
+		{
+			input: "fn(a) { a; }(...[1, 2]);",
+			want:  "1:13: wrong number of arguments: want=1, got=2",
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		c := compiler.New()
+		if err := c.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(c.Bytecode())
+		if err := vm.Run(); err == nil {
+			t.Fatalf("expected VM error but resulted in none")
+		} else if err.Error() != tt.want {
+			t.Fatalf("wrong VM error: want=%q, got=%q", tt.want, err)
+		}
+	}
+}
+
+func TestRuntimeErrorPositions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: "let a = 1;\nlet b = \"two\";\na - b;",
+			want:  "3:3: unsupported types for binary operation 3: Integer and String",
+		},
+		{
+			input: "let f = fn(x) {\n  x * true\n};\nf(1);",
+			want:  "2:5: unsupported types for binary operation 4: Integer and Boolean",
+		},
+		{
+			input: "let a = [1];\n\n  a[5] = 1;",
+			want:  "3:8: array index 5 out of range",
+		},
+		{
+			input: "const config = freeze({\"debug\": false});\nconfig.debug = true;",
+			want:  "2:14: cannot modify frozen Hash",
 		},
 	}
 
@@ -668,24 +1591,66 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
-		{`len(1)`, &object.Error{Message: "argument to `len` not supported, got Integer"}},
-		{`len("one", "two")`, &object.Error{Message: "wrong number of arguments. want=1, got=2"}},
+		{`fn() { try { len(1) } catch (e) { return e.message } }()`, "argument to `len` not supported, got Integer"},
+		{`fn() { try { len("one", "two") } catch (e) { return e.message } }()`, "wrong number of arguments. want=1, got=2"},
 		{`len([1, 2, 3])`, 3},
 		{`len([])`, 0},
 		{`puts("hello", "world!")`, Nil},
 		{`first([1, 2, 3])`, 1},
 		{`first([])`, Nil},
-		{`first(1)`, &object.Error{Message: "argument to `first` must be Array, got Integer"}},
+		{`fn() { try { first(1) } catch (e) { return e.message } }()`, "argument to `first` must be Array, got Integer"},
 		{`rest([1, 2, 3])`, []int{2, 3}},
 		{`rest([])`, Nil},
 		{`push([], 1)`, []int{1}},
-		{`push(1, 1)`, &object.Error{Message: "first argument to `push` must be Array, got Integer"}},
+		{`fn() { try { push(1, 1) } catch (e) { return e.message } }()`, "first argument to `push` must be Array, got Integer"},
 		{`first(rest(push([1, 2, 3], 4)))`, 2},
 	}
 
 	runVMTests(t, tests)
 }
 
+func TestConstantsAndFrozenValues(t *testing.T) {
+	tests := []vmTestCase{
+		{"const LIMIT = 10; LIMIT * 2", 20},
+		{"const x = 1; let y = if (true) { let x = 2; x = 3; x }; x + y", 4},
+		{"let x = 1; const x = 2; x", 2},
+		{"let f = fn() { 1 }; const f = fn() { 2 }; f()", 2},
+		{"const x = 1; let f = fn() { let x = 2; x = 3; x }; f() + x", 4},
+		{"const f = fn() { const n = 5; let g = fn() { n }; g() }; f()", 5},
+		{
+			input: `
+			const isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+			const isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+			isEven(4);
+			`,
+			want: true,
+		},
+		{"let xs = freeze([1, 2]); xs[1]", 2},
+		{"let xs = freeze([1, 2]); push(xs, 3)", []int{1, 2, 3}},
+		{"let ys = push(freeze([1]), 2); ys[0] = 5; ys", []int{5, 2}},
+		{`let h = freeze({"xs": [1]}); h.xs[0] = 2; h.xs`, []int{2}},
+		{
+			`fn() { let xs = freeze([1]); try { xs[0] = 5 } catch (e) { return e.message } }()`,
+			"cannot modify frozen Array",
+		},
+		{
+			`fn() { let xs = freeze([1]); try { xs[0] += 1 } catch (e) { return e.message } }()`,
+			"cannot modify frozen Array",
+		},
+		{
+			`fn() { const h = freeze({}); try { h["a"] = 1 } catch (e) { return e.type } }()`,
+			"TypeError",
+		},
+		{
+			`fn() { try { freeze(1) } catch (e) { return e.message } }()`,
+			"argument to `freeze` must be Array or Hash, got Integer",
+		},
+	}
+
+	runVMTests(t, tests)
+	runVMTestsAgainstEval(t, tests)
+}
+
 func TestClosures(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -765,6 +1730,262 @@ func TestClosures(t *testing.T) {
 	runVMTests(t, tests)
 }
 
+func TestAssignmentToCapturedVariables(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let newCounter = fn() {
+				let count = 0;
+				fn() { count += 1; count };
+			};
+			let counter = newCounter();
+			counter();
+			counter();
+			counter();
+			`,
+			want: 3,
+		},
+		{
+			input: `
+			let newCounter = fn() {
+				let count = 0;
+				fn() { count += 1; count };
+			};
+			let a = newCounter();
+			let b = newCounter();
+			a();
+			a();
+			b();
+			[a(), b()];
+			`,
+			want: []int{3, 2},
+		},
+		{
+			input: `
+			let newAccount = fn(balance) {
+				let deposit = fn(self, n) { balance += n };
+				let withdraw = fn(self, n) { balance -= n };
+				let get = fn(self) { balance };
+				{"deposit": deposit, "withdraw": withdraw, "get": get};
+			};
+			let account = newAccount(100);
+			account.deposit(50);
+			account.withdraw(30);
+			account.get();
+			`,
+			want: 120,
+		},
+		{
+			input: `
+			let accumulate = fn(xs) {
+				let sum = 0;
+				let add = fn(x) { sum = sum + x };
+				for (x in xs) { add(x) }
+				sum;
+			};
+			accumulate([1, 2, 3, 4]);
+			`,
+			want: 10,
+		},
+		{
+			input: `
+			let memoize = fn(f) {
+				let memo = {};
+				fn(n) {
+					if (memo[n] == nil) {
+						memo[n] = f(n);
+					}
+					memo[n];
+				};
+			};
+			let calls = 0;
+			let square = memoize(fn(n) { calls += 1; n * n });
+			square(3);
+			square(3);
+			square(4);
+			[square(3), square(4), calls];
+			`,
+			want: []int{9, 16, 2},
+		},
+		{
+			input: `
+			let outer = fn() {
+				let x = 1;
+				let middle = fn() {
+					fn() { x = x * 10 };
+				};
+				let inner = middle();
+				inner();
+				inner();
+				x;
+			};
+			outer();
+			`,
+			want: 100,
+		},
+		{
+			input: `
+			let f = fn() {
+				let x = 1;
+				let get = fn() { x };
+				x = 2;
+				get();
+			};
+			f();
+			`,
+			want: 2,
+		},
+		{
+			input: `
+			let total = 0;
+			let add = fn(n) { total += n };
+			add(5);
+			add(6);
+			total;
+			`,
+			want: 11,
+		},
+		{
+			input: `
+			let f = fn(a, b) {
+				let swap = fn() { [a, b] = [b, a] };
+				swap();
+				[a, b];
+			};
+			f(1, 2);
+			`,
+			want: []int{2, 1},
+		},
+		// A function assigning to its own name assigns to the variable it is bound to
+		{input: `let f = fn() { f = 1 }; f(); f`, want: 1},
+		{input: `let f = fn() { f = 2; f }; f()`, want: 2},
+		{input: `fn() { let f = fn() { f = 3 }; f(); f }()`, want: 3},
+		{input: `let f = fn() { let g = fn() { f = 4 }; g() }; f(); f`, want: 4},
+		{input: `let f = fn() { [f] = [5] }; f(); f`, want: 5},
+	}
+
+	runVMTests(t, tests)
+	runVMTestsAgainstEval(t, tests)
+}
+
+func TestBlockScopes(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; if (true) { let x = 2; }; x", 1},
+		{"let x = 1; let y = if (true) { let x = 2; x }; x + y", 3},
+		{"let e = 1; try { throw 2 } catch (e) { e }; e", 1},
+		{
+			input: `
+			let i = 10;
+			for (let i = 0; i < 3; i++) {}
+			i
+			`,
+			want: 10,
+		},
+		{
+			input: `
+			let f = fn() {
+				let a = 0;
+				if (true) { let b = 1; a += b }
+				if (true) { let c = 10; a += c }
+				a;
+			};
+			f();
+			`,
+			want: 11,
+		},
+		{
+			input: `
+			let f = fn(x) {
+				let y = if (x > 0) { let a = x * 2; a } else { let b = -x; b };
+				let c = 5;
+				y + c;
+			};
+			[f(1), f(-3)];
+			`,
+			want: []int{7, 8},
+		},
+		{
+			input: `
+			let f = fn() {
+				let fns = [];
+				for (x in [1, 2, 3]) { fns = push(fns, fn() { x }) }
+				let out = [];
+				for (g in fns) { out = push(out, g()) }
+				out;
+			};
+			f();
+			`,
+			want: []int{1, 2, 3},
+		},
+		{
+			input: `
+			let f = fn() {
+				let fns = [];
+				let i = 0;
+				while (i < 3) { let j = i; fns = push(fns, fn() { j }); i += 1 }
+				[fns[0](), fns[1](), fns[2]()];
+			};
+			f();
+			`,
+			want: []int{0, 1, 2},
+		},
+		{
+			input: `
+			let out = [];
+			let f = fn() {
+				let x = 1;
+				try { let x = 2; return x } finally { out = push(out, x) }
+			};
+			[f(), out[0]];
+			`,
+			want: []int{2, 1},
+		},
+		// The blocks at the top level bind their variables anew on each pass, as in functions
+		{
+			input: `
+			let fs = [];
+			let i = 0;
+			while (i < 3) { let y = i; fs = push(fs, fn() { y }); i++ }
+			[fs[0](), fs[1](), fs[2]()];
+			`,
+			want: []int{0, 1, 2},
+		},
+		{
+			input: `
+			let fs = [];
+			for (let i = 0; i < 3; i++) { let y = i * 2; fs = push(fs, fn() { y += 1; y }) }
+			[fs[0](), fs[0](), fs[2]()];
+			`,
+			want: []int{1, 2, 5},
+		},
+		{
+			input: `
+			let fs = [];
+			let i = 0;
+			while (i < 2) {
+				let g = fn() { h() };
+				let h = fn() { i };
+				fs = push(fs, g);
+				i++;
+			}
+			[fs[0](), fs[1]()];
+			`,
+			want: []int{2, 2},
+		},
+		{
+			input: `
+			let r = if (true) { let a = 1; let f = fn() { a }; a = 2; f() };
+			let s = if (true) { let b = 3; b };
+			[r, s];
+			`,
+			want: []int{2, 3},
+		},
+	}
+
+	runVMTests(t, tests)
+	runVMTestsAgainstEval(t, tests)
+}
+
 func TestRecursiveFunctions(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -815,6 +2036,86 @@ func TestRecursiveFunctions(t *testing.T) {
 	runVMTests(t, tests)
 }
 
+func TestMutuallyRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+			let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+			isEven(10) && isOdd(7);
+			`,
+			want: true,
+		},
+		{
+			input: `
+			let parity = fn(x) {
+				let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+				let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+				isEven(x);
+			};
+			parity(4) && !parity(5);
+			`,
+			want: true,
+		},
+		{
+			input: `
+			let f = fn(n) {
+				if (n > 0) {
+					let ping = fn(i) { if (i == 0) { 0 } else { pong(i - 1) + 1 } };
+					let pong = fn(i) { if (i == 0) { 0 } else { ping(i - 1) + 10 } };
+					ping(n);
+				} else {
+					0
+				}
+			};
+			f(4);
+			`,
+			want: 22,
+		},
+		{
+			input: `
+			let f = fn() {
+				let out = [];
+				for (i in [1, 2]) {
+					let get = fn() { value() };
+					let value = fn() { i };
+					out = push(out, get);
+				}
+				[out[0](), out[1]()];
+			};
+			f();
+			`,
+			want: []int{1, 2},
+		},
+		{
+			input: `
+			let countDown = fn(n) {
+				let next = fn() { countDown(n - 1) };
+				if (n == 0) { 0 } else { next() }
+			};
+			countDown(3);
+			`,
+			want: 0,
+		},
+		{
+			input: `
+			let outer = fn() {
+				let countDown = fn(n) {
+					let next = || countDown(n - 1);
+					if (n == 0) { 0 } else { next() }
+				};
+				countDown(3);
+			};
+			outer();
+			`,
+			want: 0,
+		},
+	}
+
+	runVMTests(t, tests)
+	runVMTestsAgainstEval(t, tests)
+}
+
 func TestRecursiveFibonacci(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -863,6 +2164,35 @@ func runVMTests(t *testing.T, tests []vmTestCase) {
 	}
 }
 
+// runVMTestsAgainstEval checks that the evaluator, which has reference semantics for
+// environments, gives the same results as the vm.
+func runVMTestsAgainstEval(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		complr := compiler.New()
+		if err := complr.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(complr.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		evaluated := eval.Eval(program, object.NewEnvironment())
+		// A program ending with a statement which is not an expression has no value in eval
+		if evaluated == nil {
+			evaluated = eval.NilValue
+		}
+		want := evaluated.Inspect()
+		if got := vm.LastPoppedStackElem().Inspect(); got != want {
+			t.Errorf("vm and eval disagree. vm=%s, eval=%s", got, want)
+		}
+	}
+}
+
 func runVMTestErrors(t *testing.T, tests []string) {
 	t.Helper()
 